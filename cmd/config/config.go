@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/config"
+)
+
+func NewCommand(cli cliutil.CLI, cfg *config.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Print the resolved configuration and where each value comes from",
+		Long: `Print the resolved configuration. The precedence is:
+
+  CLI flag > environment variable > config file > built-in default
+
+The config file is read from $CDEBUG_CONFIG or ~/.cdebug/config.yaml.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfig(cli, cmd.Root(), cfg)
+		},
+	}
+
+	return cmd
+}
+
+func runConfig(cli cliutil.CLI, root *cobra.Command, cfg *config.Config) error {
+	cli.PrintAux("# Config file: %s\n", config.Path())
+
+	w := tabwriter.NewWriter(cli.OutputStream(), 0, 4, 2, ' ', 0)
+	for _, field := range cfg.Fields() {
+		flag := config.Lookup(root, field.Key)
+		if flag == nil {
+			continue
+		}
+
+		// Some env vars (e.g., $KUBECONFIG) aren't flag defaults but are
+		// read by the client libraries, hence the value from the env.
+		value, source := flag.DefValue, "default"
+		if env, envValue := field.EnvValue(); env != "" {
+			value, source = envValue, "env $"+env
+		} else if field.Value != "" {
+			source = "config file"
+		}
+
+		fmt.Fprintf(w, "%s: %q\t# %s\n", field.Key, value, source)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,130 @@
+package exec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/iximiuz/cdebug/pkg/ldd"
+)
+
+// Binaries copied with --copy-binary (and their shared libraries) end up in
+// the debugger's rootfs under this dir: wrapper scripts in the dir itself,
+// the actual binaries in libexec/, and the libraries in lib/.
+const binariesDir = "/.cdebug-bin"
+
+// The wrapper runs the binary with the bundled dynamic loader, so it works
+// even if the target (in the chroot mode) has no or an incompatible libc.
+// The shebang is the toolkit's shell - in the chroot mode, the entrypoint
+// rewrites it to the shell's path under $CDEBUG_ROOTFS.
+const binaryWrapperTemplate = `#!/bin/sh
+CDEBUG_BIN="${CDEBUG_ROOTFS%%/}%s"
+exec %s"$CDEBUG_BIN/libexec/%s" "$@"
+`
+
+// stageBinaries lays out the --copy-binary files in a temporary host dir
+// and returns the dir (to be removed by the caller) and the specs to copy
+// its content to the debugger container.
+func stageBinaries(specs []string) (string, []copySpec, error) {
+	stageDir, err := os.MkdirTemp("", "cdebug-bin-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	binDir := filepath.Join(stageDir, path.Base(binariesDir))
+	copies := []copySpec{{hostPath: binDir, containerPath: binariesDir}}
+
+	for _, spec := range specs {
+		hostPath, containerPath, _ := strings.Cut(spec, ":")
+		if hostPath == "" {
+			return stageDir, nil, fmt.Errorf("invalid --copy-binary value %q: host path must not be empty", spec)
+		}
+		if containerPath != "" && !path.IsAbs(containerPath) {
+			return stageDir, nil, fmt.Errorf("invalid --copy-binary value %q: container path must be absolute", spec)
+		}
+
+		name := filepath.Base(hostPath)
+		if err := copyFile(hostPath, filepath.Join(binDir, "libexec", name)); err != nil {
+			return stageDir, nil, fmt.Errorf("cannot copy binary %s: %w", hostPath, err)
+		}
+
+		libs, err := ldd.ResolveDependencies(hostPath)
+		if err != nil {
+			return stageDir, nil, fmt.Errorf("cannot resolve %s dependencies: %w", hostPath, err)
+		}
+		for _, lib := range libs {
+			if err := copyFile(lib, filepath.Join(binDir, "lib", filepath.Base(lib))); err != nil {
+				return stageDir, nil, fmt.Errorf("cannot copy library %s: %w", lib, err)
+			}
+		}
+
+		interp, err := ldd.Interpreter(hostPath)
+		if err != nil {
+			return stageDir, nil, err
+		}
+		if interp != "" {
+			interp = fmt.Sprintf(`"$CDEBUG_BIN/lib/%s" --library-path "$CDEBUG_BIN/lib" `, filepath.Base(interp))
+		}
+
+		wrapper := filepath.Join(binDir, name)
+		if err := os.WriteFile(
+			wrapper,
+			[]byte(fmt.Sprintf(binaryWrapperTemplate, binariesDir, interp, name)),
+			0o755,
+		); err != nil {
+			return stageDir, nil, err
+		}
+
+		if containerPath != "" {
+			copies = append(copies, copySpec{hostPath: wrapper, containerPath: containerPath})
+		}
+	}
+
+	return stageDir, copies, nil
+}
+
+// binaryWrappers returns the (shell-quoted) debugger's paths of the
+// --copy-binary wrappers, including the copies at the custom paths.
+func binaryWrappers(specs []string) []string {
+	var wrappers []string
+	for _, spec := range specs {
+		hostPath, containerPath, _ := strings.Cut(spec, ":")
+		wrappers = append(wrappers, shellquote(path.Join(binariesDir, filepath.Base(hostPath))))
+		if containerPath != "" {
+			wrappers = append(wrappers, shellquote(containerPath))
+		}
+	}
+	return wrappers
+}
+
+// copyFile copies the file following symlinks (libraries are often symlinked).
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,232 @@
+package exec
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/docker/docker/api/types/image"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/iximiuz/cdebug/pkg/completion"
+	"github.com/iximiuz/cdebug/pkg/containerd"
+	"github.com/iximiuz/cdebug/pkg/docker"
+	ckubernetes "github.com/iximiuz/cdebug/pkg/kubernetes"
+)
+
+type completionFunc func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective)
+
+func completeTarget(opts *options) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), completion.Timeout)
+		defer cancel()
+
+		for _, schema := range []string{schemaKubeLong, schemaKubeShort} {
+			if strings.HasPrefix(toComplete, schema) {
+				pods, err := listPodNames(ctx, opts)
+				if err != nil {
+					return nil, cobra.ShellCompDirectiveNoFileComp
+				}
+
+				var comps []string
+				for _, pod := range pods {
+					comps = append(comps, schema+pod)
+				}
+				return comps, cobra.ShellCompDirectiveNoFileComp
+			}
+		}
+
+		for _, schema := range []string{schemaContainerd, schemaNerdctl} {
+			if strings.HasPrefix(toComplete, schema) {
+				comps, err := listContainerdTargets(ctx, opts, schema)
+				if err != nil {
+					return nil, cobra.ShellCompDirectiveNoFileComp
+				}
+				return comps, cobra.ShellCompDirectiveNoFileComp
+			}
+		}
+
+		return completion.DockerContainerNames(ctx, opts.runtime)
+	}
+}
+
+func listContainerdTargets(ctx context.Context, opts *options, schema string) ([]string, error) {
+	client, err := containerd.NewClient(containerd.Options{
+		Address:   opts.runtime,
+		Namespace: opts.namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ctx = namespaces.WithNamespace(ctx, client.Namespace())
+
+	containers, err := client.ListRunningContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var comps []string
+	for _, c := range containers {
+		if name := c.Labels["nerdctl/name"]; schema == schemaNerdctl && name != "" {
+			comps = append(comps, schema+name)
+		} else {
+			comps = append(comps, schema+c.ID)
+		}
+	}
+	return comps, nil
+}
+
+func completeNamespace(opts *options) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 && !isKubernetesTarget(args[0]) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), completion.Timeout)
+		defer cancel()
+
+		client, _, err := newKubernetesClient(opts)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		var comps []string
+		for _, ns := range namespaces.Items {
+			comps = append(comps, ns.Name)
+		}
+		return comps, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func completeImage(opts *options) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		ctx, cancel := context.WithTimeout(context.Background(), completion.Timeout)
+		defer cancel()
+
+		client, err := docker.NewClient(docker.Options{Host: opts.runtime})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		defer client.Close()
+
+		images, err := client.ImageList(ctx, image.ListOptions{})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		var comps []string
+		for _, img := range images {
+			for _, tag := range img.RepoTags {
+				if tag != "<none>:<none>" {
+					comps = append(comps, tag)
+				}
+			}
+		}
+		return comps, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeLabelSelector suggests the label keys (of the last selector
+// term) found on the running Docker containers.
+func completeLabelSelector(opts *options) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if strings.Contains(toComplete[strings.LastIndex(toComplete, ",")+1:], "=") {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), completion.Timeout)
+		defer cancel()
+
+		client, err := docker.NewClient(docker.Options{Host: opts.runtime})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		defer client.Close()
+
+		containers, err := client.ListRunningContainers(ctx)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		prefix := toComplete[:strings.LastIndex(toComplete, ",")+1]
+		seen := map[string]bool{}
+
+		var comps []string
+		for _, c := range containers {
+			for key := range c.Labels {
+				if !seen[key] {
+					seen[key] = true
+					comps = append(comps, prefix+key)
+				}
+			}
+		}
+		sort.Strings(comps)
+		return comps, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+}
+
+func listPodNames(ctx context.Context, opts *options) ([]string, error) {
+	client, namespace, err := newKubernetesClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	return names, nil
+}
+
+func newKubernetesClient(opts *options) (kubernetes.Interface, string, error) {
+	config, namespace, err := ckubernetes.GetRESTConfig(
+		opts.runtime,
+		opts.kubeconfig,
+		opts.kubeconfigContext,
+		opts.saTokenFile,
+		opts.saCAFile,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opts.namespace != "" {
+		namespace = opts.namespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	return client, namespace, nil
+}
+
+func isKubernetesTarget(target string) bool {
+	return strings.HasPrefix(target, schemaKubeLong) ||
+		strings.HasPrefix(target, schemaKubeShort) ||
+		strings.HasPrefix(target, "pod/") ||
+		strings.HasPrefix(target, "pods/")
+}
@@ -3,30 +3,83 @@ package exec
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"os"
+	osexec "os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/distribution/reference"
+	units "github.com/docker/go-units"
+	mobysignal "github.com/moby/sys/signal"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/ioutil"
+	"github.com/iximiuz/cdebug/pkg/jsonutil"
 	"github.com/iximiuz/cdebug/pkg/kubernetes"
+	"github.com/iximiuz/cdebug/pkg/schema"
+	"github.com/iximiuz/cdebug/pkg/uuid"
 )
 
 const (
-	defaultToolkitImage = "docker.io/library/busybox:musl"
+	defaultToolkitImage        = "docker.io/library/busybox:musl"
+	defaultWindowsToolkitImage = "mcr.microsoft.com/windows/nanoserver:ltsc2022"
 
-	schemaContainerd = "containerd://"
-	schemaDocker     = "docker://"
-	schemaKubeCRI    = "cri://"
-	schemaKubeLong   = "kubernetes://"
-	schemaKubeShort  = "k8s://"
-	schemaNerdctl    = "nerdctl://"
-	schemaPodman     = "podman://"
-	schemaOCI        = "oci://" // runc, crun, etc.
+	// Builds images with the packages listed in the image path (--package).
+	nixeryRegistry = "nixery.dev"
+
+	// Default --network value - join the target's network namespace.
+	networkContainer = "container"
+	networkHost      = "host"
+	networkBridge    = "bridge" // Docker's default network
+
+	// Allowed --pid values.
+	pidContainer = "container"
+	pidHost      = "host"
+	pidNone      = "none"
+
+	// Allowed --ipc values.
+	ipcContainer = "container"
+	ipcHost      = "host"
+	ipcPrivate   = "private"
+
+	// Where the stopped target's rootfs is mounted in the --rootfs mode.
+	stoppedTargetRootfs = "/target-rootfs"
+
+	// Where the writable copy of the target's rootfs is mounted in the --snapshot mode.
+	snapshotTargetRootfs = "/target-snapshot"
+
+	// Where the host's root filesystem is mounted in the --host-rootfs mode.
+	hostRootfsMountpoint = "/host"
+
+	// The --oci-runtime value to run the debugger with the target's runtime.
+	ociRuntimeTarget = "target"
+
+	schemaContainerd = schema.Containerd
+	schemaDocker     = schema.Docker
+	schemaKubeCRI    = schema.KubeCRI
+	schemaKubeLong   = schema.KubeLong
+	schemaKubeShort  = schema.KubeShort
+	schemaNerdctl    = schema.Nerdctl
+	schemaPodman     = schema.Podman
+	schemaOCI        = schema.OCI
 
 	exampleText = `
   # Start a %s shell in the Docker container:
@@ -39,9 +92,15 @@ const (
   # Use a different debugging toolkit image:
   cdebug exec -it --image=alpine mycontainer
 
+  # Start the debugger with ash (--cmd-shell) and get a bash session (COMMAND):
+  cdebug exec -it --image=bash --cmd-shell=ash mycontainer bash
+
   # Use a nixery.dev image (https://nixery.dev/):
   cdebug exec -it --image=nixery.dev/shell/vim/ps/tshark mycontainer
 
+  # Same, but let cdebug compose the nixery.dev image path:
+  cdebug exec -it -P vim -P ps -P tshark mycontainer
+
   # Exec into a containerd container:
   cdebug exec -it containerd://mycontainer ...
   cdebug exec --namespace myns -it containerd://mycontainer ...
@@ -58,6 +117,14 @@ const (
   cdebug exec -it pod/mypod/mycontainer`
 )
 
+// The --preload-image flag given without a value.
+const preloadToolkitImage = "<image>"
+
+const (
+	notFoundRetries      = 5
+	notFoundRetryBackoff = 100 * time.Millisecond
+)
+
 var (
 	errTargetNotFound = errors.New("target container not found")
 
@@ -73,28 +140,155 @@ func errCannotCreate(cause error) error {
 }
 
 type options struct {
-	target     string
-	schema     string
-	name       string
-	image      string
-	tty        bool
-	stdin      bool
-	detach     bool
-	cmd        []string
-	user       string
-	privileged bool
-	autoRemove bool
-	quiet      bool
-
-	runtime   string
-	platform  string
-	namespace string
+	target       string
+	schema       string
+	name         string
+	image        string
+	entrypoint   string
+	cmdShell     string
+	tty          bool
+	stdin        bool
+	detach       bool
+	cmd          []string
+	user         string
+	execUser     string
+	execInTarget bool
+	privileged   bool
+	ptrace       bool
+	capInherit   bool
+	autoRemove   bool
+	quiet        bool
+
+	// Extra nixery.dev packages (--package).
+	packages       []string
+	noShellPackage bool
+
+	memoryLimit string
+	memory      int64
+	cpuQuota    int64
+
+	shmSizeLimit string
+	shmSize      int64
+
+	execTimeout            time.Duration
+	timeoutKillSignal      string
+	timeoutKillGracePeriod time.Duration
+
+	waitFor        int
+	waitForTimeout time.Duration
+
+	imagePullTimeout time.Duration
+
+	chrootPath string
+
+	initScriptFile string
+	initScript     string
+
+	imageCache    string
+	cacheImage    bool
+	preloadImages []string
+
+	overrideEnv []string
+
+	forwardAgent bool
+	// The host's $SSH_AUTH_SOCK (--forward-agent).
+	sshAuthSock string
+
+	hostRootfs bool
+
+	network      string
+	networkAlias string
+	dns          []string
+	dnsSearch    []string
+	addHosts     []string
+	hostNetwork  bool
+	shareNet     bool
+	pid          string
+	ipc          string
+	volumesFrom  string
+	shareMounts  bool
+	cgroup       bool
+	cgroupParent string
+
+	volumes      []string
+	volumeDriver string
+	volumeOpts   []string
+	noCleanup    bool
+
+	teeFile string
+	tee     *ioutil.TimestampedTee
+
+	logFile string
+
+	onExitCmds []string
+
+	stdinPrompt         string
+	stdinEcho           bool
+	ttyCols             uint
+	ttyRows             uint
+	attachStdinOnSignal string
+
+	rootfs       bool
+	snapshot     bool
+	keepSnapshot bool
+	// Set when the debugger gets a (stopped or snapshotted) target's rootfs
+	// mounted instead of reaching it via /proc/<pid>/root.
+	targetRootfs string
+
+	// Set via the global --output flag.
+	outputFormat string
+
+	// To tell the explicitly set flags from the defaults.
+	flags *pflag.FlagSet
+
+	allMatching string
+	scriptFile  string
+	reportFile  string
+	parallel    int
+
+	labelSelector string
+	all           bool
+
+	// Called with the debugger's exit code when the session ends.
+	onExit func(targetID string, code int)
+
+	runtime     string
+	platform    string
+	imageOS     string
+	imageArch   string
+	namespace   string
+	snapshotter string
+	ociRuntime  string
+	usernsRemap string
+
+	allowOverlappingMounts bool
 
 	kubeconfig        string
 	kubeconfigContext string
 
+	saTokenFile string
+	saCAFile    string
+
+	serviceAccount         string
+	ephemeralContainerName string
+
+	shareProcesses bool
+	forceRestart   bool
+
 	override     string
 	overrideType kubernetes.OverrideType
+
+	annotations []string
+
+	copyFrom []string
+	copyTo   []string
+
+	copyBinaries []string
+	binaryCopies []copySpec
+
+	chrootBinaries []string
+
+	init bool
 }
 
 func NewCommand(cli cliutil.CLI) *cobra.Command {
@@ -104,29 +298,78 @@ func NewCommand(cli cliutil.CLI) *cobra.Command {
 		Use:     "exec [OPTIONS] [schema://][POD][CONTAINER] [COMMAND] [ARG...]",
 		Short:   "Start a debugger shell in the target container or pod.",
 		Example: fmt.Sprintf(exampleText[1:], strings.TrimPrefix(defaultToolkitImage, "docker.io/library/")),
-		Args:    cobra.MinimumNArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if opts.cacheImage || opts.allMatching != "" || len(opts.preloadImages) > 0 {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			if opts.labelSelector != "" {
+				return nil // All args are the COMMAND.
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+
+		ValidArgsFunction: completeTarget(&opts),
+
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if !opts.stdin {
 				opts.quiet = true
 			}
 			cli.SetQuiet(opts.quiet)
 
+			// The flag is defined on the root command (if at all).
+			opts.outputFormat, _ = cmd.Flags().GetString("output")
+			opts.flags = cmd.Flags()
+
 			if err := cli.InputStream().CheckTty(opts.stdin, opts.tty); err != nil {
 				return cliutil.WrapStatusError(err)
 			}
 
-			opts.target = args[0]
-			if len(args) > 1 {
-				opts.cmd = args[1:]
+			if opts.labelSelector != "" {
+				opts.cmd = args
+			} else {
+				if len(args) > 0 {
+					opts.target = args[0]
+				}
+				if len(args) > 1 {
+					opts.cmd = args[1:]
+				}
+			}
+
+			opts.schema, opts.target = schema.Parse(opts.target)
+
+			if opts.ephemeralContainerName != "" {
+				if opts.schema != schemaKubeLong && opts.schema != schemaKubeShort {
+					return cliutil.WrapStatusError(errors.New("the --ephemeral-container-name flag is supported only for Kubernetes runtime"))
+				}
+				if opts.name != "" && opts.name != opts.ephemeralContainerName {
+					return cliutil.WrapStatusError(errors.New("the --ephemeral-container-name and --name flags cannot have different values"))
+				}
+				opts.name = opts.ephemeralContainerName
 			}
 
-			if sep := strings.Index(opts.target, "://"); sep != -1 {
-				opts.schema = opts.target[:sep+3]
-				opts.target = opts.target[sep+3:]
-			} else if strings.HasPrefix(opts.target, "pod/") || strings.HasPrefix(opts.target, "pods/") {
-				opts.schema = schemaKubeLong
-			} else {
-				opts.schema = schemaDocker
+			if opts.imageOS != "" || opts.imageArch != "" {
+				if opts.platform != "" {
+					cli.PrintErr("Warning: --platform is set, ignoring --image-os and --image-arch\n")
+				} else {
+					platform, err := composePlatform(opts.imageOS, opts.imageArch)
+					if err != nil {
+						return cliutil.WrapStatusError(err)
+					}
+					opts.platform = platform
+				}
+			}
+
+			if len(opts.packages) > 0 {
+				if cmd.Flags().Changed("image") && !isNixeryImage(opts.image) {
+					return cliutil.WrapStatusError(errors.New("the --package flag can only be used with a nixery.dev --image"))
+				}
+				base := nixeryRegistry
+				if isNixeryImage(opts.image) {
+					base = opts.image
+				}
+				opts.image = nixeryImage(base, opts.packages, !opts.noShellPackage)
+			} else if opts.noShellPackage {
+				return cliutil.WrapStatusError(errors.New("the --no-shell-package flag requires the --package flag"))
 			}
 
 			if !reference.ReferenceRegexp.MatchString(opts.image) {
@@ -136,12 +379,361 @@ func NewCommand(cli cliutil.CLI) *cobra.Command {
 				)
 			}
 
+			if opts.cacheImage {
+				return cliutil.WrapStatusError(runCacheImage(context.Background(), cli, &opts))
+			}
+
+			if len(opts.preloadImages) > 0 {
+				if opts.target != "" {
+					return cliutil.WrapStatusError(errors.New("the --preload-image flag doesn't take a target (only an optional \"schema://\" to choose the runtime)"))
+				}
+				return cliutil.WrapStatusError(
+					runPreloadImages(context.Background(), cli, &opts, preloadImageList(&opts)),
+				)
+			}
+
+			if cmd.Flags().Changed("cmd-shell") {
+				if cmd.Flags().Changed("entrypoint") {
+					return cliutil.WrapStatusError(errors.New("only one of --cmd-shell and --entrypoint can be provided"))
+				}
+				if !cmdShellRegexp.MatchString(opts.cmdShell) {
+					return cliutil.WrapStatusError(fmt.Errorf("invalid --cmd-shell value %q (must be a shell's basename, e.g., ash or bash)", opts.cmdShell))
+				}
+				opts.entrypoint = opts.cmdShell
+			}
+
+			if cmd.Flags().Changed("context") && cmd.Flags().Changed("kubeconfig-context") {
+				return cliutil.WrapStatusError(errors.New("only one of --context and --kubeconfig-context can be provided"))
+			}
+
+			if opts.waitFor < 0 || opts.waitFor > 65535 {
+				return cliutil.WrapStatusError(errors.New("the --wait-for value must be a valid TCP port"))
+			}
+
+			if opts.cgroupParent != "" {
+				if opts.cgroup {
+					return cliutil.WrapStatusError(errors.New("the --cgroup-parent flag cannot be used with the --cgroup flag"))
+				}
+				if err := validateCgroupParent(opts.cgroupParent); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+
+			if opts.capInherit && (opts.privileged || opts.ptrace) {
+				return cliutil.WrapStatusError(errors.New("the --cap-inherit flag cannot be used with the --privileged or --ptrace flags"))
+			}
+
+			if opts.keepSnapshot && !opts.snapshot {
+				return cliutil.WrapStatusError(errors.New("the --keep-snapshot flag requires the --snapshot flag"))
+			}
+
 			if opts.tty && !opts.stdin {
 				return cliutil.WrapStatusError(errors.New("the -t/--tty flag requires the -i/--stdin flag"))
 			}
 
+			if opts.ttyCols > 0 || opts.ttyRows > 0 {
+				if !opts.tty {
+					return cliutil.WrapStatusError(errors.New("the --tty-cols and --tty-rows flags require the -t/--tty flag"))
+				}
+				if opts.ttyCols == 0 || opts.ttyRows == 0 {
+					return cliutil.WrapStatusError(errors.New("the --tty-cols and --tty-rows flags must be used together"))
+				}
+				if opts.ttyCols > math.MaxUint16 || opts.ttyRows > math.MaxUint16 {
+					return cliutil.WrapStatusError(errors.New("the --tty-cols and --tty-rows values must not exceed 65535"))
+				}
+			}
+
+			if opts.stdinEcho && (!opts.stdin || opts.tty) {
+				return cliutil.WrapStatusError(errors.New("the --interactive-no-tty-echo flag requires the -i/--stdin flag without the -t/--tty flag"))
+			}
+
+			if opts.attachStdinOnSignal != "" {
+				if opts.schema != schemaDocker {
+					return cliutil.WrapStatusError(errors.New("the --attach-stdin-on-signal flag is supported only for Docker runtime"))
+				}
+				if opts.stdin || opts.detach {
+					return cliutil.WrapStatusError(errors.New("the --attach-stdin-on-signal flag cannot be used with the -i/--interactive or -d/--detach flags"))
+				}
+				if _, err := mobysignal.ParseSignal(opts.attachStdinOnSignal); err != nil {
+					return cliutil.WrapStatusError(fmt.Errorf("bad --attach-stdin-on-signal value: %w", err))
+				}
+			}
+
+			if opts.execInTarget {
+				if opts.schema != schemaDocker {
+					return cliutil.WrapStatusError(errors.New("the --exec-in-target flag is supported only for Docker runtime"))
+				}
+				if opts.detach {
+					return cliutil.WrapStatusError(errors.New("the --exec-in-target flag cannot be used with the -d/--detach flag"))
+				}
+			}
+
+			if opts.execUser != "" {
+				if err := validateUserFlag(opts.execUser); err != nil {
+					return cliutil.WrapStatusError(fmt.Errorf("bad --exec-user value: %w", err))
+				}
+				if !isRootUser(opts.user) {
+					return cliutil.WrapStatusError(errors.New("the --exec-user flag requires the debugger to run as root (drop the --user flag)"))
+				}
+			}
+
+			if len(opts.volumes) > 0 || opts.volumeDriver != "" || len(opts.volumeOpts) > 0 {
+				if opts.schema != schemaDocker {
+					return cliutil.WrapStatusError(errors.New("the --volume, --volume-driver, and --volume-opt flags are supported only for Docker runtime"))
+				}
+				named := false
+				for _, spec := range opts.volumes {
+					source, _, _, err := parseVolumeSpec(spec)
+					if err != nil {
+						return cliutil.WrapStatusError(err)
+					}
+					named = named || isNamedVolume(source)
+				}
+				if (opts.volumeDriver != "" || len(opts.volumeOpts) > 0) && !named {
+					return cliutil.WrapStatusError(errors.New("the --volume-driver and --volume-opt flags require at least one named --volume"))
+				}
+				if _, err := parseVolumeOpts(opts.volumeOpts); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+
+			if len(opts.annotations) > 0 {
+				if opts.schema != schemaKubeLong && opts.schema != schemaKubeShort {
+					return cliutil.WrapStatusError(errors.New("the --annotation flag is supported only for Kubernetes runtime"))
+				}
+			}
+			if opts.schema == schemaKubeLong || opts.schema == schemaKubeShort {
+				if _, err := debuggerAnnotations(&opts); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+
+			if opts.all && opts.labelSelector == "" {
+				return cliutil.WrapStatusError(errors.New("the --all flag requires the --label-selector flag"))
+			}
+			if opts.labelSelector != "" {
+				if opts.schema != schemaDocker {
+					return cliutil.WrapStatusError(errors.New("the --label-selector flag is supported only for Docker runtime"))
+				}
+				if opts.allMatching != "" {
+					return cliutil.WrapStatusError(errors.New("the --label-selector flag cannot be used with the --all-matching flag"))
+				}
+				if opts.all {
+					// Batch mode over all the containers matching the selector.
+					opts.allMatching = "*"
+				}
+			}
+
+			if opts.allMatching != "" {
+				if opts.scriptFile == "" {
+					return cliutil.WrapStatusError(errors.New("the --all-matching flag requires the --script flag"))
+				}
+				if opts.stdin || opts.detach {
+					return cliutil.WrapStatusError(errors.New("the --all-matching flag cannot be used with the -i/--interactive or -d/--detach flags"))
+				}
+				if opts.parallel < 1 {
+					return cliutil.WrapStatusError(errors.New("the --parallel value must be at least 1"))
+				}
+			}
+
+			if opts.execTimeout != 0 && len(opts.cmd) == 0 && opts.allMatching == "" {
+				return cliutil.WrapStatusError(errors.New("the --exec-timeout flag requires a COMMAND to run"))
+			}
+			if opts.execTimeout < 0 || opts.execTimeout%time.Second != 0 {
+				return cliutil.WrapStatusError(errors.New("the --exec-timeout value must be a positive whole number of seconds"))
+			}
+			if opts.execTimeout == 0 && (cmd.Flags().Changed("timeout-kill-signal") || cmd.Flags().Changed("timeout-kill-grace-period")) {
+				return cliutil.WrapStatusError(errors.New("the --timeout-kill-signal and --timeout-kill-grace-period flags require --exec-timeout"))
+			}
+			if sig, err := timeoutSignal(opts.timeoutKillSignal); err != nil {
+				return cliutil.WrapStatusError(fmt.Errorf("bad --timeout-kill-signal value: %w", err))
+			} else {
+				opts.timeoutKillSignal = sig
+			}
+			if opts.timeoutKillGracePeriod < 0 || opts.timeoutKillGracePeriod%time.Second != 0 {
+				return cliutil.WrapStatusError(errors.New("the --timeout-kill-grace-period value must be a non-negative whole number of seconds"))
+			}
+
+			if opts.hostNetwork {
+				if opts.network != networkContainer && opts.network != networkHost {
+					return cliutil.WrapStatusError(errors.New("the --host-network flag cannot be used with the --network flag"))
+				}
+				opts.network = networkHost
+			}
+			if !opts.shareNet {
+				if opts.schema != schemaDocker {
+					return cliutil.WrapStatusError(errors.New("the --share-net=false flag is supported only for Docker runtime"))
+				}
+				if opts.network != networkContainer {
+					return cliutil.WrapStatusError(errors.New("the --share-net flag cannot be used with the --network or --host-network flags"))
+				}
+				opts.network = networkBridge
+			}
+			if opts.networkAlias != "" && (opts.network == networkHost || opts.network == "none") {
+				return cliutil.WrapStatusError(fmt.Errorf("the --network-alias flag cannot be used with --network %s", opts.network))
+			}
+			if opts.network == networkHost && opts.privileged {
+				cli.PrintErr("Warning: host network combined with --privileged gives the debugger full control over the host's network stack\n")
+			}
+
+			switch opts.pid {
+			case pidContainer, pidHost, pidNone:
+			default:
+				return cliutil.WrapStatusError(fmt.Errorf("invalid --pid value %q (must be one of container, host, none)", opts.pid))
+			}
+			if opts.init {
+				switch opts.pid {
+				case pidContainer:
+					// The debugger can be PID 1 only in its own PID namespace.
+					opts.pid = pidNone
+				case pidHost:
+					return cliutil.WrapStatusError(errors.New("the --init flag cannot be used with --pid host"))
+				}
+			}
+
+			if opts.memoryLimit != "" {
+				memory, err := units.RAMInBytes(opts.memoryLimit)
+				if err != nil || memory <= 0 {
+					return cliutil.WrapStatusError(fmt.Errorf("invalid --memory value %q", opts.memoryLimit))
+				}
+				opts.memory = memory
+			}
+			if opts.shmSizeLimit != "" {
+				shmSize, err := units.RAMInBytes(opts.shmSizeLimit)
+				if err != nil || shmSize <= 0 {
+					return cliutil.WrapStatusError(fmt.Errorf("invalid --shm-size value %q", opts.shmSizeLimit))
+				}
+				if opts.ipc == ipcContainer {
+					cli.PrintErr("Warning: --shm-size is ignored with --ipc container (the target's /dev/shm is shared)\n")
+				} else {
+					opts.shmSize = shmSize
+				}
+			}
+			if opts.cpuQuota < 0 {
+				return cliutil.WrapStatusError(errors.New("the --cpu-quota value must be a positive number of microseconds"))
+			}
+			if opts.privileged && opts.memory == 0 && opts.cpuQuota == 0 {
+				cli.PrintErr("Warning: a privileged debugger without --memory or --cpu-quota limits can starve the target's workload\n")
+			}
+
+			for _, spec := range opts.addHosts {
+				if _, _, err := parseAddHost(spec); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+			if opts.usernsRemap != "" && opts.usernsRemap != usernsRemapAuto {
+				if _, _, err := parseUsernsRemap(opts.usernsRemap); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+			for _, spec := range opts.overrideEnv {
+				if _, _, err := parseEnvOverride(spec); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+			if opts.shareMounts && opts.volumesFrom != "" {
+				return cliutil.WrapStatusError(errors.New("the --share-mounts and --volumes-from flags are mutually exclusive"))
+			}
+			if opts.hostRootfs {
+				if !opts.privileged {
+					return cliutil.WrapStatusError(errors.New("the --host-rootfs flag requires the --privileged flag"))
+				}
+				cli.PrintErr("Warning: --host-rootfs gives the debugger read-write access to the host's entire filesystem - anything done under /host happens on the host\n")
+			}
+			if opts.forwardAgent {
+				opts.sshAuthSock = os.Getenv("SSH_AUTH_SOCK")
+				if opts.sshAuthSock == "" {
+					return cliutil.WrapStatusError(errors.New("the --forward-agent flag requires $SSH_AUTH_SOCK to be set (is the SSH agent running?)"))
+				}
+			}
+			for _, ip := range opts.dns {
+				if net.ParseIP(ip) == nil {
+					return cliutil.WrapStatusError(fmt.Errorf("invalid --dns value %q (must be an IP address)", ip))
+				}
+			}
+
+			switch opts.ipc {
+			case "", ipcContainer, ipcHost, ipcPrivate:
+			default:
+				return cliutil.WrapStatusError(fmt.Errorf("invalid --ipc value %q (must be one of container, host, private)", opts.ipc))
+			}
+
+			if opts.chrootPath != "" {
+				if !path.IsAbs(opts.chrootPath) || strings.Contains(opts.chrootPath, "..") {
+					return cliutil.WrapStatusError(errors.New("the --chroot-path value must be an absolute path without '..'"))
+				}
+			}
+
+			if opts.initScriptFile != "" {
+				script, err := os.ReadFile(opts.initScriptFile)
+				if err != nil {
+					return cliutil.WrapStatusError(fmt.Errorf("cannot read init script: %w", err))
+				}
+				opts.initScript = string(script)
+			}
+
+			if opts.logFile != "" && opts.detach {
+				return cliutil.WrapStatusError(errors.New("the --log-file flag cannot be used with the -d/--detach flag"))
+			}
+
+			if len(opts.copyFrom) > 0 && opts.detach {
+				return cliutil.WrapStatusError(errors.New("the --copy-from flag cannot be used with the -d/--detach flag"))
+			}
+			for _, spec := range opts.copyFrom {
+				if _, err := parseCopyFromSpec(spec); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+			for _, spec := range opts.copyTo {
+				if _, err := parseCopyToSpec(spec); err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+			}
+
+			for _, p := range opts.chrootBinaries {
+				if !path.IsAbs(p) || strings.Contains(p, "..") || strings.ContainsAny(p, " \t\n'\"$`\\") {
+					return cliutil.WrapStatusError(fmt.Errorf("invalid --chroot-binary value %q (must be an absolute path without '..' and special characters)", p))
+				}
+			}
+
+			if len(opts.copyBinaries) > 0 {
+				stageDir, copies, err := stageBinaries(opts.copyBinaries)
+				defer os.RemoveAll(stageDir)
+				if err != nil {
+					return cliutil.WrapStatusError(err)
+				}
+				opts.binaryCopies = copies
+			}
+
+			if opts.teeFile != "" {
+				f, err := os.OpenFile(opts.teeFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+				if err != nil {
+					return cliutil.WrapStatusError(fmt.Errorf("cannot open --tee file: %w", err))
+				}
+				opts.tee = ioutil.NewTimestampedTee(io.Discard, f)
+				defer opts.tee.Close()
+			}
+
 			ctx := context.Background()
 
+			if opts.allMatching != "" {
+				return cliutil.WrapStatusError(runBatch(ctx, cli, &opts))
+			}
+
+			if len(opts.onExitCmds) > 0 {
+				if opts.detach {
+					return cliutil.WrapStatusError(errors.New("the --on-exit flag cannot be used with the -d/--detach flag"))
+				}
+
+				// The name has to be known upfront to be passed to the commands.
+				opts.name = debuggerName(opts.name)
+				opts.onExit = func(targetID string, code int) {
+					if err := runOnExitCommands(cli, &opts, targetID, code); err != nil {
+						cli.PrintErr("Warning: %s\n", err)
+					}
+				}
+			}
+
 			switch opts.schema {
 			case schemaContainerd, schemaNerdctl:
 				return cliutil.WrapStatusError(wrapExitError(runDebuggerContainerd(ctx, cli, &opts)))
@@ -180,8 +772,33 @@ func NewCommand(cli cliutil.CLI) *cobra.Command {
 	flags.StringVar(
 		&opts.image,
 		"image",
-		defaultToolkitImage,
-		`Debugging toolkit image (hint: use "busybox:musl" or "nixery.dev/shell/vim/ps/tool3/tool4/...")`,
+		cliutil.EnvOr("CDEBUG_DEFAULT_IMAGE", defaultToolkitImage),
+		`Debugging toolkit image (hint: use "busybox:musl" or "nixery.dev/shell/vim/ps/tool3/tool4/..."; can also be set via $CDEBUG_DEFAULT_IMAGE)`,
+	)
+	flags.StringArrayVarP(
+		&opts.packages,
+		"package",
+		"P",
+		nil,
+		`Use a nixery.dev toolkit image with the given package (can be repeated; e.g., -P vim -P strace results in nixery.dev/shell/strace/vim)`,
+	)
+	flags.BoolVar(
+		&opts.noShellPackage,
+		"no-shell-package",
+		false,
+		`Don't add the "shell" base package to the --package image path`,
+	)
+	flags.StringVar(
+		&opts.entrypoint,
+		"entrypoint",
+		"sh",
+		`Shell to run the debugger's setup script with as "<entrypoint> -c <script>" (use "" to keep the toolkit image's ENTRYPOINT and pass "-c <script>" to it)`,
+	)
+	flags.StringVar(
+		&opts.cmdShell,
+		"cmd-shell",
+		"sh",
+		`Shell (a basename, looked up in the toolkit image's $PATH) to run the debugger's setup script with, e.g., "ash" for busybox-only images. Unlike COMMAND, which is what the user gets (an interactive "sh" by default), it only affects how the debugger is started. Same as --entrypoint, but validated`,
 	)
 	flags.BoolVarP(
 		&opts.stdin,
@@ -197,12 +814,24 @@ func NewCommand(cli cliutil.CLI) *cobra.Command {
 		false,
 		`Allocate a pseudo-TTY (as in "docker exec -t")`,
 	)
+	flags.UintVar(
+		&opts.ttyCols,
+		"tty-cols",
+		0,
+		`Fixed width of the debugger's TTY instead of the auto-detected one (e.g., when running from a script; requires --tty-rows)`,
+	)
+	flags.UintVar(
+		&opts.ttyRows,
+		"tty-rows",
+		0,
+		`Fixed height of the debugger's TTY instead of the auto-detected one (e.g., when running from a script; requires --tty-cols)`,
+	)
 	flags.BoolVarP(
 		&opts.detach,
 		"detach",
 		"d",
 		false,
-		`Detached mode: execute the command in the background`,
+		`Detached mode: execute the command in the background and print the debugger container name`,
 	)
 	flags.StringVarP(
 		&opts.user,
@@ -212,133 +841,1363 @@ func NewCommand(cli cliutil.CLI) *cobra.Command {
 		`Run the debugger container as User (format: <name|uid>[:<group|gid>])`,
 	)
 	flags.BoolVar(
-		&opts.privileged,
-		"privileged",
-		false,
-		`God mode for the debugger container (as in "docker run --privileged")`,
-	)
-	flags.BoolVar(
-		&opts.autoRemove,
-		"rm",
+		&opts.execInTarget,
+		"exec-in-target",
 		false,
-		`Automatically remove the debugger container when it exits (as in "docker run --rm")`,
+		`[Docker only] Don't start a debugger container but run the COMMAND (or shell) right in the target via "docker exec" (the target must have the shell; privileged only with --privileged; the target's PID 1 keeps running)`,
 	)
-	flags.StringVarP(
-		&opts.namespace,
-		"namespace",
-		"n",
+	flags.StringVar(
+		&opts.execUser,
+		"exec-user",
 		"",
-		`Namespace (the final meaning of this parameter is runtime specific)`,
+		`Run only the debugger's shell (or COMMAND) as this user, after the setup has been done as root (format: <uid>[:<gid>]; requires su-exec, gosu, or a chroot with --userspec in the toolkit image)`,
+	)
+	flags.IntVar(
+		&opts.waitFor,
+		"wait-for",
+		0,
+		`Wait until the target accepts TCP connections on the given port before starting the debugger`,
+	)
+	flags.DurationVar(
+		&opts.waitForTimeout,
+		"wait-for-timeout",
+		time.Minute,
+		`How long to wait for the --wait-for port to open`,
+	)
+	flags.DurationVar(
+		&opts.execTimeout,
+		"exec-timeout",
+		0,
+		`Kill the COMMAND if it's still running after the given duration (the watchdog runs in the debugger, so the target needs no extra tools)`,
 	)
 	flags.StringVar(
-		&opts.runtime,
-		"runtime",
-		"",
-		`Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "https://<kube-api-addr>:8433/...)`,
+		&opts.timeoutKillSignal,
+		"timeout-kill-signal",
+		"SIGTERM",
+		`Signal to send to the COMMAND when --exec-timeout fires`,
+	)
+	flags.DurationVar(
+		&opts.timeoutKillGracePeriod,
+		"timeout-kill-grace-period",
+		5*time.Second,
+		`How long to wait after the --timeout-kill-signal for the COMMAND to exit before sending SIGKILL`,
 	)
 	flags.StringVar(
-		&opts.platform,
-		"platform",
+		&opts.chrootPath,
+		"chroot-path",
 		"",
-		`Platform (e.g., linux/amd64, linux/arm64) of the target container (for some runtimes it's hard to detect it automatically, but the debug sidecar must be of the same platform as the target)`,
+		`Directory to link the debugger's rootfs to in the target's rootfs in the chroot mode, or the target's rootfs to in the debugger in the simple mode (default is "/" and $HOME respectively)`,
 	)
 	flags.StringVar(
-		&opts.kubeconfig,
-		"kubeconfig",
+		&opts.initScriptFile,
+		"init-script",
 		"",
-		`Path to the kubeconfig file (default is $HOME/.kube/config)`,
+		`Shell script to run in the debugger container before the shell or COMMAND starts (the session is aborted if the script fails)`,
 	)
 	flags.StringVar(
-		&opts.kubeconfigContext,
-		"kubeconfig-context",
-		"",
-		`Name of the kubeconfig context to use`,
+		&opts.network,
+		"network",
+		networkContainer,
+		`[Docker only] Network for the debugger container ("container" to share the target's network namespace | "host" | "none" | <network-name>)`,
 	)
 	flags.StringVar(
-		&opts.override,
-		"override",
+		&opts.networkAlias,
+		"network-alias",
 		"",
-		`[Kubernetes only] An inline JSON override for the generated ephemeral container object. Example: '{ "env": [{ "name": "DEBUG", "value": "1" }] }'`,
+		`[Docker only] Attach the debugger to the target's (user-defined) network under this DNS alias, so the target can reach services running in the debugger (the debugger gets its own network namespace then)`,
 	)
-	flags.StringVar(
-		(*string)(&opts.overrideType),
-		"override-type",
-		string(kubernetes.DefaultOverrideType),
-		fmt.Sprintf(`[Kubernetes only] The method used to override the generated ephemeral container object: %s, %s, or %s.`,
+	flags.StringSliceVar(
+		&opts.dns,
+		"dns",
+		nil,
+		`Custom DNS server(s) for the debugger container (replace the target's nameservers in the debugger's /etc/resolv.conf; not supported in the chroot mode)`,
+	)
+	flags.StringSliceVar(
+		&opts.dnsSearch,
+		"dns-search",
+		nil,
+		`Custom DNS search domain(s) for the debugger container`,
+	)
+	flags.StringArrayVar(
+		&opts.addHosts,
+		"add-host",
+		nil,
+		`Add a HOSTNAME:IP entry to the debugger's /etc/hosts (can be repeated; not supported in the chroot mode for Docker and containerd)`,
+	)
+	flags.StringArrayVar(
+		&opts.overrideEnv,
+		"override-env",
+		nil,
+		`Copy the target's environment to the debugger replacing (or adding) the given KEY=VALUE variable (can be repeated)`,
+	)
+	flags.BoolVar(
+		&opts.forwardAgent,
+		"forward-agent",
+		false,
+		`Forward the host's SSH agent ($SSH_AUTH_SOCK) into the debugger (cdebug must run on the container host)`,
+	)
+	flags.BoolVar(
+		&opts.hostRootfs,
+		"host-rootfs",
+		false,
+		`Mount the host's root filesystem (/proc/1/root) at /host in the debugger (requires --privileged; disables the chroot mode - the target's rootfs stays at $CDEBUG_ROOTFS; combine with --pid host for a "kubectl debug node/"-like experience)`,
+	)
+	flags.BoolVar(
+		&opts.hostNetwork,
+		"host-network",
+		false,
+		`Use the host's network namespace instead of the target's one (a shorthand for --network host that also works for containerd)`,
+	)
+	flags.BoolVar(
+		&opts.shareNet,
+		"share-net",
+		true,
+		`[Docker only] Share the target's network namespace (--share-net=false puts the debugger on the default bridge network instead)`,
+	)
+	flags.StringVar(
+		&opts.pid,
+		"pid",
+		pidContainer,
+		`PID namespace for the debugger container ("container" to share the target's PID namespace | "host" | "none")`,
+	)
+	flags.BoolVar(
+		&opts.init,
+		"init",
+		false,
+		`Run the debugger under an init process (tini from the toolkit image or a shell-based fallback) as PID 1 in a new PID namespace (implies --pid none)`,
+	)
+	flags.StringVar(
+		&opts.ipc,
+		"ipc",
+		"",
+		`IPC namespace for the debugger container ("container" to share the target's IPC namespace | "host" | "private"; default is the target's one for containerd and a private one for Docker)`,
+	)
+	flags.StringVar(
+		&opts.volumesFrom,
+		"volumes-from",
+		"",
+		`Mount the volumes of the given container (usually, the target) into the debugger container (as in "docker run --volumes-from")`,
+	)
+	flags.StringArrayVarP(
+		&opts.volumes,
+		"volume",
+		"v",
+		nil,
+		`[Docker only] Mount a named volume or a host path into the debugger container (format: <name|/host/path>:/container/path[:ro], can be repeated)`,
+	)
+	flags.StringVar(
+		&opts.volumeDriver,
+		"volume-driver",
+		"",
+		`[Docker only] Volume driver for the named --volume(s) that don't exist yet (e.g., an NFS or Ceph plugin)`,
+	)
+	flags.StringArrayVar(
+		&opts.volumeOpts,
+		"volume-opt",
+		nil,
+		`[Docker only] Driver-specific option KEY=VALUE for the named --volume(s) that don't exist yet (can be repeated)`,
+	)
+	flags.BoolVar(
+		&opts.noCleanup,
+		"no-cleanup",
+		false,
+		`[Docker only] Keep the named volumes cdebug created for the debugger (by default, they are removed together with the --rm debugger)`,
+	)
+	flags.BoolVar(
+		&opts.shareMounts,
+		"share-mounts",
+		false,
+		`Replicate the target's mounts (volumes, bind mounts, config maps, secrets) in the debugger container, except for /proc, /sys, /dev, and the /etc/{hosts,hostname,resolv.conf} files`,
+	)
+	flags.BoolVar(
+		&opts.cgroup,
+		"cgroup",
+		false,
+		`Put the debugger into a child cgroup of the target's one, so that the target's resource limits apply to both (not supported with the systemd cgroup driver)`,
+	)
+	flags.StringVar(
+		&opts.cgroupParent,
+		"cgroup-parent",
+		"",
+		`[Docker and containerd only] Cgroup (relative to /sys/fs/cgroup, or a systemd slice) to create the debugger's cgroup under; --memory and --cpu-quota apply within it`,
+	)
+	flags.StringVar(
+		&opts.teeFile,
+		"tee",
+		"",
+		`Append a timestamped transcript of the session I/O to the given file`,
+	)
+	flags.BoolVar(
+		&opts.rootfs,
+		"rootfs",
+		false,
+		`If the target is stopped, start the debugger with the target's rootfs mounted read-only at `+stoppedTargetRootfs+` (Docker: cdebug must run on the Docker host)`,
+	)
+	flags.BoolVar(
+		&opts.snapshot,
+		"snapshot",
+		false,
+		`[Docker and containerd only] Debug a writable copy of the target's rootfs (mounted at `+snapshotTargetRootfs+`) so that no changes reach the live container (cdebug must run on the runtime's host)`,
+	)
+	flags.BoolVar(
+		&opts.keepSnapshot,
+		"keep-snapshot",
+		false,
+		`Don't remove the --snapshot copy of the target's rootfs after the session (for post-mortem analysis)`,
+	)
+	flags.StringVar(
+		&opts.stdinPrompt,
+		"stdin-prompt",
+		"",
+		`Print this message once the debugger is ready to read stdin in the -i mode without a TTY (e.g., "cdebug> ")`,
+	)
+	flags.BoolVar(
+		&opts.stdinEcho,
+		"interactive-no-tty-echo",
+		false,
+		`Echo the piped input back to the local output in the -i mode without a TTY (e.g., to see the commands interleaved with their output; a terminal echoes the input itself)`,
+	)
+	flags.StringVar(
+		&opts.attachStdinOnSignal,
+		"attach-stdin-on-signal",
+		"",
+		`[Docker only] Don't attach stdin right away but only once cdebug receives the given signal (e.g., "kill -USR1 <cdebug-pid>")`,
+	)
+	flags.StringVar(
+		&opts.logFile,
+		"log-file",
+		"",
+		`Write the debugger container's output to the given file after it exits (regardless of --quiet)`,
+	)
+	flags.StringArrayVar(
+		&opts.onExitCmds,
+		"on-exit",
+		nil,
+		`Run a host command (with sh -c) after the debugger exits; $CDEBUG_EXIT_CODE, $CDEBUG_TARGET_ID, and $CDEBUG_DEBUGGER_NAME are set (can be repeated, the commands run sequentially)`,
+	)
+	flags.BoolVar(
+		&opts.privileged,
+		"privileged",
+		false,
+		`God mode for the debugger container (as in "docker run --privileged")`,
+	)
+	flags.BoolVar(
+		&opts.ptrace,
+		"ptrace",
+		false,
+		`Allow the debugger to trace the target's processes with strace, gdb, perf, etc. (a shorthand for adding the SYS_PTRACE capability)`,
+	)
+	flags.BoolVar(
+		&opts.capInherit,
+		"cap-inherit",
+		false,
+		`Give the debugger exactly the target's capabilities - no more, no less (containerd: all five capability sets are copied)`,
+	)
+	flags.StringVar(
+		&opts.memoryLimit,
+		"memory",
+		"",
+		`Memory limit for the debugger container (e.g., 256m, 1g; default is no limit)`,
+	)
+	flags.Int64Var(
+		&opts.cpuQuota,
+		"cpu-quota",
+		0,
+		`CPU CFS quota for the debugger container in microseconds per 100ms period (e.g., 50000 = half a CPU; default is no limit)`,
+	)
+	flags.StringVar(
+		&opts.shmSizeLimit,
+		"shm-size",
+		"",
+		`Size of /dev/shm in the debugger container (e.g., 256m, 1g; default is the runtime's default, usually 64m)`,
+	)
+	flags.BoolVar(
+		&opts.autoRemove,
+		"rm",
+		false,
+		`Automatically remove the debugger container when it exits (as in "docker run --rm")`,
+	)
+	flags.StringVarP(
+		&opts.namespace,
+		"namespace",
+		"n",
+		os.Getenv("CDEBUG_NAMESPACE"),
+		`Namespace (the final meaning of this parameter is runtime specific; can also be set via $CDEBUG_NAMESPACE)`,
+	)
+	flags.StringVar(
+		&opts.runtime,
+		"runtime",
+		os.Getenv("CDEBUG_RUNTIME"),
+		`Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "https://<kube-api-addr>:8433/..."; can also be set via $CDEBUG_RUNTIME)`,
+	)
+	flags.StringVar(
+		&opts.platform,
+		"platform",
+		os.Getenv("CDEBUG_PLATFORM"),
+		`Platform (e.g., linux/amd64, linux/arm64) of the target container (for some runtimes it's hard to detect it automatically, but the debug sidecar must be of the same platform as the target; can also be set via $CDEBUG_PLATFORM)`,
+	)
+	flags.StringVar(
+		&opts.imageOS,
+		"image-os",
+		"",
+		`OS part of the --platform (e.g., linux, windows; default is linux)`,
+	)
+	flags.StringVar(
+		&opts.imageArch,
+		"image-arch",
+		"",
+		`Architecture part of the --platform (e.g., amd64, arm64; default is the architecture cdebug runs on)`,
+	)
+	flags.StringVar(
+		&opts.snapshotter,
+		"snapshotter",
+		"",
+		`[containerd only] Snapshotter to use for the debugger container (e.g., overlayfs, fuse-overlayfs, devmapper, zfs, btrfs; default is the daemon's default snapshotter)`,
+	)
+	flags.StringVar(
+		&opts.ociRuntime,
+		"oci-runtime",
+		"",
+		`[containerd only] Runtime to run the debugger container with (e.g., io.containerd.runc.v2, io.containerd.runsc.v1, or "target" to use the target's runtime; default is the daemon's default runtime)`,
+	)
+	flags.StringVar(
+		&opts.usernsRemap,
+		"userns-remap",
+		"",
+		`[containerd only] Run the debugger in the target's user namespace (e.g., rootless containerd) with the UID_SHIFT:GID_SHIFT mapping ("auto" or no value to read it from the target's /proc/<pid>/uid_map and gid_map)`,
+	)
+	flags.Lookup("userns-remap").NoOptDefVal = usernsRemapAuto
+	flags.BoolVar(
+		&opts.allowOverlappingMounts,
+		"allow-overlapping-mounts",
+		false,
+		`[containerd only] Start the debugger even if some of its mounts (--volumes-from, --dns, --add-host, etc.) shadow each other`,
+	)
+	flags.StringVar(
+		&opts.kubeconfig,
+		"kubeconfig",
+		"",
+		`Path to the kubeconfig file (default is $HOME/.kube/config)`,
+	)
+	flags.StringVar(
+		&opts.kubeconfigContext,
+		"kubeconfig-context",
+		kubernetes.DefaultContext(),
+		`Name of the kubeconfig context to use (can also be set via $KUBECONTEXT or $KUBECTL_CONTEXT)`,
+	)
+	flags.StringVar(
+		&opts.kubeconfigContext,
+		"context",
+		kubernetes.DefaultContext(),
+		`Name of the kubeconfig context to use (same as --kubeconfig-context)`,
+	)
+	flags.StringVar(
+		&opts.saTokenFile,
+		"service-account-token",
+		os.Getenv("CDEBUG_SA_TOKEN_FILE"),
+		`[Kubernetes only] Path to the service account token file (can also be set via $CDEBUG_SA_TOKEN_FILE)`,
+	)
+	flags.StringVar(
+		&opts.saCAFile,
+		"service-account-ca",
+		os.Getenv("CDEBUG_SA_CA_FILE"),
+		`[Kubernetes only] Path to the cluster CA certificate file (can also be set via $CDEBUG_SA_CA_FILE)`,
+	)
+	flags.StringVar(
+		&opts.serviceAccount,
+		"service-account",
+		"",
+		`[Kubernetes only] Run the debugger under this service account (since ephemeral containers always inherit the pod's service account, a separate debug pod is created on the target's node instead)`,
+	)
+	flags.StringVar(
+		&opts.ephemeralContainerName,
+		"ephemeral-container-name",
+		"",
+		`[Kubernetes only] Deterministic name for the ephemeral debugger container (same as --name; if the pod already has a running one with this name, cdebug attaches to it instead of adding a new one)`,
+	)
+	flags.BoolVar(
+		&opts.shareProcesses,
+		"share-processes",
+		false,
+		`[Kubernetes only] Make the processes of all the pod's containers visible to the debugger (requires shareProcessNamespace: true on the pod)`,
+	)
+	flags.BoolVar(
+		&opts.forceRestart,
+		"force-restart",
+		false,
+		`[Kubernetes only] Allow --share-processes to delete and recreate the target pod with shareProcessNamespace: true (destructive!)`,
+	)
+	flags.DurationVar(
+		&opts.imagePullTimeout,
+		"image-pull-timeout",
+		2*time.Minute,
+		`[Kubernetes only] How long to keep waiting for the debugger image while the kubelet reports ErrImagePull or ImagePullBackOff`,
+	)
+	flags.StringVar(
+		&opts.override,
+		"override",
+		"",
+		`[Docker and Kubernetes only] An inline JSON override for the generated debugger container (the ephemeral container object for Kubernetes, {"config": ..., "hostConfig": ...} for Docker). Example: '{ "env": [{ "name": "DEBUG", "value": "1" }] }'. Ephemeral containers have no metadata, so "metadata.annotations" from a merge or strategic override are added to the target pod instead, with the "<debugger-name>.debugger.cdebug.io/" key prefix (the '/' in the original key is replaced with '_')`,
+	)
+	flags.StringArrayVar(
+		&opts.annotations,
+		"annotation",
+		nil,
+		`[Kubernetes only] Annotate the debugger with KEY=VALUE (can be repeated). Ephemeral containers have no metadata, so the annotations are added to the target pod instead, with the "<debugger-name>.debugger.cdebug.io/" key prefix (as with --override)`,
+	)
+	flags.StringVar(
+		(*string)(&opts.overrideType),
+		"override-type",
+		string(kubernetes.DefaultOverrideType),
+		fmt.Sprintf(`[Docker and Kubernetes only] The method used to override the generated debugger container: %s, %s, or %s.`,
 			kubernetes.OverrideTypeJSON, kubernetes.OverrideTypeMerge, kubernetes.OverrideTypeStrategic,
 		),
 	)
+	flags.StringArrayVar(
+		&opts.copyFrom,
+		"copy-from",
+		nil,
+		`Copy a file or directory to the host after the debugger exits (format: CONTAINER_PATH[:HOST_PATH], can be repeated; [Kubernetes] copied from the target container using tar)`,
+	)
+	flags.StringArrayVar(
+		&opts.copyTo,
+		"copy-to",
+		nil,
+		`Copy a file or directory from the host to the debugger before it starts (format: HOST_PATH[:CONTAINER_PATH], can be repeated; in the chroot mode, the files can be found under $CDEBUG_ROOTFS)`,
+	)
+	flags.StringArrayVar(
+		&opts.copyBinaries,
+		"copy-binary",
+		nil,
+		`Copy a host binary with its shared libraries to the debugger and add it to the PATH (format: HOST_PATH[:CONTAINER_PATH], can be repeated)`,
+	)
+	flags.StringArrayVar(
+		&opts.chrootBinaries,
+		"chroot-binary",
+		nil,
+		`Make a file from the toolkit image available in the chroot at the same absolute path via a symlink, e.g., for LD_PRELOAD (can be repeated; existing target files are never replaced)`,
+	)
+
+	flags.StringVar(
+		&opts.imageCache,
+		"image-cache",
+		"",
+		`Directory to cache the pulled debugger images in (used as a fallback when the registry is unreachable)`,
+	)
+	flags.BoolVar(
+		&opts.cacheImage,
+		"cache-image",
+		false,
+		`Only pull the debugger image and save it to the --image-cache directory (no target is needed, but a "schema://" can be given to choose the runtime)`,
+	)
+
+	flags.StringSliceVar(
+		&opts.preloadImages,
+		"preload-image",
+		nil,
+		`Only pull the given images (in parallel) to warm up the runtime's image store; without a value, the --image is pulled (no target is needed, but a "schema://" can be given to choose the runtime)`,
+	)
+	flags.Lookup("preload-image").NoOptDefVal = preloadToolkitImage
+
+	flags.StringVar(
+		&opts.allMatching,
+		"all-matching",
+		"",
+		`Batch mode: run the --script in every running container whose name matches the glob (Docker and containerd only)`,
+	)
+	flags.StringVar(
+		&opts.labelSelector,
+		"label-selector",
+		"",
+		`[Docker only] Pick the target by labels instead of name/ID (KEY[=VALUE][,KEY[=VALUE]...]); all the positional args become the COMMAND`,
+	)
+	flags.BoolVar(
+		&opts.all,
+		"all",
+		false,
+		`Batch mode: run the --script in every running container matching the --label-selector`,
+	)
+	flags.StringVar(
+		&opts.scriptFile,
+		"script",
+		"",
+		`Batch mode: shell script to run in each matching container`,
+	)
+	flags.StringVar(
+		&opts.reportFile,
+		"report-file",
+		"",
+		`Batch mode: file to write the JSON report to (default is stdout)`,
+	)
+	flags.IntVar(
+		&opts.parallel,
+		"parallel",
+		4,
+		`Batch mode: how many containers to debug concurrently`,
+	)
+
+	flags.SetNormalizeFunc(func(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "pkg" {
+			name = "package"
+		}
+		return pflag.NormalizedName(name)
+	})
+
+	cmd.RegisterFlagCompletionFunc("namespace", completeNamespace(&opts))
+	cmd.RegisterFlagCompletionFunc("image", completeImage(&opts))
+	cmd.RegisterFlagCompletionFunc("label-selector", completeLabelSelector(&opts))
 
 	return cmd
 }
 
-func debuggerName(name string, runID string) string {
+type copySpec struct {
+	containerPath string
+	hostPath      string
+}
+
+// Allowed values:
+//
+//	CONTAINER_PATH - copy to the current working directory
+//	CONTAINER_PATH:HOST_PATH - copy to the specified host path
+func parseCopyFromSpec(spec string) (copySpec, error) {
+	containerPath, hostPath, _ := strings.Cut(spec, ":")
+	if !path.IsAbs(containerPath) {
+		return copySpec{}, fmt.Errorf("invalid --copy-from value %q: container path must be absolute", spec)
+	}
+	if hostPath == "" {
+		hostPath = "."
+	}
+
+	return copySpec{
+		containerPath: containerPath,
+		hostPath:      hostPath,
+	}, nil
+}
+
+// Allowed values:
+//
+//	HOST_PATH - copy to the root of the debugger's filesystem
+//	HOST_PATH:CONTAINER_PATH - copy to the specified debugger's path
+func parseCopyToSpec(spec string) (copySpec, error) {
+	hostPath, containerPath, _ := strings.Cut(spec, ":")
+	if hostPath == "" {
+		return copySpec{}, fmt.Errorf("invalid --copy-to value %q: host path must not be empty", spec)
+	}
+	if containerPath == "" {
+		containerPath = "/" + filepath.Base(hostPath)
+	}
+	if !path.IsAbs(containerPath) {
+		return copySpec{}, fmt.Errorf("invalid --copy-to value %q: container path must be absolute", spec)
+	}
+
+	return copySpec{
+		containerPath: containerPath,
+		hostPath:      hostPath,
+	}, nil
+}
+
+func copyToSpecs(opts *options) []copySpec {
+	var parsed []copySpec
+	for _, spec := range opts.copyTo {
+		// Already validated in NewCommand().
+		if p, err := parseCopyToSpec(spec); err == nil {
+			parsed = append(parsed, p)
+		}
+	}
+	return append(parsed, opts.binaryCopies...)
+}
+
+func copyFromSpecs(specs []string) []copySpec {
+	var parsed []copySpec
+	for _, spec := range specs {
+		// Already validated in NewCommand().
+		if p, err := parseCopyFromSpec(spec); err == nil {
+			parsed = append(parsed, p)
+		}
+	}
+	return parsed
+}
+
+type batchResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runBatch runs the --script non-interactively in every running container
+// matching the --all-matching glob and writes a per-container JSON report.
+func runBatch(ctx context.Context, cli cliutil.CLI, opts *options) error {
+	script, err := os.ReadFile(opts.scriptFile)
+	if err != nil {
+		return fmt.Errorf("cannot read script: %w", err)
+	}
+
+	var targets []string
+	switch opts.schema {
+	case schemaContainerd, schemaNerdctl:
+		targets, err = listTargetsContainerd(ctx, cli, opts)
+	case schemaDocker:
+		targets, err = listTargetsDocker(ctx, cli, opts)
+	default:
+		return fmt.Errorf("--all-matching flag is not supported for %s runtime", opts.schema)
+	}
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		if opts.labelSelector != "" {
+			return fmt.Errorf("no running containers match the label selector %q", opts.labelSelector)
+		}
+		return fmt.Errorf("no running containers match %q", opts.allMatching)
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, opts.parallel)
+		results = map[string]batchResult{}
+	)
+
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := runBatchTarget(ctx, opts, target, script)
+
+			mu.Lock()
+			results[target] = result
+			mu.Unlock()
+		}(target)
+	}
+	wg.Wait()
+
+	report := jsonutil.DumpIndent(results) + "\n"
+	if opts.reportFile == "" {
+		cli.PrintOut("%s", report)
+		return nil
+	}
+	return os.WriteFile(opts.reportFile, []byte(report), 0o644)
+}
+
+func runBatchTarget(ctx context.Context, opts *options, target string, script []byte) batchResult {
+	// The output streaming may outlive the debugger run function.
+	var stdout, stderr syncBuffer
+
+	tcli := cliutil.NewCLI(io.NopCloser(strings.NewReader("")), &stdout, &stderr)
+	tcli.SetQuiet(true)
+
+	result := batchResult{ExitCode: -1}
+
+	topts := *opts
+	topts.target = target
+	topts.name = debuggerName("") + "-" + uuid.ShortID()
+	topts.autoRemove = true
+	// Base64 keeps the script intact through the entrypoint's shell quoting.
+	topts.cmd = []string{"sh", "-c", "echo " + base64.StdEncoding.EncodeToString(script) + " | base64 -d | sh"}
+	topts.onExit = func(_ string, code int) {
+		result.ExitCode = code
+	}
+
+	var err error
+	switch opts.schema {
+	case schemaContainerd, schemaNerdctl:
+		err = runDebuggerContainerd(ctx, tcli, &topts)
+	default:
+		err = runDebuggerDocker(ctx, tcli, &topts)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// teeStreams makes the session I/O recorded to the --tee transcript (if any).
+func teeStreams(opts *options, in io.Reader, out, errOut io.Writer) (io.Reader, io.Writer, io.Writer) {
+	if opts.tee == nil {
+		return in, out, errOut
+	}
+
+	if in != nil {
+		in = opts.tee.Reader(in)
+	}
+	if out != nil {
+		out = opts.tee.Fork(out)
+	}
+	if errOut != nil {
+		errOut = opts.tee.Fork(errOut)
+	}
+	return in, out, errOut
+}
+
+// echoStdin echoes the input back to the local output in the -i mode
+// without a TTY (--interactive-no-tty-echo). A terminal echoes the
+// keystrokes itself, so only the piped input needs it.
+func echoStdin(cli cliutil.CLI, opts *options, in io.Reader) io.Reader {
+	if !opts.stdinEcho || in == nil || cli.InputStream().IsTerminal() {
+		return in
+	}
+	return io.TeeReader(in, cli.OutputStream())
+}
+
+// debuggerInfo is the machine-readable description of a started
+// debugger container printed in the --output json mode.
+type debuggerInfo struct {
+	Name    string `json:"name"`
+	ID      string `json:"id,omitempty"`
+	Image   string `json:"image"`
+	Target  string `json:"target"`
+	Runtime string `json:"runtime"`
+}
+
+// formatOutput serializes the debugger info merging the runtime-specific
+// additional fields into the resulting JSON object.
+func formatOutput(info debuggerInfo, additionalFields map[string]any) string {
+	fields := map[string]any{}
+	if err := json.Unmarshal([]byte(jsonutil.Dump(info)), &fields); err != nil {
+		panic(err)
+	}
+	for k, v := range additionalFields {
+		fields[k] = v
+	}
+	return jsonutil.Dump(fields)
+}
+
+func printDebuggerInfo(
+	cli cliutil.CLI,
+	opts *options,
+	info debuggerInfo,
+	additionalFields map[string]any,
+) {
+	if opts.outputFormat != "json" {
+		return
+	}
+
+	info.Image = opts.image
+	info.Target = opts.target
+	info.Runtime = strings.TrimSuffix(opts.schema, "://")
+	cli.PrintOut("%s\n", formatOutput(info, additionalFields))
+}
+
+// printDetachedDebugger prints the name of the debugger started with -d
+// (as "docker run -d" does) so that scripts can pick it up from stdout.
+func printDetachedDebugger(cli cliutil.CLI, opts *options, name string) {
+	if opts.outputFormat == "json" {
+		return // Already printed as part of the debugger info.
+	}
+	cli.PrintOut("%s\n", name)
+}
+
+// stdinPrompt returns the message letting the user know that a non-TTY
+// interactive session is ready (there is no shell prompt in this mode).
+func stdinPrompt(opts *options) string {
+	if opts.stdin && !opts.tty {
+		return opts.stdinPrompt
+	}
+	return ""
+}
+
+// debuggerResolvConf renders the debugger's resolv.conf for --dns and
+// --dns-search on top of the target's one: the nameservers and the search
+// domains are replaced only if the corresponding flag is set.
+func debuggerResolvConf(base []byte, dns []string, dnsSearch []string) []byte {
+	var b bytes.Buffer
+	for _, line := range strings.Split(string(base), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if len(dns) > 0 {
+				continue
+			}
+		case "search", "domain":
+			if len(dnsSearch) > 0 {
+				continue
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+
+	for _, ns := range dns {
+		b.WriteString("nameserver " + ns + "\n")
+	}
+	if len(dnsSearch) > 0 {
+		b.WriteString("search " + strings.Join(dnsSearch, " ") + "\n")
+	}
+
+	return b.Bytes()
+}
+
+// debuggerHosts appends the --add-host entries to the hosts file.
+func debuggerHosts(base []byte, addHosts []string) []byte {
+	var b bytes.Buffer
+	b.Write(base)
+	if len(base) > 0 && !bytes.HasSuffix(base, []byte("\n")) {
+		b.WriteString("\n")
+	}
+	for _, spec := range addHosts {
+		host, ip, _ := parseAddHost(spec)
+		b.WriteString(ip + "\t" + host + "\n")
+	}
+	return b.Bytes()
+}
+
+// parseAddHost parses a --add-host value (HOSTNAME:IP, the IP can be v6).
+func parseAddHost(spec string) (string, string, error) {
+	host, ip, ok := strings.Cut(spec, ":")
+	if !ok || host == "" || net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid --add-host value %q (must be HOSTNAME:IP)", spec)
+	}
+	return host, ip, nil
+}
+
+// addHostsScript is the --add-host counterpart for the runtimes where the
+// debugger's /etc/hosts cannot be replaced (prepended to the entrypoint).
+func addHostsScript(addHosts []string) string {
+	var script strings.Builder
+	for _, spec := range addHosts {
+		host, ip, _ := parseAddHost(spec)
+		fmt.Fprintf(&script, "echo %s >> /etc/hosts\n", shellquote(ip+"\t"+host))
+	}
+	return script.String()
+}
+
+// writeDebuggerFile saves a generated file (resolv.conf, hosts, etc.)
+// to be bind-mounted into the debugger container and returns its path.
+func writeDebuggerFile(pattern string, content []byte) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// The debugger may run as a non-root user.
+	if err := f.Chmod(0644); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func parseEnvOverride(spec string) (string, string, error) {
+	key, value, ok := strings.Cut(spec, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid --override-env value %q (must be KEY=VALUE)", spec)
+	}
+	return key, value, nil
+}
+
+// parseVolumeSpec parses the --volume <name|/host/path>:/container/path[:ro] flag.
+func parseVolumeSpec(spec string) (source string, dest string, readOnly bool, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) == 3 {
+		if parts[2] != "ro" && parts[2] != "rw" {
+			return "", "", false, fmt.Errorf("invalid --volume mode %q (must be ro or rw)", parts[2])
+		}
+		readOnly = parts[2] == "ro"
+		parts = parts[:2]
+	}
+	if len(parts) != 2 || parts[0] == "" || !path.IsAbs(parts[1]) {
+		return "", "", false, fmt.Errorf("invalid --volume value %q (must be <name|/host/path>:/container/path[:ro])", spec)
+	}
+	if strings.HasPrefix(parts[0], ".") {
+		return "", "", false, fmt.Errorf("invalid --volume value %q (host paths must be absolute)", spec)
+	}
+	return parts[0], parts[1], readOnly, nil
+}
+
+// isNamedVolume tells a named volume apart from a host path (as Docker does).
+func isNamedVolume(source string) bool {
+	return !path.IsAbs(source)
+}
+
+// parseVolumeOpts parses the --volume-opt KEY=VALUE flags.
+func parseVolumeOpts(specs []string) (map[string]string, error) {
+	opts := map[string]string{}
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --volume-opt value %q (must be KEY=VALUE)", spec)
+		}
+		opts[key] = value
+	}
+	return opts, nil
+}
+
+// mergeTargetEnv applies the --override-env values on top of the target's
+// environment (in the KEY=VALUE form). The target's $PATH is dropped
+// because it likely points to directories missing in the debugger's image.
+func mergeTargetEnv(targetEnv []string, overrides []string) []string {
+	values := map[string]string{}
+	for _, spec := range overrides {
+		key, value, _ := parseEnvOverride(spec)
+		values[key] = value
+	}
+
+	var merged []string
+	for _, kv := range targetEnv {
+		key, _, _ := strings.Cut(kv, "=")
+		if key == "PATH" {
+			continue
+		}
+		if value, ok := values[key]; ok {
+			kv = key + "=" + value
+			delete(values, key)
+		}
+		merged = append(merged, kv)
+	}
+
+	for _, spec := range overrides {
+		key, _, _ := parseEnvOverride(spec)
+		if value, ok := values[key]; ok {
+			merged = append(merged, key+"="+value)
+			delete(values, key)
+		}
+	}
+
+	return merged
+}
+
+// The GOOS and GOARCH values (as in "go tool dist list") - OCI platforms use the same names.
+var (
+	knownOS = []string{
+		"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios", "js",
+		"linux", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows",
+	}
+	knownArch = []string{
+		"386", "amd64", "arm", "arm64", "loong64", "mips", "mips64", "mips64le",
+		"mipsle", "ppc64", "ppc64le", "riscv64", "s390x", "wasm",
+	}
+)
+
+func isNixeryImage(image string) bool {
+	return image == nixeryRegistry || strings.HasPrefix(image, nixeryRegistry+"/")
+}
+
+// nixeryImage adds the packages to the nixery.dev image path. The packages
+// are sorted and deduplicated (so that the same set always results in the
+// same, cacheable image), with the "shell" base package going first (but
+// after the "arm64" architecture meta-package, which must be the first one).
+func nixeryImage(base string, packages []string, shell bool) string {
+	var (
+		arm64 bool
+		pkgs  []string
+	)
+	for _, p := range append(strings.Split(base, "/")[1:], packages...) {
+		switch p {
+		case "", "shell":
+		case "arm64":
+			arm64 = true
+		default:
+			pkgs = append(pkgs, p)
+		}
+	}
+	slices.Sort(pkgs)
+	pkgs = slices.Compact(pkgs)
+
+	if shell {
+		pkgs = append([]string{"shell"}, pkgs...)
+	}
+	if arm64 {
+		pkgs = append([]string{"arm64"}, pkgs...)
+	}
+	return strings.Join(append([]string{nixeryRegistry}, pkgs...), "/")
+}
+
+// composePlatform turns --image-os and --image-arch into a --platform value.
+func composePlatform(os string, arch string) (string, error) {
+	if os == "" {
+		os = "linux"
+	}
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+
+	if !slices.Contains(knownOS, os) {
+		return "", fmt.Errorf("invalid --image-os value %q (must be one of %s)", os, strings.Join(knownOS, ", "))
+	}
+	if !slices.Contains(knownArch, arch) {
+		return "", fmt.Errorf("invalid --image-arch value %q (must be one of %s)", arch, strings.Join(knownArch, ", "))
+	}
+	return os + "/" + arch, nil
+}
+
+// preloadImageList resolves the --preload-image values (the bare flag
+// stands for the --image one).
+func preloadImageList(opts *options) []string {
+	var images []string
+	for _, image := range opts.preloadImages {
+		if image == preloadToolkitImage {
+			image = opts.image
+		}
+		if !slices.Contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+const waitForInterval = 200 * time.Millisecond
+
+// waitForPort polls the probe until it reports the --wait-for port open
+// or the --wait-for-timeout elapses.
+func waitForPort(ctx context.Context, cli cliutil.CLI, opts *options, probe func() bool) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.waitForTimeout)
+	defer cancel()
+
+	cli.PrintAux("Waiting for the target's port %d to open", opts.waitFor)
+	for {
+		if probe() {
+			cli.PrintAux(" done\n")
+			return nil
+		}
+		cli.PrintAux(".")
+
+		select {
+		case <-ctx.Done():
+			cli.PrintAux("\n")
+			return fmt.Errorf("target's port %d didn't open within %s", opts.waitFor, opts.waitForTimeout)
+		case <-time.After(waitForInterval):
+		}
+	}
+}
+
+func dialProbe(host string, port int) func() bool {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	return func() bool {
+		conn, err := net.DialTimeout("tcp", addr, waitForInterval)
+		if err != nil {
+			logrus.Debugf("Dialing %s failed: %s", addr, err)
+			return false
+		}
+		conn.Close()
+		return true
+	}
+}
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// validateCgroupParent checks that the --cgroup-parent exists (in the unified
+// hierarchy or in any of the v1 controllers). The systemd slices are created
+// on demand, and the check is skipped when cdebug runs on a host without
+// cgroups (e.g., macOS with a remote runtime).
+func validateCgroupParent(parent string) error {
+	if isSystemdSlice(parent) {
+		return nil
+	}
+	if _, err := os.Stat(cgroupRoot); err != nil {
+		return nil
+	}
+
+	candidates := []string{filepath.Join(cgroupRoot, parent)}
+	if matches, err := filepath.Glob(filepath.Join(cgroupRoot, "*", parent)); err == nil {
+		candidates = append(candidates, matches...)
+	}
+	for _, dir := range candidates {
+		if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+			return nil
+		}
+	}
+	return fmt.Errorf("--cgroup-parent %q doesn't exist under %s", parent, cgroupRoot)
+}
+
+func isSystemdSlice(cgroup string) bool {
+	return strings.HasSuffix(cgroup, ".slice")
+}
+
+// runOnExitCommands runs the --on-exit commands one by one (stopping
+// at the first failure) with the session details in the environment.
+func runOnExitCommands(cli cliutil.CLI, opts *options, targetID string, code int) error {
+	env := append(os.Environ(),
+		"CDEBUG_EXIT_CODE="+strconv.Itoa(code),
+		"CDEBUG_TARGET_ID="+targetID,
+		"CDEBUG_DEBUGGER_NAME="+opts.name,
+	)
+
+	for _, command := range opts.onExitCmds {
+		c := osexec.Command("sh", "-c", command)
+		c.Env = env
+		c.Stdout = cli.OutputStream()
+		c.Stderr = cli.ErrorStream()
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("--on-exit command %q failed: %w", command, err)
+		}
+	}
+	return nil
+}
+
+// cleanupSnapshot removes the --snapshot copy of the target's rootfs unless
+// it's requested to be kept (or still needed by a detached debugger).
+func cleanupSnapshot(cli cliutil.CLI, opts *options, dir string) {
+	if dir == "" {
+		return
+	}
+	if opts.keepSnapshot || opts.detach {
+		cli.PrintAux("The snapshot of the target's rootfs is kept at %s\n", dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		cli.PrintErr("Warning: cannot remove the snapshot of the target's rootfs: %s\n", err)
+	}
+}
+
+// cleanupExportedRootfs removes the exported rootfs of a stopped target
+// unless it's still needed by a detached debugger.
+func cleanupExportedRootfs(cli cliutil.CLI, opts *options, dir string) {
+	if dir == "" {
+		return
+	}
+	if opts.detach {
+		cli.PrintAux("The exported rootfs of the target is kept at %s (remove it when the debugger is gone)\n", dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		cli.PrintErr("Warning: cannot remove the exported rootfs of the target: %s\n", err)
+	}
+}
+
+// debuggerOutlivesSession reports whether the debugger container has to be
+// kept after it exits (to copy files or logs from it).
+func debuggerOutlivesSession(opts *options) bool {
+	return len(opts.copyFrom) > 0 || opts.logFile != ""
+}
+
+func debuggerName(name string) string {
 	if len(name) > 0 {
 		return name
 	}
-	return "cdebug-" + runID
+	return uuid.PseudorandomName()
 }
 
 var (
-	simpleEntrypoint = template.Must(template.New("user-entrypoint").Parse(`
+	entrypointSnippets = template.Must(template.New("snippets").Parse(`
+{{ define "init" }}
+{{ if .InitScript }}
+cat > /.cdebug-init.sh <<'CDEBUG_INIT_EOF'
+{{ .InitScript }}
+CDEBUG_INIT_EOF
+
+if ! sh /.cdebug-init.sh; then
+  echo "cdebug: init script failed" >&2
+  exit 1
+fi
+{{ end }}
+{{ end }}
+
+{{ define "privdrop" }}
+{{ if .ExecUser }}
+if command -v su-exec >/dev/null 2>&1; then
+  exec su-exec {{ .ExecUser }} {{ .Cmd }}
+elif command -v gosu >/dev/null 2>&1; then
+  exec gosu {{ .ExecUser }} {{ .Cmd }}
+fi
+
+# su needs a user name (and always uses the user's primary group).
+CDEBUG_EXEC_USER=$(awk -F: -v uid={{ .ExecUID }} '$3 == uid { print $1; exit }' /etc/passwd)
+if [ -z "${CDEBUG_EXEC_USER}" ]; then
+  echo "cdebug: --exec-user requires su-exec or gosu in the debugger image (or a user with UID {{ .ExecUID }} in its /etc/passwd)" >&2
+  exit 1
+fi
+exec su -s /bin/sh -c {{ .QuotedCmd }} "${CDEBUG_EXEC_USER}"
+{{ end }}
+{{ end }}
+
+{{ define "chroot-privdrop" }}
+{{ if .ExecUser }}
+if chroot --help 2>&1 | grep -q userspec; then
+  CDEBUG_CHROOT_OPTS="--userspec={{ .ExecUser }}"
+elif command -v su-exec >/dev/null 2>&1; then
+  CDEBUG_PRIVDROP="$CDEBUG_ROOTFS$(command -v su-exec) {{ .ExecUser }}"
+elif command -v gosu >/dev/null 2>&1; then
+  CDEBUG_PRIVDROP="$CDEBUG_ROOTFS$(command -v gosu) {{ .ExecUser }}"
+else
+  echo "cdebug: --exec-user in the chroot mode requires chroot --userspec, su-exec, or gosu in the debugger image" >&2
+  exit 1
+fi
+{{ end }}
+{{ end }}
+
+{{ define "watchdog" }}
+{{ if .ExecTimeout }}
+# Must be followed by an exec - the COMMAND takes over this shell's PID.
+(
+  sleep {{ .ExecTimeout }}
+  kill -{{ .ExecTimeoutSignal }} $$
+{{- if .ExecTimeoutKillAfter }}
+  sleep {{ .ExecTimeoutKillAfter }}
+  kill -KILL $$
+{{- end }}
+) </dev/null >/dev/null 2>&1 &
+{{ end }}
+{{ end }}
+`))
+
+	simpleEntrypoint = template.Must(template.Must(entrypointSnippets.Clone()).New("user-entrypoint").Parse(`
 set -eu
 
 export CDEBUG_ROOTFS=/
+{{ if .HasBinaries }}export PATH=$PATH:{{ .BinariesDir }}{{ end }}
+{{ template "init" . }}
 
+{{ if .RootfsLink }}
+mkdir -p {{ .RootfsLinkDir }}
+ln -s {{ .TargetRootfs }} {{ .RootfsLink }}
+{{ else }}
 if [ "${HOME:-/}" != "/" ]; then
-	ln -s /proc/{{ .TARGET_PID }}/root/ ${HOME}target-rootfs
+	ln -s {{ .TargetRootfs }} ${HOME}target-rootfs
 fi
+{{ end }}
 
 # TODO: Add target container's PATH to the user's PATH
 
+{{ template "watchdog" . }}
+{{ template "privdrop" . }}
 exec {{ .Cmd }}
 `))
 
-	chrootEntrypoint = template.Must(template.New("chroot-entrypoint").Parse(`
+	chrootEntrypoint = template.Must(template.Must(entrypointSnippets.Clone()).New("chroot-entrypoint").Parse(`
 set -eu
 
-CURRENT_PID=$(sh -c 'echo $PPID')
+CURRENT_PID=$({{ .Shell }} -c 'echo $PPID')
 
 {{ if .IsNix }}
 CURRENT_NIX_INODE=$(stat -c '%i' /nix)
-TARGET_NIX_INODE=$(stat -c '%i' /proc/{{ .TARGET_PID }}/root/nix 2>/dev/null || echo 0)
+TARGET_NIX_INODE=$(stat -c '%i' {{ .ChrootRoot }}/nix 2>/dev/null || echo 0)
 if [ ${CURRENT_NIX_INODE} -ne ${TARGET_NIX_INODE} ]; then
-  rm -rf /proc/{{ .TARGET_PID }}/root/nix
-  ln -s /proc/${CURRENT_PID}/root/nix /proc/{{ .TARGET_PID }}/root/nix
+  rm -rf {{ .ChrootRoot }}/nix
+  ln -s /proc/${CURRENT_PID}/root/nix {{ .ChrootRoot }}/nix
 fi
 {{ end }}
 
-ln -s /proc/${CURRENT_PID}/root/ /proc/{{ .TARGET_PID }}/root/.cdebug-{{ .ID }}
+mkdir -p {{ .ChrootRoot }}{{ .RootfsLinkDir }}
+ln -s /proc/${CURRENT_PID}/root/ {{ .ChrootRoot }}{{ .RootfsLink }}
 
-export CDEBUG_ROOTFS=/.cdebug-{{ .ID }}
+{{ range .ChrootBinaries }}
+# Absolute symlinks in the debugger image would dangle in the chroot.
+CDEBUG_CHROOT_BINARY=$(readlink -f {{ .Path }} || true)
+if [ -z "${CDEBUG_CHROOT_BINARY}" ] || [ ! -e "${CDEBUG_CHROOT_BINARY}" ]; then
+  echo "cdebug: --chroot-binary {{ .Path }} not found in the debugger image, skipping" >&2
+elif [ -e {{ $.ChrootRoot }}{{ .Path }} ] || [ -L {{ $.ChrootRoot }}{{ .Path }} ]; then
+  echo "cdebug: --chroot-binary {{ .Path }} already exists in the target, skipping" >&2
+else
+  mkdir -p {{ $.ChrootRoot }}{{ .Dir }}
+  ln -s {{ $.RootfsLink }}"${CDEBUG_CHROOT_BINARY}" {{ $.ChrootRoot }}{{ .Path }}
+fi
+{{ end }}
+
+export CDEBUG_ROOTFS={{ .RootfsLink }}
+{{ range .BinaryWrappers }}
+sed -i "1s|.*|#!$CDEBUG_ROOTFS$(command -v {{ $.Shell }})|" {{ . }}
+{{ end }}
+{{ template "init" . }}
+{{ template "chroot-privdrop" . }}
 
 cat > /.cdebug-entrypoint.sh <<EOF
 #!/bin/sh
-export PATH=$PATH:$CDEBUG_ROOTFS/bin:$CDEBUG_ROOTFS/usr/bin:$CDEBUG_ROOTFS/sbin:$CDEBUG_ROOTFS/usr/sbin:$CDEBUG_ROOTFS/usr/local/bin:$CDEBUG_ROOTFS/usr/local/sbin
+export PATH=$PATH:$CDEBUG_ROOTFS/bin:$CDEBUG_ROOTFS/usr/bin:$CDEBUG_ROOTFS/sbin:$CDEBUG_ROOTFS/usr/sbin:$CDEBUG_ROOTFS/usr/local/bin:$CDEBUG_ROOTFS/usr/local/sbin{{ if .HasBinaries }}:$CDEBUG_ROOTFS{{ .BinariesDir }}{{ end }}
+{{ if .SSHAuthSock }}export SSH_AUTH_SOCK=$CDEBUG_ROOTFS{{ .SSHAuthSock }}{{ end }}
 
-chroot /proc/{{ .TARGET_PID }}/root {{ .Cmd }}
+exec chroot ${CDEBUG_CHROOT_OPTS:-} {{ .ChrootRoot }} ${CDEBUG_PRIVDROP:-} {{ .Cmd }}
 EOF
 
-exec sh /.cdebug-entrypoint.sh
+{{ template "watchdog" . }}
+exec {{ .Shell }} /.cdebug-entrypoint.sh
 `))
 )
 
+// The init (PID 1) for --init: tini if the toolkit image has it, otherwise
+// a shell that forwards the termination signals to the entrypoint and reaps
+// the zombies (the wait builtin collects any exited child, orphans included).
+// The stdin is passed via fd 3 - background jobs of a non-interactive shell
+// get /dev/null as stdin otherwise.
+var initEntrypoint = template.Must(template.New("init-entrypoint").Parse(`
+if command -v tini >/dev/null 2>&1; then
+  exec tini -s -g -- {{ .Shell }} -c {{ .Entrypoint }}
+fi
+
+exec 3<&0
+{{ .Shell }} -c {{ .Entrypoint }} <&3 3<&- &
+CDEBUG_CHILD=$!
+trap 'kill -TERM $CDEBUG_CHILD 2>/dev/null' TERM INT HUP
+
+CDEBUG_STATUS=0
+while kill -0 $CDEBUG_CHILD 2>/dev/null; do
+  wait $CDEBUG_CHILD
+  CDEBUG_STATUS=$?
+done
+exit $CDEBUG_STATUS
+`))
+
 func debuggerEntrypoint(
 	cli cliutil.CLI,
 	runID string,
 	targetPID int,
-	image string,
-	cmd []string,
+	opts *options,
 	chroot bool,
 ) string {
+	cmd := opts.cmd
+
 	if chroot {
-		return mustRenderTemplate(
+		link := path.Join("/", opts.chrootPath, ".cdebug-"+runID)
+
+		return withInit(cli, opts, mustRenderTemplate(
 			cli,
 			chrootEntrypoint,
 			map[string]any{
 				"ID":         runID,
 				"TARGET_PID": targetPID,
-				"IsNix":      strings.Contains(image, "nixery"),
+				"ChrootRoot": func() string {
+					if opts.targetRootfs != "" {
+						return strings.TrimSuffix(opts.targetRootfs, "/")
+					}
+					return fmt.Sprintf("/proc/%d/root", targetPID)
+				}(),
+				"RootfsLink":           link,
+				"RootfsLinkDir":        path.Dir(link),
+				"InitScript":           opts.initScript,
+				"HasBinaries":          len(opts.binaryCopies) > 0,
+				"BinariesDir":          binariesDir,
+				"BinaryWrappers":       binaryWrappers(opts.copyBinaries),
+				"IsNix":                strings.Contains(opts.image, "nixery"),
+				"Shell":                shellquote(scriptShell(opts)),
+				"ChrootBinaries":       chrootBinaryLinks(opts.chrootBinaries),
+				"SSHAuthSock":          opts.sshAuthSock,
+				"ExecUser":             opts.execUser,
+				"ExecTimeout":          int(opts.execTimeout.Seconds()),
+				"ExecTimeoutSignal":    opts.timeoutKillSignal,
+				"ExecTimeoutKillAfter": int(opts.timeoutKillGracePeriod.Seconds()),
 				"Cmd": func() string {
 					if len(cmd) == 0 {
 						return "sh"
@@ -346,22 +2205,91 @@ func debuggerEntrypoint(
 					return "sh -c '" + strings.Join(shellescape(cmd), " ") + "'"
 				}(),
 			},
-		)
+		))
+	}
+
+	var link string
+	if opts.chrootPath != "" {
+		link = path.Join(opts.chrootPath, "target-rootfs")
 	}
 
-	return mustRenderTemplate(
+	targetRootfs := fmt.Sprintf("/proc/%d/root/", targetPID)
+	if opts.targetRootfs != "" {
+		targetRootfs = opts.targetRootfs
+	}
+
+	userCmd := "sh"
+	if len(cmd) > 0 {
+		userCmd = "sh -c \"" + strings.Join(shellescape(cmd), " ") + "\""
+	}
+	execUID, _, _ := strings.Cut(opts.execUser, ":")
+
+	return withInit(cli, opts, mustRenderTemplate(
 		cli,
 		simpleEntrypoint,
 		map[string]any{
-			"PID": targetPID,
-			"Cmd": func() string {
-				if len(cmd) == 0 {
-					return "sh"
-				}
-				return "sh -c \"" + strings.Join(shellescape(cmd), " ") + "\""
-			}(),
+			"TargetRootfs":         targetRootfs,
+			"RootfsLink":           link,
+			"RootfsLinkDir":        path.Dir(link),
+			"InitScript":           opts.initScript,
+			"HasBinaries":          len(opts.binaryCopies) > 0,
+			"BinariesDir":          binariesDir,
+			"ExecTimeout":          int(opts.execTimeout.Seconds()),
+			"ExecTimeoutSignal":    opts.timeoutKillSignal,
+			"ExecTimeoutKillAfter": int(opts.timeoutKillGracePeriod.Seconds()),
+			"ExecUser":             opts.execUser,
+			"ExecUID":              execUID,
+			"Cmd":                  userCmd,
+			"QuotedCmd":            shellquote(userCmd),
 		},
-	)
+	))
+}
+
+// debuggerCommand splits the invocation of the debugger's setup script
+// into the entrypoint and its arguments. A nil entrypoint means the
+// toolkit image's own ENTRYPOINT should be kept (--entrypoint "").
+func debuggerCommand(opts *options, script string) ([]string, []string) {
+	if opts.entrypoint == "" {
+		return nil, []string{"-c", script}
+	}
+	return []string{opts.entrypoint}, []string{"-c", script}
+}
+
+var cmdShellRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.+-]*$`)
+
+// scriptShell is the shell the debugger's own scripts (the setup script,
+// the chroot mode's second stage, the --init wrapper) run with - the
+// toolkit image may have no "sh" (--cmd-shell).
+func scriptShell(opts *options) string {
+	if opts.entrypoint == "" {
+		return "sh"
+	}
+	return opts.entrypoint
+}
+
+// withInit makes the entrypoint a child of an init process (--init).
+func withInit(cli cliutil.CLI, opts *options, entrypoint string) string {
+	if !opts.init {
+		return entrypoint
+	}
+	return mustRenderTemplate(cli, initEntrypoint, map[string]any{
+		"Entrypoint": shellquote(entrypoint),
+		"Shell":      shellquote(scriptShell(opts)),
+	})
+}
+
+type chrootBinaryLink struct {
+	Path string
+	Dir  string
+}
+
+func chrootBinaryLinks(paths []string) []chrootBinaryLink {
+	var links []chrootBinaryLink
+	for _, p := range paths {
+		p = path.Clean(p)
+		links = append(links, chrootBinaryLink{Path: p, Dir: path.Dir(p)})
+	}
+	return links
 }
 
 func mustRenderTemplate(cli cliutil.CLI, t *template.Template, data any) string {
@@ -373,6 +2301,19 @@ func mustRenderTemplate(cli cliutil.CLI, t *template.Template, data any) string
 	return buf.String()
 }
 
+// timeoutSignal validates the --timeout-kill-signal value and converts it
+// to the form the watchdog passes to the shell's kill builtin as "kill -SIG"
+// (e.g., "TERM" for -TERM, or a number as is).
+func timeoutSignal(sig string) (string, error) {
+	if _, err := mobysignal.ParseSignal(sig); err != nil {
+		return "", err
+	}
+	if _, err := strconv.Atoi(sig); err == nil {
+		return sig, nil
+	}
+	return strings.TrimPrefix(strings.ToUpper(sig), "SIG"), nil
+}
+
 // FIXME: Too naive. This will break for args containing escaped symbols.
 func shellescape(args []string) (escaped []string) {
 	for _, a := range args {
@@ -384,10 +2325,48 @@ func shellescape(args []string) (escaped []string) {
 	return
 }
 
+func shellquote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// isSystemMount tells if the mount is set up by the runtime itself, so
+// the debugger gets its own one anyway (--share-mounts).
+func isSystemMount(dest string) bool {
+	switch dest {
+	case "/etc/hosts", "/etc/hostname", "/etc/resolv.conf":
+		return true
+	}
+	return isPseudoFSMount(dest)
+}
+
 func isRootUser(user string) bool {
 	return len(user) == 0 || user == "root" || user == "0" || user == "0:0"
 }
 
+// retryNotFound retries fn while it fails with a "not found" error - a freshly
+// created container may be briefly invisible to some of the runtime's APIs.
+func retryNotFound(
+	ctx context.Context,
+	what string,
+	isNotFound func(error) bool,
+	fn func() error,
+) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !isNotFound(err) || attempt > notFoundRetries {
+			return err
+		}
+
+		logrus.Debugf("%s failed (attempt %d/%d): %s", what, attempt, notFoundRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(notFoundRetryBackoff):
+		}
+	}
+}
+
 func wrapExitError(err error) error {
 	if err == nil {
 		return nil
@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -17,9 +21,12 @@ import (
 	"github.com/containerd/containerd/cmd/ctr/commands"
 	"github.com/containerd/containerd/cmd/ctr/commands/tasks"
 	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/oci"
 	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/continuity/fs"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 
@@ -29,8 +36,18 @@ import (
 )
 
 func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options) error {
-	if opts.detach {
-		return errors.New("--detach|-d flag is not supported for containerd runtime yet")
+	if opts.network != networkContainer && opts.network != networkHost {
+		return errors.New("--network flag is not supported for containerd runtime yet (only --network host is)")
+	}
+	if opts.init {
+		return errors.New("--init flag is not supported for containerd runtime yet")
+	}
+	if opts.pid != pidContainer {
+		return errors.New("--pid flag is not supported for containerd runtime yet")
+	}
+	if opts.networkAlias != "" {
+		// cdebug doesn't manage CNI networks, and containerd has no embedded DNS to register the alias in.
+		return errors.New("--network-alias flag is not supported for containerd runtime")
 	}
 
 	if strings.Contains(opts.namespace, "/") {
@@ -38,9 +55,10 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 	}
 
 	client, err := containerd.NewClient(containerd.Options{
-		Out:       cli.AuxStream(),
-		Address:   opts.runtime,
-		Namespace: opts.namespace,
+		Out:         cli.AuxStream(),
+		Address:     opts.runtime,
+		Namespace:   opts.namespace,
+		Snapshotter: opts.snapshotter,
 	})
 	if err != nil {
 		return err
@@ -60,6 +78,13 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 	if err != nil {
 		return err
 	}
+	if len(found) == 0 && opts.namespace == "" {
+		found, err = findTargetInAllNamespaces(ctx, cli, client, filters)
+		if err != nil {
+			return err
+		}
+		ctx = namespaces.WithNamespace(ctx, client.Namespace())
+	}
 	if len(found) == 0 {
 		return errTargetNotFound
 	}
@@ -68,13 +93,19 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 	}
 	target := found[0]
 
+	running := false
 	targetTask, err := target.Task(ctx, nil)
-	if err != nil {
+	if err != nil && !errdefs.IsNotFound(err) {
 		return err
 	}
-	if status, err := targetTask.Status(ctx); err != nil {
-		return err
-	} else if status.Status != offcontainerd.Running {
+	if targetTask != nil {
+		status, err := targetTask.Status(ctx)
+		if err != nil {
+			return err
+		}
+		running = status.Status == offcontainerd.Running
+	}
+	if !running && !opts.rootfs {
 		return errTargetNotRunning
 	}
 
@@ -83,6 +114,84 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 		return err
 	}
 
+	if opts.cgroup {
+		if targetSpec.Linux == nil || targetSpec.Linux.CgroupsPath == "" {
+			return errors.New("--cgroup flag requires the target to have a cgroups path")
+		}
+		// Scopes of the systemd cgroup driver ("slice:prefix:name") cannot have children.
+		if strings.Contains(targetSpec.Linux.CgroupsPath, ":") {
+			return errors.New("--cgroup flag is not supported with the systemd cgroup driver (use --cgroup-parent with the target's slice)")
+		}
+	}
+
+	runtimeOpt, err := debuggerRuntimeContainerd(ctx, cli, target, opts.ociRuntime)
+	if err != nil {
+		return err
+	}
+
+	var volumes []specs.Mount
+	if opts.volumesFrom != "" {
+		volumes, err = volumesFromContainerd(ctx, client, opts.volumesFrom)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.shareMounts {
+		for _, m := range targetSpec.Mounts {
+			if !isSystemMount(m.Destination) {
+				volumes = append(volumes, m)
+			}
+		}
+	}
+
+	if len(opts.dns)+len(opts.dnsSearch) > 0 {
+		var base []byte
+		if targetTask != nil && running {
+			base, err = os.ReadFile(fmt.Sprintf("/proc/%d/root/etc/resolv.conf", targetTask.Pid()))
+			if err != nil {
+				logrus.Debugf("Cannot read target's resolv.conf: %s", err)
+			}
+		}
+
+		resolvConf, err := writeDebuggerFile("cdebug-resolv-conf-", debuggerResolvConf(base, opts.dns, opts.dnsSearch))
+		if err != nil {
+			return fmt.Errorf("cannot create resolv.conf for debugger container: %w", err)
+		}
+		defer os.Remove(resolvConf)
+
+		volumes = append(volumes, specs.Mount{
+			Destination: "/etc/resolv.conf",
+			Type:        "bind",
+			Source:      resolvConf,
+			Options:     []string{"rbind", "ro"},
+		})
+	}
+
+	if len(opts.addHosts) > 0 {
+		base := []byte("127.0.0.1\tlocalhost\n")
+		if targetTask != nil && running {
+			if b, err := os.ReadFile(fmt.Sprintf("/proc/%d/root/etc/hosts", targetTask.Pid())); err == nil {
+				base = b
+			} else {
+				logrus.Debugf("Cannot read target's hosts file: %s", err)
+			}
+		}
+
+		hosts, err := writeDebuggerFile("cdebug-hosts-", debuggerHosts(base, opts.addHosts))
+		if err != nil {
+			return fmt.Errorf("cannot create hosts file for debugger container: %w", err)
+		}
+		defer os.Remove(hosts)
+
+		// Writable, as the regular /etc/hosts.
+		volumes = append(volumes, specs.Mount{
+			Destination: "/etc/hosts",
+			Type:        "bind",
+			Source:      hosts,
+			Options:     []string{"rbind", "rw"},
+		})
+	}
+
 	cli.PrintAux("Pulling debugger image...\n")
 	image, err := client.ImagePullEx(
 		ctx,
@@ -94,30 +203,164 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 			return opts.platform
 		}(),
 	)
+	if err == nil {
+		saveCachedImage(ctx, cli, client, opts)
+	} else if loadCachedImage(ctx, cli, client, opts) == nil {
+		image, err = client.GetImage(ctx, opts.image)
+	}
 	if err != nil {
 		return errCannotPull(opts.image, err)
 	}
 
+	if opts.waitFor > 0 {
+		if !running {
+			return errors.New("--wait-for flag requires a running target")
+		}
+		if err := waitForPort(ctx, cli, opts, listeningProbe(targetTask.Pid(), opts.waitFor)); err != nil {
+			return err
+		}
+	}
+
+	var uidMap, gidMap []specs.LinuxIDMapping
+	if opts.usernsRemap != "" {
+		uidMap, gidMap, err = usernsRemapMappings(opts.usernsRemap, targetTask, running)
+		if err != nil {
+			return err
+		}
+	}
+
 	runID := uuid.ShortID()
-	runName := debuggerName(opts.name, runID)
+	runName := debuggerName(opts.name)
+	useChroot := isRootUser(opts.user)
+	if useChroot && targetSpec.Root != nil && targetSpec.Root.Readonly && !opts.snapshot {
+		cli.PrintAux("Target has read-only rootfs, using simple mode\n")
+		useChroot = false
+	}
+	if useChroot && opts.hostRootfs {
+		cli.PrintAux("The host's rootfs is not visible from the target's rootfs, using simple mode\n")
+		useChroot = false
+	}
+
+	var (
+		targetPID      int
+		namespacesSpec oci.SpecOpts
+	)
+	if running {
+		targetPID = int(targetTask.Pid())
+		if hasNamespace(targetSpec.Linux.Namespaces, specs.PIDNamespace) {
+			targetPID = 1
+		}
+		namespacesSpec = debuggerNamespacesSpec(targetTask.Pid(), targetSpec.Linux.Namespaces, opts.network, opts.ipc)
+	} else {
+		cli.PrintAux("Target is not running, mounting its rootfs...\n")
+		rootfs, err := stoppedRootfsContainerd(ctx, client, target)
+		if err != nil {
+			return fmt.Errorf("cannot mount target's rootfs: %w", err)
+		}
+		volumes = append(volumes, rootfs...)
+		opts.targetRootfs = stoppedTargetRootfs
+		useChroot = false
+
+		// Namespaces of a stopped container cannot be joined - the
+		// debugger gets the default (new) ones instead.
+		namespacesSpec = ociSpecNoOp
+	}
+	if useChroot && len(opts.dns)+len(opts.dnsSearch) > 0 {
+		// The chrooted shell reads the target's /etc/resolv.conf and /etc/hosts.
+		return errors.New("--dns and --dns-search flags are not supported in the chroot mode (use a non-root --user to run the debugger in the simple mode)")
+	}
+	if useChroot && len(opts.addHosts) > 0 {
+		return errors.New("--add-host flag is not supported in the chroot mode (use a non-root --user to run the debugger in the simple mode)")
+	}
+
+	if opts.snapshot {
+		if !running {
+			return errors.New("--snapshot flag requires a running target (use --rootfs for stopped ones)")
+		}
+
+		cli.PrintAux("Taking a snapshot of the target's rootfs...\n")
+		dir, err := snapshotRootfsContainerd(ctx, client, target)
+		defer cleanupSnapshot(cli, opts, dir)
+		if err != nil {
+			return fmt.Errorf("cannot snapshot target's rootfs: %w", err)
+		}
+
+		volumes = append(volumes, specs.Mount{
+			Destination: snapshotTargetRootfs,
+			Type:        "bind",
+			Source:      dir,
+			Options:     []string{"rbind", "rw"},
+		})
+		opts.targetRootfs = snapshotTargetRootfs
+	}
+
+	if opts.sshAuthSock != "" {
+		volumes = append(volumes, specs.Mount{
+			Destination: opts.sshAuthSock,
+			Type:        "bind",
+			Source:      opts.sshAuthSock,
+			Options:     []string{"bind", "rw"},
+		})
+	}
 
-	targetPID := int(targetTask.Pid())
-	if hasNamespace(targetSpec.Linux.Namespaces, specs.PIDNamespace) {
-		targetPID = 1
+	if opts.hostRootfs {
+		volumes = append(volumes, specs.Mount{
+			Destination: hostRootfsMountpoint,
+			Type:        "bind",
+			Source:      "/proc/1/root",
+			Options:     []string{"rbind", "rw"},
+		})
 	}
 
+	if err := containerd.ValidateMountOverlap(volumes); err != nil {
+		if !opts.allowOverlappingMounts {
+			return fmt.Errorf("%w (use --allow-overlapping-mounts to proceed anyway)", err)
+		}
+		cli.PrintErr("Warning: %s\n", err)
+	}
+
+	entrypoint, args := debuggerCommand(opts, debuggerEntrypoint(
+		cli, runID, targetPID, opts, useChroot,
+	))
+
 	debugger, err := client.NewContainer(
 		ctx,
 		runName,
-		offcontainerd.WithNewSnapshot(runName, image),
+		offcontainerd.WithSnapshotter(client.Snapshotter()),
+		func() offcontainerd.NewContainerOpts {
+			if len(uidMap) > 0 {
+				// Otherwise, the image files would be owned by nobody
+				// inside the debugger's user namespace.
+				return withRemappedSnapshot(runName, image, rootHostID(uidMap), rootHostID(gidMap))
+			}
+			return offcontainerd.WithNewSnapshot(runName, image)
+		}(),
+		runtimeOpt,
 		offcontainerd.WithNewSpec(
 			oci.Compose(
 				// Order is important here!
 				oci.WithDefaultPathEnv,
-				oci.WithImageConfig(image), // May override the default $PATH.
-				oci.WithProcessArgs("sh", "-c", debuggerEntrypoint(
-					cli, runID, targetPID, opts.image, opts.cmd, isRootUser(opts.user),
-				)),
+				// May override the default $PATH. With a nil entrypoint,
+				// the args are appended to the image's ENTRYPOINT.
+				oci.WithImageConfigArgs(image, args),
+				func() oci.SpecOpts {
+					if len(opts.overrideEnv) > 0 {
+						return oci.WithEnv(mergeTargetEnv(targetSpec.Process.Env, opts.overrideEnv))
+					}
+					return ociSpecNoOp
+				}(),
+				func() oci.SpecOpts {
+					if opts.sshAuthSock != "" {
+						return oci.WithEnv([]string{"SSH_AUTH_SOCK=" + opts.sshAuthSock})
+					}
+					return ociSpecNoOp
+				}(),
+				func() oci.SpecOpts {
+					if entrypoint == nil {
+						return ociSpecNoOp
+					}
+					return oci.WithProcessArgs(append(entrypoint, args...)...)
+				}(),
 				func() oci.SpecOpts {
 					if opts.tty {
 						return oci.WithTTY
@@ -137,7 +380,12 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 
 					// Take the target's config as is:
 					return oci.Compose(
-						oci.WithCapabilities(targetSpec.Process.Capabilities.Effective),
+						func() oci.SpecOpts {
+							if opts.capInherit {
+								return withInheritedCapabilities(targetSpec.Process.Capabilities)
+							}
+							return oci.WithCapabilities(targetSpec.Process.Capabilities.Effective)
+						}(),
 						oci.WithMaskedPaths(targetSpec.Linux.MaskedPaths),
 						oci.WithReadonlyPaths(targetSpec.Linux.ReadonlyPaths),
 						// TODO: oci.WithWriteableSysfs,
@@ -153,7 +401,60 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 						},
 					)
 				}(),
-				debuggerNamespacesSpec(targetTask.Pid(), targetSpec.Linux.Namespaces),
+				func() oci.SpecOpts {
+					if opts.ptrace && !opts.privileged {
+						return oci.WithAddedCapabilities([]string{"CAP_SYS_PTRACE"})
+					}
+					return ociSpecNoOp
+				}(),
+				func() oci.SpecOpts {
+					if opts.memory > 0 {
+						return oci.WithMemoryLimit(uint64(opts.memory))
+					}
+					return ociSpecNoOp
+				}(),
+				func() oci.SpecOpts {
+					if opts.cgroup {
+						// A child cgroup - destroying the target's own cgroup
+						// on the debugger's exit would kill the target.
+						return oci.WithCgroup(debuggerCgroupsPath(targetSpec.Linux.CgroupsPath, runName))
+					}
+					if opts.cgroupParent != "" {
+						return oci.WithCgroup(debuggerCgroupsPath(opts.cgroupParent, runName))
+					}
+					return ociSpecNoOp
+				}(),
+				func() oci.SpecOpts {
+					if opts.shmSize > 0 {
+						return oci.WithDevShmSize(opts.shmSize / 1024)
+					}
+					return ociSpecNoOp
+				}(),
+				func() oci.SpecOpts {
+					if opts.cpuQuota > 0 {
+						return oci.WithCPUCFS(opts.cpuQuota, 100000)
+					}
+					return ociSpecNoOp
+				}(),
+				namespacesSpec,
+				func() oci.SpecOpts {
+					if len(uidMap) == 0 {
+						return ociSpecNoOp
+					}
+					if running {
+						// Joining the target's user namespace makes the chroot into
+						// /proc/<pid>/root work with the same effective UIDs.
+						return oci.Compose(
+							oci.WithUserNamespace(uidMap, gidMap),
+							oci.WithLinuxNamespace(specs.LinuxNamespace{
+								Type: specs.UserNamespace,
+								Path: fmt.Sprintf("/proc/%d/ns/user", targetTask.Pid()),
+							}),
+						)
+					}
+					return oci.WithUserNamespace(uidMap, gidMap)
+				}(),
+				oci.WithMounts(volumes),
 			),
 		),
 	)
@@ -161,29 +462,64 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 		return errCannotCreate(err)
 	}
 
-	if opts.autoRemove {
-		defer func() {
-			ctx, cancel := context.WithTimeout(
-				namespaces.WithNamespace(context.Background(), client.Namespace()),
-				3*time.Second,
-			)
-			defer cancel()
+	removeDebugger := func() {
+		ctx, cancel := context.WithTimeout(
+			namespaces.WithNamespace(context.Background(), client.Namespace()),
+			3*time.Second,
+		)
+		defer cancel()
 
-			if err := client.ContainerRemoveEx(ctx, debugger, true); err != nil {
-				logrus.Debugf("Cannot remove debugger container: %s", err)
-			}
-		}()
+		if err := client.ContainerRemoveEx(ctx, debugger, true); err != nil {
+			logrus.Debugf("Cannot remove debugger container: %s", err)
+		}
 	}
 
-	ioc, con, err := prepareTaskIO(ctx, cli, opts.tty, opts.stdin, debugger)
-	if err != nil {
-		return err
+	for _, spec := range copyToSpecs(opts) {
+		if err := client.CopyToContainer(ctx, debugger, spec.hostPath, spec.containerPath); err != nil {
+			// The debugger has never started, so there is nothing to keep.
+			removeDebugger()
+			return fmt.Errorf("cannot copy %s to debugger container: %w", spec.hostPath, err)
+		}
 	}
-	if con != nil {
-		defer con.Reset()
+
+	if opts.autoRemove && opts.detach {
+		// Unlike dockerd, containerd has no auto-removal of exited containers.
+		cli.PrintErr("Warning: --rm is ignored in the detached mode for containerd runtime (remove the debugger with \"ctr containers rm %s\")\n", runName)
+	} else if opts.autoRemove {
+		defer removeDebugger()
+	}
+
+	// There is no log storage in containerd, so the output is
+	// written to the --log-file as the session goes.
+	var logOut io.Writer
+	if opts.logFile != "" {
+		f, err := os.Create(opts.logFile)
+		if err != nil {
+			return fmt.Errorf("cannot create log file: %w", err)
+		}
+		defer f.Close()
+		logOut = f
+	}
+
+	// The task outlives cdebug in the detached mode, so it cannot be
+	// attached to cdebug's stdio.
+	var ioc cio.Creator = cio.NullIO
+	var con console.Console
+	if !opts.detach {
+		ioc, con, err = prepareTaskIO(ctx, cli, opts, debugger, logOut)
+		if err != nil {
+			return err
+		}
+		if con != nil {
+			defer con.Reset()
+		}
 	}
 
-	task, err := debugger.NewTask(ctx, ioc)
+	var task offcontainerd.Task
+	err = retryNotFound(ctx, "Creating debugger task", errdefs.IsNotFound, func() (err error) {
+		task, err = debugger.NewTask(ctx, ioc)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -197,7 +533,20 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 		return err
 	}
 
-	if opts.tty && cli.OutputStream().IsTerminal() {
+	printDebuggerInfo(cli, opts, debuggerInfo{Name: runName, ID: debugger.ID()}, nil)
+
+	if opts.detach {
+		printDetachedDebugger(cli, opts, runName)
+		return nil
+	}
+
+	cli.PrintOut("%s", stdinPrompt(opts))
+
+	if opts.tty && opts.ttyCols > 0 {
+		if err := task.Resize(ctx, uint32(opts.ttyCols), uint32(opts.ttyRows)); err != nil {
+			logrus.WithError(err).Error("console resize")
+		}
+	} else if opts.tty && cli.OutputStream().IsTerminal() {
 		if err := tasks.HandleConsoleResize(ctx, task, con); err != nil {
 			logrus.WithError(err).Error("console resize")
 		}
@@ -210,6 +559,24 @@ func runDebuggerContainerd(ctx context.Context, cli cliutil.CLI, opts *options)
 	if status.Error() != nil {
 		return fmt.Errorf("waiting debugger container failed: %w", err)
 	}
+	if opts.onExit != nil {
+		opts.onExit(target.ID(), int(status.ExitCode()))
+	}
+
+	if len(opts.copyFrom) > 0 {
+		// In the chroot mode, the debugger's rootfs is the target's rootfs.
+		source := debugger
+		if useChroot {
+			source = target
+		}
+
+		for _, spec := range copyFromSpecs(opts.copyFrom) {
+			if err := client.CopyFromContainer(ctx, source, spec.containerPath, spec.hostPath); err != nil {
+				cli.PrintErr("Warning: cannot copy %s from the debugger container: %s\n", spec.containerPath, err)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -222,9 +589,184 @@ var (
 	}
 )
 
+// debuggerRuntimeContainerd picks the runtime for the debugger container:
+// the daemon's default one, the explicitly requested one, or the target's.
+func debuggerRuntimeContainerd(
+	ctx context.Context,
+	cli cliutil.CLI,
+	target offcontainerd.Container,
+	name string,
+) (offcontainerd.NewContainerOpts, error) {
+	if name == "" {
+		// Let the client fall back to the daemon's default runtime.
+		return func(context.Context, *offcontainerd.Client, *containers.Container) error { return nil }, nil
+	}
+	if name != ociRuntimeTarget {
+		cli.PrintAux("Using OCI runtime %s\n", name)
+		return offcontainerd.WithRuntime(name, nil), nil
+	}
+
+	info, err := target.Info(ctx, offcontainerd.WithoutRefreshedMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get target's runtime: %w", err)
+	}
+	if info.Runtime.Name == "" {
+		return nil, errors.New("cannot determine target's runtime")
+	}
+
+	// Only the name - the target's runtime options (e.g., CRI's SystemdCgroup)
+	// may not fit the debugger's spec.
+	cli.PrintAux("Using OCI runtime %s (inherited from the target)\n", info.Runtime.Name)
+	return offcontainerd.WithRuntime(info.Runtime.Name, nil), nil
+}
+
+// stoppedRootfsContainerd returns the mounts of the (stopped) container's
+// rootfs snapshot retargeted to the debugger's stoppedTargetRootfs path.
+func stoppedRootfsContainerd(
+	ctx context.Context,
+	client *containerd.Client,
+	cont offcontainerd.Container,
+) ([]specs.Mount, error) {
+	info, err := cont.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := client.SnapshotService(info.Snapshotter).Mounts(ctx, info.SnapshotKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var rootfs []specs.Mount
+	for _, m := range mounts {
+		rootfs = append(rootfs, specs.Mount{
+			Destination: stoppedTargetRootfs,
+			Type:        m.Type,
+			Source:      m.Source,
+			Options:     append(m.Options, "ro"),
+		})
+	}
+	return rootfs, nil
+}
+
+// snapshotRootfsContainerd copies the container's rootfs snapshot into
+// a temporary directory (that has to be removed by the caller). An active
+// snapshot cannot be a parent of a new one, hence the copying.
+func snapshotRootfsContainerd(
+	ctx context.Context,
+	client *containerd.Client,
+	cont offcontainerd.Container,
+) (string, error) {
+	info, err := cont.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	mounts, err := client.SnapshotService(info.Snapshotter).Mounts(ctx, info.SnapshotKey)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "cdebug-snapshot-")
+	if err != nil {
+		return "", err
+	}
+
+	return dir, mount.WithReadonlyTempMount(ctx, mounts, func(root string) error {
+		return fs.CopyDir(dir, root)
+	})
+}
+
+// findTargetInAllNamespaces looks for the target in every namespace
+// but the client's current one. If the target is found in exactly one
+// namespace, the client is switched to it.
+func findTargetInAllNamespaces(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client *containerd.Client,
+	filters []string,
+) ([]offcontainerd.Container, error) {
+	nsList, err := client.NamespaceService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list containerd namespaces: %w", err)
+	}
+
+	var (
+		matchedNs []string
+		found     []offcontainerd.Container
+	)
+	for _, ns := range nsList {
+		if ns == client.Namespace() {
+			continue
+		}
+
+		conts, err := client.Containers(namespaces.WithNamespace(ctx, ns), filters...)
+		if err != nil {
+			logrus.Debugf("Cannot list containers in namespace %q: %s", ns, err)
+			continue
+		}
+		if len(conts) > 0 {
+			matchedNs = append(matchedNs, ns)
+			found = conts
+		}
+	}
+
+	switch len(matchedNs) {
+	case 0:
+		return nil, nil
+	case 1:
+		cli.PrintAux("Target found in namespace %q\n", matchedNs[0])
+		client.SetNamespace(matchedNs[0])
+		return found, nil
+	default:
+		return nil, fmt.Errorf("target found in multiple namespaces (%s): use -n|--namespace to pick one",
+			strings.Join(matchedNs, ", "))
+	}
+}
+
+// volumesFromContainerd mimics `docker run --volumes-from` - containerd
+// has no notion of volumes, so the container's mounts are copied instead
+// (keeping their options, e.g., "ro"). The runtime-managed pseudo
+// filesystems are skipped since the debugger gets its own ones.
+func volumesFromContainerd(
+	ctx context.Context,
+	client *containerd.Client,
+	id string,
+) ([]specs.Mount, error) {
+	cont, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find --volumes-from container %q: %w", id, err)
+	}
+
+	spec, err := cont.Spec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read --volumes-from container %q spec: %w", id, err)
+	}
+
+	var mounts []specs.Mount
+	for _, m := range spec.Mounts {
+		if isPseudoFSMount(m.Destination) {
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+func isPseudoFSMount(dest string) bool {
+	for _, dir := range []string{"/proc", "/sys", "/dev"} {
+		if dest == dir || strings.HasPrefix(dest, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func debuggerNamespacesSpec(
 	targetPID uint32,
 	targetNamespaces []specs.LinuxNamespace,
+	network string,
+	ipc string,
 ) oci.SpecOpts {
 	debuggerNamespaces := map[specs.LinuxNamespaceType]oci.SpecOpts{
 		specs.NetworkNamespace: oci.WithHostNamespace(specs.NetworkNamespace),
@@ -234,6 +776,12 @@ func debuggerNamespacesSpec(
 	}
 
 	for _, ns := range targetNamespaces {
+		if ns.Type == specs.IPCNamespace && ipc != ipcContainer && ipc != "" {
+			continue
+		}
+		if ns.Type == specs.NetworkNamespace && network == networkHost {
+			continue
+		}
 		if _, ok := debuggerNamespaces[ns.Type]; ok {
 			debuggerNamespaces[ns.Type] = oci.WithLinuxNamespace(specs.LinuxNamespace{
 				Type: ns.Type,
@@ -242,6 +790,12 @@ func debuggerNamespacesSpec(
 		}
 	}
 
+	if ipc == ipcPrivate {
+		debuggerNamespaces[specs.IPCNamespace] = oci.WithLinuxNamespace(specs.LinuxNamespace{
+			Type: specs.IPCNamespace,
+		})
+	}
+
 	opts := []oci.SpecOpts{}
 	for _, opt := range debuggerNamespaces {
 		opts = append(opts, opt)
@@ -249,6 +803,185 @@ func debuggerNamespacesSpec(
 	return oci.Compose(opts...)
 }
 
+const usernsRemapAuto = "auto"
+
+// parseUsernsRemap parses the UID_SHIFT:GID_SHIFT form of --userns-remap.
+func parseUsernsRemap(spec string) (uint32, uint32, error) {
+	uid, gid, ok := strings.Cut(spec, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid --userns-remap value %q (must be UID_SHIFT:GID_SHIFT or auto)", spec)
+	}
+
+	uidShift, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --userns-remap UID shift %q: %w", uid, err)
+	}
+	gidShift, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --userns-remap GID shift %q: %w", gid, err)
+	}
+
+	return uint32(uidShift), uint32(gidShift), nil
+}
+
+// usernsRemapMappings returns the debugger's UID and GID mappings - either
+// the ones of the (running) target or the explicitly given shifts.
+func usernsRemapMappings(
+	spec string,
+	targetTask offcontainerd.Task,
+	running bool,
+) ([]specs.LinuxIDMapping, []specs.LinuxIDMapping, error) {
+	if spec != usernsRemapAuto {
+		uidShift, gidShift, err := parseUsernsRemap(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []specs.LinuxIDMapping{{ContainerID: 0, HostID: uidShift, Size: 65536}},
+			[]specs.LinuxIDMapping{{ContainerID: 0, HostID: gidShift, Size: 65536}},
+			nil
+	}
+
+	if !running {
+		return nil, nil, errors.New("--userns-remap=auto requires a running target (specify UID_SHIFT:GID_SHIFT instead)")
+	}
+
+	uidMap, err := readIDMap(fmt.Sprintf("/proc/%d/uid_map", targetTask.Pid()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot detect target's UID mapping: %w", err)
+	}
+	gidMap, err := readIDMap(fmt.Sprintf("/proc/%d/gid_map", targetTask.Pid()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot detect target's GID mapping: %w", err)
+	}
+	return uidMap, gidMap, nil
+}
+
+func readIDMap(path string) ([]specs.LinuxIDMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := parseIDMap(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return mappings, nil
+}
+
+// parseIDMap parses the /proc/<pid>/{uid,gid}_map format
+// ("<container-id> <host-id> <size>" per line).
+func parseIDMap(data string) ([]specs.LinuxIDMapping, error) {
+	var mappings []specs.LinuxIDMapping
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed ID mapping %q", line)
+		}
+
+		var ids [3]uint32
+		for i, f := range fields {
+			id, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("malformed ID mapping %q: %w", line, err)
+			}
+			ids[i] = uint32(id)
+		}
+		mappings = append(mappings, specs.LinuxIDMapping{ContainerID: ids[0], HostID: ids[1], Size: ids[2]})
+	}
+
+	if len(mappings) == 1 && mappings[0].ContainerID == 0 && mappings[0].HostID == 0 {
+		return nil, errors.New("the target is not in a remapped user namespace")
+	}
+	return mappings, nil
+}
+
+// rootHostID returns the host ID the container's root (ID 0) maps to.
+func rootHostID(mappings []specs.LinuxIDMapping) uint32 {
+	for _, m := range mappings {
+		if m.ContainerID == 0 {
+			return m.HostID
+		}
+	}
+	return 0
+}
+
+// debuggerCgroupsPath puts the debugger's cgroup under the --cgroup-parent.
+// The systemd cgroup driver expects the "slice:prefix:name" format.
+func debuggerCgroupsPath(parent string, name string) string {
+	if isSystemdSlice(parent) {
+		return parent + ":cdebug:" + name
+	}
+	return path.Join("/", parent, name)
+}
+
+// listeningProbe checks the target's network namespace (via /proc on the
+// containerd host) for a socket listening on the port - containerd doesn't
+// know the container's IP address (the CNI setup is up to its clients).
+func listeningProbe(pid uint32, port int) func() bool {
+	return func() bool {
+		for _, file := range []string{"tcp", "tcp6"} {
+			data, err := os.ReadFile(fmt.Sprintf("/proc/%d/net/%s", pid, file))
+			if err != nil {
+				logrus.Debugf("Cannot read target's sockets: %s", err)
+				continue
+			}
+			if hasListeningSocket(string(data), port) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// hasListeningSocket parses the /proc/<pid>/net/tcp{,6} format, e.g.:
+//
+//	sl  local_address rem_address   st ...
+//	 0: 00000000:0050 00000000:0000 0A ...
+func hasListeningSocket(data string, port int) bool {
+	const stateListen = "0A"
+
+	for _, line := range strings.Split(data, "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != stateListen {
+			continue
+		}
+
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if p, err := strconv.ParseUint(hexPort, 16, 16); err == nil && int(p) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// withInheritedCapabilities copies all the capability sets (bounding,
+// effective, inheritable, permitted, and ambient) of the target's process.
+// Unlike oci.WithCapabilities, which derives all the sets from one list.
+func withInheritedCapabilities(caps *specs.LinuxCapabilities) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Process == nil {
+			s.Process = &specs.Process{}
+		}
+		if caps == nil {
+			s.Process.Capabilities = nil
+			return nil
+		}
+
+		s.Process.Capabilities = &specs.LinuxCapabilities{
+			Bounding:    slices.Clone(caps.Bounding),
+			Effective:   slices.Clone(caps.Effective),
+			Inheritable: slices.Clone(caps.Inheritable),
+			Permitted:   slices.Clone(caps.Permitted),
+			Ambient:     slices.Clone(caps.Ambient),
+		}
+		return nil
+	}
+}
+
 func hasNamespace(list []specs.LinuxNamespace, typ specs.LinuxNamespaceType) bool {
 	for _, ns := range list {
 		if ns.Type == typ {
@@ -265,11 +998,11 @@ func ociSpecNoOp(context.Context, oci.Client, *containers.Container, *oci.Spec)
 func prepareTaskIO(
 	ctx context.Context,
 	cli cliutil.CLI,
-	tty bool,
-	stdin bool,
+	opts *options,
 	cont offcontainerd.Container,
+	logOut io.Writer,
 ) (cio.Creator, console.Console, error) {
-	if tty {
+	if opts.tty {
 		var con console.Console
 		if cli.OutputStream().IsTerminal() {
 			con = console.Current()
@@ -279,18 +1012,24 @@ func prepareTaskIO(
 		}
 
 		var in io.Reader
-		if stdin {
+		if opts.stdin {
 			if con == nil {
 				return nil, nil, errors.New("input must be a terminal")
 			}
 			in = con
 		}
 
-		return cio.NewCreator(cio.WithStreams(in, con, nil), cio.WithTerminal), con, nil
+		var out io.Writer
+		if con != nil {
+			out = con
+		}
+
+		in, out, _ = teeStreams(opts, in, withLogOut(out, logOut), nil)
+		return cio.NewCreator(cio.WithStreams(in, out, nil), cio.WithTerminal), con, nil
 	}
 
 	var in io.Reader
-	if stdin {
+	if opts.stdin {
 		in = &inCloser{
 			inputStream: cli.InputStream(),
 			close: func() {
@@ -301,13 +1040,26 @@ func prepareTaskIO(
 				}
 			},
 		}
+		in = echoStdin(cli, opts, in)
 	}
 
-	return cio.NewCreator(cio.WithStreams(
+	in, out, errOut := teeStreams(
+		opts,
 		in,
-		cli.OutputStream(),
-		cli.ErrorStream(),
-	)), nil, nil
+		withLogOut(cli.OutputStream(), logOut),
+		withLogOut(cli.ErrorStream(), logOut),
+	)
+	return cio.NewCreator(cio.WithStreams(in, out, errOut)), nil, nil
+}
+
+func withLogOut(out io.Writer, logOut io.Writer) io.Writer {
+	if logOut == nil {
+		return out
+	}
+	if out == nil {
+		return logOut
+	}
+	return io.MultiWriter(out, logOut)
 }
 
 type inCloser struct {
@@ -351,3 +1103,34 @@ func (s *inCloser) Close() error {
 	s.closed = true
 	return nil
 }
+
+func listTargetsContainerd(ctx context.Context, cli cliutil.CLI, opts *options) ([]string, error) {
+	client, err := containerd.NewClient(containerd.Options{
+		Out:       cli.AuxStream(),
+		Address:   opts.runtime,
+		Namespace: opts.namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ctx = namespaces.WithNamespace(ctx, client.Namespace())
+
+	containers, err := client.ListRunningContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, c := range containers {
+		name := c.ID
+		if opts.schema == schemaNerdctl && c.Labels["nerdctl/name"] != "" {
+			name = c.Labels["nerdctl/name"]
+		}
+		if ok, _ := path.Match(opts.allMatching, name); ok {
+			targets = append(targets, name)
+		}
+	}
+	return targets, nil
+}
@@ -2,14 +2,26 @@ package exec
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/stdcopy"
+	mobysignal "github.com/moby/sys/signal"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 
 	"github.com/iximiuz/cdebug/pkg/cliutil"
 	"github.com/iximiuz/cdebug/pkg/docker"
@@ -26,14 +38,42 @@ func runDebuggerDocker(ctx context.Context, cli cliutil.CLI, opts *options) erro
 		return err
 	}
 
+	if opts.target == "" && opts.labelSelector != "" {
+		opts.target, err = targetByLabelsDocker(ctx, client, opts.labelSelector)
+		if err != nil {
+			return err
+		}
+	}
+
 	target, err := client.ContainerInspect(ctx, opts.target)
 	if err != nil {
 		return err
 	}
-	if target.State == nil || !target.State.Running {
+	stopped := target.State == nil || !target.State.Running
+	if stopped && !opts.rootfs {
 		return errTargetNotRunning
 	}
 
+	if opts.execInTarget {
+		if stopped || target.Platform == "windows" {
+			return errors.New("--exec-in-target flag requires a running Linux target")
+		}
+		return execInTargetDocker(ctx, cli, client, opts, target)
+	}
+
+	isWindows := target.Platform == "windows"
+	if isWindows {
+		if err := validateWindowsOptions(opts); err != nil {
+			return err
+		}
+		if stopped {
+			return errTargetNotRunning
+		}
+		if opts.image == defaultToolkitImage {
+			opts.image = defaultWindowsToolkitImage
+		}
+	}
+
 	platform := opts.platform
 	if len(platform) == 0 {
 		platform = target.Platform
@@ -48,56 +88,267 @@ func runDebuggerDocker(ctx context.Context, cli cliutil.CLI, opts *options) erro
 		if err := client.ImagePullEx(ctx, opts.image, types.ImagePullOptions{
 			Platform: platform,
 		}); err != nil {
-			return errCannotPull(opts.image, err)
+			if loadCachedImage(ctx, cli, client, opts) != nil {
+				return errCannotPull(opts.image, err)
+			}
+		} else {
+			saveCachedImage(ctx, cli, client, opts)
+		}
+	}
+
+	if opts.waitFor > 0 {
+		if stopped {
+			return errors.New("--wait-for flag requires a running target")
+		}
+		probe, err := waitForProbeDocker(ctx, cli, client, target, opts.waitFor)
+		if err != nil {
+			return err
+		}
+		if err := waitForPort(ctx, cli, opts, probe); err != nil {
+			return err
 		}
 	}
 
 	runID := uuid.ShortID()
+	useChroot := isRootUser(opts.user) && !isWindows
+	if useChroot && target.HostConfig.ReadonlyRootfs && !opts.snapshot {
+		cli.PrintAux("Target has read-only rootfs, using simple mode\n")
+		useChroot = false
+	}
+	if useChroot && opts.pid == pidNone {
+		cli.PrintAux("Target processes are not visible with --pid none, using simple mode\n")
+		useChroot = false
+	}
+	if useChroot && opts.hostRootfs {
+		cli.PrintAux("The host's rootfs is not visible from the target's rootfs, using simple mode\n")
+		useChroot = false
+	}
 	nsMode := "container:" + target.ID
+
+	var binds []string
+	if stopped {
+		cli.PrintAux("Target is not running, exporting its rootfs...\n")
+		dir, err := exportRootfsDocker(ctx, client, target.ID)
+		defer cleanupExportedRootfs(cli, opts, dir)
+		if err != nil {
+			return fmt.Errorf("cannot export target's rootfs: %w", err)
+		}
+
+		binds = []string{dir + ":" + stoppedTargetRootfs + ":ro"}
+		opts.targetRootfs = stoppedTargetRootfs
+		useChroot = false
+
+		// Namespaces of a stopped container cannot be joined.
+		nsMode = ""
+	}
+	if useChroot && len(opts.dns)+len(opts.dnsSearch) > 0 {
+		// The chrooted shell reads the target's /etc/resolv.conf and /etc/hosts.
+		return errors.New("--dns and --dns-search flags are not supported in the chroot mode (use a non-root --user to run the debugger in the simple mode)")
+	}
+	if useChroot && len(opts.addHosts) > 0 {
+		return errors.New("--add-host flag is not supported in the chroot mode (use a non-root --user to run the debugger in the simple mode)")
+	}
+	if opts.snapshot {
+		if stopped {
+			return errors.New("--snapshot flag requires a running target (use --rootfs for stopped ones)")
+		}
+
+		cli.PrintAux("Taking a snapshot of the target's rootfs...\n")
+		dir, err := exportRootfsDocker(ctx, client, target.ID)
+		defer cleanupSnapshot(cli, opts, dir)
+		if err != nil {
+			return fmt.Errorf("cannot snapshot target's rootfs: %w", err)
+		}
+
+		binds = append(binds, dir+":"+snapshotTargetRootfs)
+		opts.targetRootfs = snapshotTargetRootfs
+	}
+	netMode := nsMode
+	if opts.network != networkContainer {
+		netMode = opts.network
+	}
+	var networkingConfig *network.NetworkingConfig
+	if opts.networkAlias != "" {
+		// Aliases don't work with the container:<id> mode - the debugger
+		// has to become a separate endpoint in the target's network.
+		netMode, err = targetNetworkDocker(target, opts.network)
+		if err != nil {
+			return err
+		}
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				netMode: {Aliases: []string{opts.networkAlias}},
+			},
+		}
+		cli.PrintAux("Debugger will be reachable from the target as %s (network %s)\n", opts.networkAlias, netMode)
+	}
+	pidMode := nsMode
+	switch opts.pid {
+	case pidHost:
+		pidMode = "host"
+	case pidNone:
+		pidMode = ""
+	}
+	ipcMode := ""
+	switch opts.ipc {
+	case ipcContainer:
+		ipcMode = nsMode
+	case ipcHost:
+		ipcMode = "host"
+	}
+	var dns, dnsSearch []string
+	if len(opts.dns)+len(opts.dnsSearch) > 0 {
+		if nm := container.NetworkMode(netMode); nm.IsContainer() || nm.IsHost() {
+			// Docker rejects custom DNS settings in these network modes,
+			// so the debugger gets its own resolv.conf instead.
+			base, err := os.ReadFile(target.ResolvConfPath)
+			if err != nil {
+				logrus.Debugf("Cannot read target's resolv.conf: %s", err)
+			}
+			resolvConf, err := writeDebuggerFile("cdebug-resolv-conf-", debuggerResolvConf(base, opts.dns, opts.dnsSearch))
+			if err != nil {
+				return fmt.Errorf("cannot create resolv.conf for debugger container: %w", err)
+			}
+			defer os.Remove(resolvConf)
+
+			binds = append(binds, resolvConf+":/etc/resolv.conf:ro")
+		} else {
+			dns, dnsSearch = opts.dns, opts.dnsSearch
+		}
+	}
+	var extraHosts []string
+	if len(opts.addHosts) > 0 {
+		if nm := container.NetworkMode(netMode); nm.IsContainer() || nm.IsHost() {
+			// Same as with DNS - ExtraHosts conflict with these network modes.
+			base, err := os.ReadFile(target.HostsPath)
+			if err != nil {
+				logrus.Debugf("Cannot read target's hosts file: %s", err)
+				base = []byte("127.0.0.1\tlocalhost\n")
+			}
+			hosts, err := writeDebuggerFile("cdebug-hosts-", debuggerHosts(base, opts.addHosts))
+			if err != nil {
+				return fmt.Errorf("cannot create hosts file for debugger container: %w", err)
+			}
+			defer os.Remove(hosts)
+
+			binds = append(binds, hosts+":/etc/hosts")
+		} else {
+			extraHosts = opts.addHosts
+		}
+	}
+	cgroupParent := opts.cgroupParent
+	if opts.cgroup && !isWindows {
+		cgroupParent, err = targetCgroupDocker(ctx, client, target)
+		if err != nil {
+			return err
+		}
+	}
 	targetPID := 1
-	if target.HostConfig.PidMode.IsHost() {
+	if target.HostConfig.PidMode.IsHost() || opts.pid == pidHost {
 		targetPID = target.State.Pid
 	}
 
-	resp, err := client.ContainerCreate(
-		ctx,
-		&container.Config{
-			Image:      opts.image,
-			Entrypoint: []string{"sh"},
-			Cmd: []string{"-c", debuggerEntrypoint(
-				cli, runID, targetPID, opts.image, opts.cmd, isRootUser(opts.user),
-			)},
-			Tty:          opts.tty,
-			OpenStdin:    opts.stdin,
-			AttachStdin:  opts.stdin,
-			AttachStdout: true,
-			AttachStderr: true,
-			User:         opts.user,
-		},
-		&container.HostConfig{
-			Privileged: target.HostConfig.Privileged || opts.privileged,
-			CapAdd:     target.HostConfig.CapAdd,
-			CapDrop:    target.HostConfig.CapDrop,
+	entrypoint, args := debuggerCommand(opts, debuggerEntrypoint(
+		cli, runID, targetPID, opts, useChroot,
+	))
+
+	config := &container.Config{
+		Image:        opts.image,
+		Entrypoint:   entrypoint,
+		Cmd:          args,
+		Tty:          opts.tty,
+		OpenStdin:    opts.stdin || opts.attachStdinOnSignal != "",
+		AttachStdin:  opts.stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		User:         opts.user,
+	}
+	if len(opts.overrideEnv) > 0 {
+		config.Env = mergeTargetEnv(target.Config.Env, opts.overrideEnv)
+	}
+	if opts.sshAuthSock != "" {
+		// In the chroot mode, the entrypoint re-points it to $CDEBUG_ROOTFS.
+		config.Env = append(config.Env, "SSH_AUTH_SOCK="+opts.sshAuthSock)
+		binds = append(binds, opts.sshAuthSock+":"+opts.sshAuthSock)
+	}
+	if opts.hostRootfs {
+		// PID 1 of the daemon's PID namespace is the host's init.
+		binds = append(binds, "/proc/1/root:"+hostRootfsMountpoint)
+	}
+	createdVolumes, err := ensureVolumesDocker(ctx, cli, client, opts)
+	if err != nil {
+		return err
+	}
+	binds = append(binds, opts.volumes...)
+	hostConfig := &container.HostConfig{
+		Privileged: target.HostConfig.Privileged || opts.privileged,
+		CapAdd:     debuggerCapAdd(opts, target.HostConfig.CapAdd),
+		CapDrop:    target.HostConfig.CapDrop,
 
-			AutoRemove: opts.autoRemove,
+		// The debugger container may have to outlive the session to copy files or logs from it.
+		AutoRemove: opts.autoRemove && !debuggerOutlivesSession(opts),
 
-			NetworkMode: container.NetworkMode(nsMode),
-			PidMode:     container.PidMode(nsMode),
-			// UTSMode:     container.UTSMode(nsMode),  <-- stopped working in Docker 1.23 for some reason
-			// TODO: CgroupnsMode: container.CgroupnsMode(nsMode),
-			// TODO: IpcMode:      container.IpcMode(nsMode)
-			// TODO: UsernsMode:   container.UsernsMode(target)
+		NetworkMode: container.NetworkMode(netMode),
+		PidMode:     container.PidMode(pidMode),
+		IpcMode:     container.IpcMode(ipcMode),
+		VolumesFrom: volumesFromDocker(opts),
+		Mounts:      sharedMountsDocker(opts, target.Mounts),
+		ShmSize:     opts.shmSize,
+		Binds:       binds,
+		DNS:         dns,
+		DNSSearch:   dnsSearch,
+		ExtraHosts:  extraHosts,
+		// UTSMode:     container.UTSMode(nsMode),  <-- stopped working in Docker 1.23 for some reason
+		// TODO: CgroupnsMode: container.CgroupnsMode(nsMode),
+		// TODO: UsernsMode:   container.UsernsMode(target)
 
-			Init: ptr(false),
+		Resources: container.Resources{
+			CgroupParent: cgroupParent,
+			Memory:       opts.memory,
+			CPUQuota:     opts.cpuQuota,
 		},
+
+		Init: ptr(false),
+	}
+	if isWindows {
+		config, hostConfig = windowsDebuggerConfig(opts, target)
+	}
+
+	if opts.override != "" {
+		spec, err := docker.Override(docker.ContainerSpec{
+			Config:     config,
+			HostConfig: hostConfig,
+		}, opts.override, opts.overrideType)
+		if err != nil {
+			return fmt.Errorf("cannot override debugger container: %w", err)
+		}
+		config, hostConfig = spec.Config, spec.HostConfig
+	}
+
+	name := debuggerName(opts.name)
+	resp, err := client.ContainerCreate(
+		ctx,
+		config,
+		hostConfig,
+		networkingConfig,
 		nil,
-		nil,
-		debuggerName(opts.name, runID),
+		name,
 	)
 	if err != nil {
+		removeVolumesDocker(cli, client, opts, "", createdVolumes)
 		return errCannotCreate(err)
 	}
 
+	for _, spec := range copyToSpecs(opts) {
+		if err := copyToContainerDocker(ctx, client, resp.ID, spec); err != nil {
+			// Never started, so the --rm (auto-removal) doesn't apply to it.
+			removeCreatedDebuggerDocker(client, resp.ID)
+			removeVolumesDocker(cli, client, opts, "", createdVolumes)
+			return fmt.Errorf("cannot copy %s to debugger container: %w", spec.hostPath, err)
+		}
+	}
+	defer removeVolumesDocker(cli, client, opts, resp.ID, createdVolumes)
+
 	if !opts.detach {
 		close, err := attachDebugger(ctx, cli, client, opts, resp.ID)
 		if err != nil {
@@ -110,8 +361,19 @@ func runDebuggerDocker(ctx context.Context, cli cliutil.CLI, opts *options) erro
 		return fmt.Errorf("cannot start debugger container: %w", err)
 	}
 
+	printDebuggerInfo(cli, opts, debuggerInfo{Name: name, ID: resp.ID}, nil)
+	if opts.detach {
+		printDetachedDebugger(cli, opts, name)
+	}
+
+	if opts.attachStdinOnSignal != "" {
+		go attachStdinOnSignal(ctx, cli, client, opts.attachStdinOnSignal, resp.ID)
+	}
+
 	if !opts.detach {
-		if opts.tty && cli.OutputStream().IsTerminal() {
+		if opts.tty && opts.ttyCols > 0 {
+			tty.SetSize(ctx, client, resp.ID, opts.ttyRows, opts.ttyCols)
+		} else if opts.tty && cli.OutputStream().IsTerminal() {
 			tty.StartResizing(ctx, cli.OutputStream(), client, resp.ID)
 		}
 
@@ -121,13 +383,500 @@ func runDebuggerDocker(ctx context.Context, cli cliutil.CLI, opts *options) erro
 			if err != nil {
 				return fmt.Errorf("waiting debugger container failed: %w", err)
 			}
+		case status := <-statusCh:
+			if opts.onExit != nil {
+				opts.onExit(target.ID, int(status.StatusCode))
+			}
+		}
+	}
+
+	if len(opts.copyFrom) > 0 {
+		// In the chroot mode, the debugger's rootfs is the target's rootfs.
+		source := resp.ID
+		if useChroot {
+			source = target.ID
+		}
+
+		for _, spec := range copyFromSpecs(opts.copyFrom) {
+			if err := copyFromContainerDocker(ctx, client, source, spec); err != nil {
+				cli.PrintErr("Warning: cannot copy %s from the debugger container: %s\n", spec.containerPath, err)
+			}
+		}
+	}
+
+	if opts.logFile != "" {
+		if err := saveDebuggerLogsDocker(ctx, client, resp.ID, opts.tty, opts.logFile); err != nil {
+			cli.PrintErr("Warning: cannot save debugger logs: %s\n", err)
+		}
+	}
+
+	if opts.autoRemove && debuggerOutlivesSession(opts) {
+		if err := client.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			logrus.Debugf("Cannot remove debugger container: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func saveDebuggerLogsDocker(
+	ctx context.Context,
+	client *docker.Client,
+	contID string,
+	tty bool,
+	logFile string,
+) error {
+	logs, err := client.ContainerLogs(ctx, contID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	f, err := os.Create(logFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if tty {
+		// TTY logs aren't multiplexed - the raw bytes are written as is.
+		_, err = io.Copy(f, logs)
+	} else {
+		_, err = stdcopy.StdCopy(f, f, logs)
+	}
+	return err
+}
+
+// waitForProbeDocker dials the target's port directly unless the daemon is
+// rootless - then the target's network namespace is inspected via /proc
+// instead (cdebug must run on the same host as the daemon).
+func waitForProbeDocker(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client *docker.Client,
+	target types.ContainerJSON,
+	port int,
+) (func() bool, error) {
+	rootless, err := client.IsRootless(ctx)
+	if err != nil {
+		logrus.Debugf("Cannot detect rootless Docker: %s", err)
+	}
+	if rootless {
+		// Even --network host means the daemon's network namespace here.
+		cli.PrintErr("Warning: rootless Docker detected - the target's network is not reachable from the host, " +
+			"checking the target's listening sockets instead\n")
+		return listeningProbe(uint32(target.State.Pid), port), nil
+	}
+
+	host, err := targetAddrDocker(target)
+	if err != nil {
+		return nil, err
+	}
+	return dialProbe(host, port), nil
+}
+
+// targetAddrDocker returns the address to probe the --wait-for port at.
+// The container IPs are reachable only from the Docker host (or VM).
+func targetAddrDocker(target types.ContainerJSON) (string, error) {
+	if target.HostConfig.NetworkMode.IsHost() {
+		return "127.0.0.1", nil
+	}
+	if target.NetworkSettings != nil {
+		if target.NetworkSettings.IPAddress != "" {
+			return target.NetworkSettings.IPAddress, nil
+		}
+		for _, n := range target.NetworkSettings.Networks {
+			if n.IPAddress != "" {
+				return n.IPAddress, nil
+			}
+		}
+	}
+	return "", errors.New("cannot determine the target's IP address for --wait-for")
+}
+
+// exportRootfsDocker extracts the container's filesystem into
+// a temporary directory (that has to be removed by the caller).
+func exportRootfsDocker(
+	ctx context.Context,
+	client *docker.Client,
+	contID string,
+) (string, error) {
+	dir, err := os.MkdirTemp("", "cdebug-rootfs-")
+	if err != nil {
+		return "", err
+	}
+
+	content, err := client.ContainerExport(ctx, contID)
+	if err != nil {
+		return dir, err
+	}
+	defer content.Close()
+
+	return dir, archive.Untar(content, dir, &archive.TarOptions{
+		NoLchown: os.Geteuid() != 0,
+	})
+}
+
+// targetCgroupDocker returns the target's own cgroup to be used as the
+// debugger's cgroup parent (i.e., the debugger gets a child cgroup). Only
+// the cgroupfs driver accepts arbitrary paths as a cgroup parent - the
+// systemd one expects a slice, and a container's scope cannot have children.
+func targetCgroupDocker(
+	ctx context.Context,
+	client *docker.Client,
+	target types.ContainerJSON,
+) (string, error) {
+	info, err := client.Info(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot get Docker info: %w", err)
+	}
+	if info.CgroupDriver != "cgroupfs" {
+		return "", fmt.Errorf("--cgroup flag is supported only with the cgroupfs cgroup driver (the daemon uses %q; use --cgroup-parent with a systemd slice instead)", info.CgroupDriver)
+	}
+
+	// The exact path (accounts for the daemon's default cgroup parent),
+	// but readable only on the Docker host.
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", target.State.Pid)); err == nil {
+		if cgroup, err := parseProcCgroup(string(data)); err == nil && cgroup != "/" {
+			return cgroup, nil
+		}
+	}
+
+	parent := target.HostConfig.CgroupParent
+	if parent == "" {
+		parent = "/docker"
+	}
+	return path.Join("/", parent, target.ID), nil
+}
+
+// parseProcCgroup extracts the cgroup path from the /proc/<pid>/cgroup
+// contents, preferring the unified (v2) hierarchy.
+func parseProcCgroup(data string) (string, error) {
+	var v1Path string
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2], nil
+		}
+		if v1Path == "" {
+			v1Path = parts[2]
+		}
+	}
+
+	if v1Path == "" {
+		return "", errors.New("cannot parse target's cgroup")
+	}
+	return v1Path, nil
+}
+
+func volumesFromDocker(opts *options) []string {
+	if opts.volumesFrom == "" {
+		return nil
+	}
+	return []string{opts.volumesFrom}
+}
+
+// ensureVolumesDocker creates the named --volume(s) that don't exist yet
+// using the --volume-driver and --volume-opt(s). The existing volumes are
+// reused as is. Returns the names of the newly created volumes.
+func ensureVolumesDocker(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client *docker.Client,
+	opts *options,
+) ([]string, error) {
+	driverOpts, err := parseVolumeOpts(opts.volumeOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var created []string
+	for _, spec := range opts.volumes {
+		name, _, _, _ := parseVolumeSpec(spec)
+		if !isNamedVolume(name) {
+			continue
+		}
+
+		existing, err := client.VolumeInspect(ctx, name)
+		if err == nil {
+			if opts.volumeDriver != "" && existing.Driver != opts.volumeDriver {
+				cli.PrintErr("Warning: volume %s already exists with driver %s, --volume-driver is ignored\n", name, existing.Driver)
+			}
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return created, fmt.Errorf("cannot inspect volume %s: %w", name, err)
+		}
+
+		if _, err := client.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       name,
+			Driver:     opts.volumeDriver,
+			DriverOpts: driverOpts,
+		}); err != nil {
+			removeVolumesDocker(cli, client, opts, "", created)
+			return nil, fmt.Errorf("cannot create volume %s: %w", name, err)
+		}
+		cli.PrintAux("Created volume %s\n", name)
+		created = append(created, name)
+	}
+	return created, nil
+}
+
+// removeCreatedDebuggerDocker removes a debugger container that failed
+// to be set up before it was started.
+func removeCreatedDebuggerDocker(client *docker.Client, contID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.ContainerRemove(ctx, contID, container.RemoveOptions{Force: true}); err != nil {
+		logrus.Debugf("Cannot remove debugger container: %s", err)
+	}
+}
+
+// removeVolumesDocker removes the volumes created by ensureVolumesDocker
+// once the debugger container is gone. A volume cannot be removed while
+// a container (even an exited one) uses it, so the volumes of a debugger
+// that is kept around (no --rm, or -d) are kept as well.
+func removeVolumesDocker(
+	cli cliutil.CLI,
+	client *docker.Client,
+	opts *options,
+	contID string,
+	volumes []string,
+) {
+	if len(volumes) == 0 || opts.noCleanup {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if contID != "" {
+		if !opts.autoRemove || opts.detach {
+			cli.PrintAux("Keeping volume(s) %s used by the debugger container\n", strings.Join(volumes, ", "))
+			return
+		}
+
+		// The daemon removes the --rm container asynchronously.
+		statusCh, errCh := client.ContainerWait(ctx, contID, container.WaitConditionRemoved)
+		select {
 		case <-statusCh:
+		case err := <-errCh:
+			logrus.Debugf("Waiting for debugger container removal: %s", err)
+		}
+	}
+
+	for _, name := range volumes {
+		if err := client.VolumeRemove(ctx, name, false); err != nil {
+			cli.PrintErr("Warning: cannot remove volume %s: %s\n", name, err)
 		}
 	}
+}
+
+// sharedMountsDocker replicates the target's (non-system) mounts (--share-mounts).
+func sharedMountsDocker(opts *options, targetMounts []types.MountPoint) []mount.Mount {
+	if !opts.shareMounts {
+		return nil
+	}
+
+	var mounts []mount.Mount
+	for _, m := range targetMounts {
+		if isSystemMount(m.Destination) {
+			continue
+		}
 
+		source := m.Source
+		if m.Type == mount.TypeVolume {
+			source = m.Name
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     m.Type,
+			Source:   source,
+			Target:   m.Destination,
+			ReadOnly: !m.RW,
+		})
+	}
+	return mounts
+}
+
+func debuggerCapAdd(opts *options, targetCapAdd []string) []string {
+	capAdd := append([]string{}, targetCapAdd...)
+	if opts.ptrace {
+		capAdd = append(capAdd, "SYS_PTRACE")
+	}
+	return capAdd
+}
+
+func copyFromContainerDocker(
+	ctx context.Context,
+	client *docker.Client,
+	contID string,
+	spec copySpec,
+) error {
+	content, stat, err := client.CopyFromContainer(ctx, contID, spec.containerPath)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return archive.CopyTo(content, archive.CopyInfo{
+		Path:   spec.containerPath,
+		Exists: true,
+		IsDir:  stat.Mode.IsDir(),
+	}, spec.hostPath)
+}
+
+// copyToContainerDocker follows the `docker cp HOST_PATH CONTAINER:PATH` semantics.
+func copyToContainerDocker(
+	ctx context.Context,
+	client *docker.Client,
+	contID string,
+	spec copySpec,
+) error {
+	srcInfo, err := archive.CopyInfoSourcePath(spec.hostPath, false)
+	if err != nil {
+		return err
+	}
+
+	srcArchive, err := archive.TarResource(srcInfo)
+	if err != nil {
+		return err
+	}
+	defer srcArchive.Close()
+
+	dstInfo := archive.CopyInfo{Path: spec.containerPath}
+	if stat, err := client.ContainerStatPath(ctx, contID, spec.containerPath); err == nil {
+		dstInfo.Exists = true
+		dstInfo.IsDir = stat.Mode.IsDir()
+	}
+
+	dstDir, content, err := archive.PrepareArchiveCopy(srcArchive, srcInfo, dstInfo)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return client.CopyToContainer(ctx, contID, dstDir, content, types.CopyToContainerOptions{})
+}
+
+// There are no Linux namespaces and /proc/<pid>/root on Windows. The best
+// we can do is to join the target's network compartment, so the debugger
+// won't see the target's filesystem and processes.
+func windowsDebuggerConfig(
+	opts *options,
+	target types.ContainerJSON,
+) (*container.Config, *container.HostConfig) {
+	cmd := opts.cmd
+	if len(cmd) == 0 {
+		cmd = []string{"cmd.exe"}
+	}
+
+	return &container.Config{
+		Image:        opts.image,
+		Entrypoint:   []string{"cmd.exe", "/S", "/C"},
+		Cmd:          cmd,
+		Tty:          opts.tty,
+		OpenStdin:    opts.stdin,
+		AttachStdin:  opts.stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		User:         opts.user,
+	}, &container.HostConfig{
+		AutoRemove:  opts.autoRemove && !debuggerOutlivesSession(opts),
+		Isolation:   container.IsolationProcess,
+		NetworkMode: container.NetworkMode("container:" + target.ID),
+		Resources: container.Resources{
+			Memory: opts.memory,
+		},
+	}
+}
+
+// windowsFlags are the flags supported for Windows targets. Most of the
+// others rely on Linux namespaces, chroot, or shell scripts, so any flag
+// that isn't listed here (including the newly added ones) is rejected.
+var windowsFlags = map[string]bool{
+	"all":                     true,
+	"all-matching":            true,
+	"detach":                  true,
+	"image":                   true,
+	"image-arch":              true,
+	"image-cache":             true,
+	"image-os":                true,
+	"image-pull-timeout":      true,
+	"interactive":             true,
+	"interactive-no-tty-echo": true,
+	"label-selector":          true,
+	"log-file":                true,
+	"log-level":               true,
+	"memory":                  true,
+	"name":                    true,
+	"no-cleanup":              true,
+	"on-exit":                 true,
+	"output":                  true,
+	"override":                true,
+	"override-type":           true,
+	"parallel":                true,
+	"platform":                true,
+	"quiet":                   true,
+	"report-file":             true,
+	"rm":                      true,
+	"runtime":                 true,
+	"stdin-prompt":            true,
+	"tee":                     true,
+	"tty":                     true,
+	"tty-cols":                true,
+	"tty-rows":                true,
+	"user":                    true,
+}
+
+func validateWindowsOptions(opts *options) error {
+	if opts.flags == nil {
+		return nil
+	}
+
+	var unsupported string
+	opts.flags.Visit(func(flag *pflag.Flag) {
+		if unsupported == "" && !windowsFlags[flag.Name] {
+			unsupported = flag.Name
+		}
+	})
+	if unsupported != "" {
+		return fmt.Errorf("--%s flag is not supported for Windows containers", unsupported)
+	}
 	return nil
 }
 
+// targetNetworkDocker picks the target's network to attach the debugger to
+// with --network-alias: the --network one, or the target's only network.
+func targetNetworkDocker(target types.ContainerJSON, requested string) (string, error) {
+	var name string
+	if requested != networkContainer {
+		if _, ok := target.NetworkSettings.Networks[requested]; !ok {
+			return "", fmt.Errorf("target is not attached to network %q", requested)
+		}
+		name = requested
+	} else {
+		if len(target.NetworkSettings.Networks) != 1 {
+			return "", errors.New("target is attached to more than one network, use --network to choose one for --network-alias")
+		}
+		for n := range target.NetworkSettings.Networks {
+			name = n
+		}
+	}
+
+	if name == networkBridge {
+		// No embedded DNS on the default bridge network.
+		return "", errors.New("--network-alias flag requires a user-defined network (the target is on the default bridge network)")
+	}
+	return name, nil
+}
+
 func attachDebugger(
 	ctx context.Context,
 	cli cliutil.CLI,
@@ -135,19 +884,23 @@ func attachDebugger(
 	opts *options,
 	contID string,
 ) (func(), error) {
-	resp, err := client.ContainerAttach(ctx, contID, container.AttachOptions{
-		Stream: true,
-		Stdin:  opts.stdin,
-		Stdout: true,
-		Stderr: true,
+	var resp types.HijackedResponse
+	err := retryNotFound(ctx, "Attaching to debugger container", errdefs.IsNotFound, func() (err error) {
+		resp, err = client.ContainerAttach(ctx, contID, container.AttachOptions{
+			Stream: true,
+			Stdin:  opts.stdin,
+			Stdout: true,
+			Stderr: true,
+		})
+		return err
 	})
 	if err != nil {
 		return nil, fmt.Errorf("cannot attach to debugger container: %w", err)
 	}
 
-	var cin io.ReadCloser
+	var cin io.Reader
 	if opts.stdin {
-		cin = cli.InputStream()
+		cin = echoStdin(cli, opts, cli.InputStream())
 	}
 
 	var cout io.Writer = cli.OutputStream()
@@ -155,10 +908,12 @@ func attachDebugger(
 	if opts.tty {
 		cerr = cli.OutputStream()
 	}
+	cin, cout, cerr = teeStreams(opts, cin, cout, cerr)
 
 	go func() {
 		s := ioStreamer{
 			streams:      cli,
+			prompt:       stdinPrompt(opts),
 			inputStream:  cin,
 			outputStream: cout,
 			errorStream:  cerr,
@@ -175,10 +930,136 @@ func attachDebugger(
 	return resp.Close, nil
 }
 
+// execInTargetDocker runs the command right in the target container via
+// "docker exec" (no debugger container is created, so there is nothing to
+// --rm). The exec-ed process shares all the namespaces of the target's
+// PID 1 already, but the PID 1 itself is left intact.
+func execInTargetDocker(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client *docker.Client,
+	opts *options,
+	target types.ContainerJSON,
+) error {
+	cmd := opts.cmd
+	if len(cmd) == 0 {
+		cmd = []string{"sh"}
+	}
+
+	exec, err := client.ContainerExecCreate(ctx, target.ID, types.ExecConfig{
+		User:         opts.user,
+		Privileged:   opts.privileged,
+		Tty:          opts.tty,
+		AttachStdin:  opts.stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create exec session in target container: %w", err)
+	}
+
+	resp, err := client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{Tty: opts.tty})
+	if err != nil {
+		return fmt.Errorf("cannot attach to exec session: %w", err)
+	}
+	defer resp.Close()
+
+	if opts.tty && opts.ttyCols > 0 {
+		tty.SetExecSize(ctx, client, exec.ID, opts.ttyRows, opts.ttyCols)
+	} else if opts.tty && cli.OutputStream().IsTerminal() {
+		tty.StartResizingExec(ctx, cli.OutputStream(), client, exec.ID)
+	}
+
+	var cin io.Reader
+	if opts.stdin {
+		cin = cli.InputStream()
+	}
+
+	var cout io.Writer = cli.OutputStream()
+	var cerr io.Writer = cli.ErrorStream()
+	if opts.tty {
+		cerr = cli.OutputStream()
+	}
+	cin, cout, cerr = teeStreams(opts, cin, cout, cerr)
+
+	s := ioStreamer{
+		streams:      cli,
+		prompt:       stdinPrompt(opts),
+		inputStream:  cin,
+		outputStream: cout,
+		errorStream:  cerr,
+		resp:         resp,
+		tty:          opts.tty,
+		stdin:        opts.stdin,
+	}
+	if err := s.stream(ctx); err != nil {
+		return err
+	}
+
+	inspect, err := client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return fmt.Errorf("cannot inspect exec session: %w", err)
+	}
+	if opts.onExit != nil {
+		opts.onExit(target.ID, inspect.ExitCode)
+	}
+	return nil
+}
+
+// attachStdinOnSignal waits for the given signal and only then starts
+// forwarding cdebug's stdin to the (already running) debugger.
+func attachStdinOnSignal(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client *docker.Client,
+	sigName string,
+	contID string,
+) {
+	sig, err := mobysignal.ParseSignal(sigName)
+	if err != nil {
+		logrus.Debugf("Cannot parse signal %q: %s", sigName, err)
+		return
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sig)
+	defer signal.Stop(sigCh)
+
+	cli.PrintAux("Send signal %s to PID %d to attach stdin to the debugger\n", strings.ToUpper(sigName), os.Getpid())
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-sigCh:
+	}
+
+	resp, err := client.ContainerAttach(ctx, contID, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+	})
+	if err != nil {
+		cli.PrintErr("Warning: cannot attach stdin to debugger container: %s\n", err)
+		return
+	}
+	defer resp.Close()
+
+	cli.PrintAux("Stdin attached\n")
+	if _, err := io.Copy(resp.Conn, cli.InputStream()); err != nil {
+		logrus.Debugf("Error forwarding stdin: %s", err)
+	}
+	if err := resp.CloseWrite(); err != nil {
+		logrus.Debugf("Cannot close debugger's stdin: %s", err)
+	}
+}
+
 type ioStreamer struct {
 	streams cliutil.Streams
 
-	inputStream  io.ReadCloser
+	// Printed right before stdin is forwarded (if not empty).
+	prompt string
+
+	inputStream  io.Reader
 	outputStream io.Writer
 	errorStream  io.Writer
 
@@ -201,6 +1082,9 @@ func (s *ioStreamer) stream(ctx context.Context) error {
 	inDone := make(chan error)
 	go func() {
 		if s.stdin {
+			if s.prompt != "" {
+				fmt.Fprint(s.outputStream, s.prompt)
+			}
 			if _, err := io.Copy(s.resp.Conn, s.inputStream); err != nil {
 				logrus.Debugf("Error forwarding stdin: %s", err)
 			}
@@ -267,3 +1151,61 @@ func imageExistsLocally(
 
 	return true, nil
 }
+
+func listTargetsDocker(ctx context.Context, cli cliutil.CLI, opts *options) ([]string, error) {
+	client, err := docker.NewClient(docker.Options{
+		Out:  cli.AuxStream(),
+		Host: opts.runtime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	containers, err := client.ListRunningContainers(ctx, labelFilters(opts.labelSelector)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, c := range containers {
+		if ok, _ := path.Match(opts.allMatching, c.Name); ok {
+			targets = append(targets, c.Name)
+		}
+	}
+	return targets, nil
+}
+
+// labelFilters splits the --label-selector into the Docker label filters.
+func labelFilters(selector string) []string {
+	var labels []string
+	for _, label := range strings.Split(selector, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+func targetByLabelsDocker(ctx context.Context, client *docker.Client, selector string) (string, error) {
+	containers, err := client.ListRunningContainers(ctx, labelFilters(selector)...)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(containers) {
+	case 0:
+		return "", fmt.Errorf("no running containers match the label selector %q", selector)
+	case 1:
+		return containers[0].ID, nil
+	}
+
+	var names []string
+	for _, c := range containers {
+		names = append(names, c.Name)
+	}
+	return "", fmt.Errorf(
+		"label selector %q matches %d running containers (%s); narrow it down or use --all",
+		selector, len(containers), strings.Join(names, ", "),
+	)
+}
@@ -1,26 +1,41 @@
 package exec
 
 import (
+	"archive/tar"
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/docker/docker/pkg/archive"
 	"github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/httpstream"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -35,25 +50,95 @@ import (
 	"github.com/iximiuz/cdebug/pkg/uuid"
 )
 
+const (
+	maxCopyToPodSize = 1024 * 1024
+
+	// Scopes the debugger's annotations on the pod: <debugger>.debugger.cdebug.io/<key>.
+	debuggerAnnotationDomain = "debugger.cdebug.io"
+)
+
 // TODO: Handle exit codes - terminate the `cdebug exec` command with the same exit code as the debugger container.
 
 func runDebuggerKubernetes(ctx context.Context, cli cliutil.CLI, opts *options) error {
 	if opts.autoRemove {
 		return fmt.Errorf("--rm flag is not supported for Kubernetes runtime")
 	}
-	if err := validateUserFlag(opts.user); err != nil {
+	if opts.network != networkContainer && opts.network != networkHost {
+		return fmt.Errorf("--network flag is not supported for Kubernetes runtime (only --network host is)")
+	}
+	if opts.imageCache != "" {
+		return fmt.Errorf("--image-cache flag is not supported for Kubernetes runtime")
+	}
+	if opts.volumesFrom != "" {
+		return fmt.Errorf("--volumes-from flag is not supported for Kubernetes runtime")
+	}
+	if opts.shareMounts && opts.serviceAccount != "" {
+		// The target pod's volumes cannot be mounted into another pod.
+		return fmt.Errorf("--share-mounts flag is not supported with --service-account")
+	}
+	if opts.init {
+		return fmt.Errorf("--init flag is not supported for Kubernetes runtime (ephemeral containers cannot have their own PID namespace)")
+	}
+	if opts.networkAlias != "" {
+		return fmt.Errorf("--network-alias flag is not supported for Kubernetes runtime (containers of a pod share its network namespace)")
+	}
+	if opts.shmSize != 0 {
+		return fmt.Errorf("--shm-size flag is not supported for Kubernetes runtime")
+	}
+	if opts.cgroup {
+		return fmt.Errorf("--cgroup flag is not supported for Kubernetes runtime")
+	}
+	if opts.cgroupParent != "" {
+		return fmt.Errorf("--cgroup-parent flag is not supported for Kubernetes runtime (the kubelet manages the pod cgroups)")
+	}
+	if opts.capInherit && opts.serviceAccount != "" {
+		return fmt.Errorf("--cap-inherit flag is not supported with --service-account (the debug pod doesn't run next to the target)")
+	}
+	if opts.snapshot {
+		return fmt.Errorf("--snapshot flag is not supported for Kubernetes runtime (ephemeral containers cannot have extra volumes)")
+	}
+	if len(opts.dns)+len(opts.dnsSearch) > 0 && opts.serviceAccount == "" {
+		// dnsConfig is a pod-level setting - ephemeral containers cannot have their own.
+		return fmt.Errorf("--dns and --dns-search flags are supported for Kubernetes runtime only with --service-account (ephemeral containers share the pod's DNS config)")
+	}
+	if opts.hostRootfs {
+		// A hostPath volume would have to be added to the pod spec.
+		return fmt.Errorf("--host-rootfs flag is not supported for Kubernetes runtime (use \"kubectl debug node/<name>\" to access the node's filesystem)")
+	}
+	if opts.forwardAgent {
+		// The agent socket is on cdebug's machine, not on the target's node.
+		cli.PrintErr("Warning: --forward-agent is ignored for Kubernetes runtime (exposing a socket to a pod requires a hostPath volume, which usually needs cluster-admin rights)\n")
+	}
+	if (opts.memory > 0 || opts.cpuQuota > 0) && opts.serviceAccount == "" {
+		// The API server rejects ephemeral containers with resources set.
+		return fmt.Errorf("--memory and --cpu-quota flags are supported for Kubernetes runtime only with --service-account (ephemeral containers cannot have resource limits)")
+	}
+	if err := validateUserFlag(opts.user); err != nil && !isUserName(opts.user) {
 		return err
 	}
+	if isUserName(opts.user) && opts.serviceAccount != "" {
+		return fmt.Errorf("--user with a user name is not supported with --service-account (use a numeric UID[:GID])")
+	}
 
 	config, namespace, err := ckubernetes.GetRESTConfig(
 		opts.runtime,
 		opts.kubeconfig,
 		opts.kubeconfigContext,
+		opts.saTokenFile,
+		opts.saCAFile,
 	)
 	if err != nil {
 		return fmt.Errorf("error getting Kubernetes REST config: %v", err)
 	}
 
+	if config.BearerTokenFile != "" {
+		if name, err := ckubernetes.ServiceAccountName(config.BearerTokenFile); err == nil {
+			cli.PrintAux("Using service account %s\n", name)
+		} else {
+			logrus.Debugf("Cannot determine service account name: %s", err)
+		}
+	}
+
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return fmt.Errorf("error creating Kubernetes client: %v", err)
@@ -83,18 +168,150 @@ func runDebuggerKubernetes(ctx context.Context, cli cliutil.CLI, opts *options)
 		CoreV1().
 		Pods(namespace).
 		Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if found := podNamespaces(ctx, client, podName); len(found) > 0 {
+			return fmt.Errorf("pod %q not found in namespace %q, but it exists in namespace(s) %s (use --namespace %s)",
+				podName, namespace, strings.Join(found, ", "), found[0])
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("error getting target pod: %v", err)
 	}
 
+	if opts.shareProcesses && !isTrue(pod.Spec.ShareProcessNamespace) {
+		if !opts.forceRestart {
+			return fmt.Errorf("--share-processes requires pod %q to run with shareProcessNamespace: true, "+
+				"which can be set only by recreating the pod (use --force-restart to do so)", podName)
+		}
+
+		pod, err = recreatePodWithSharedProcesses(ctx, cli, client, pod, targetName)
+		if err != nil {
+			return fmt.Errorf("error recreating target pod: %v", err)
+		}
+	}
+
+	if opts.name != "" && opts.serviceAccount == "" && ephemeralContainerByName(pod, opts.name) != nil {
+		return reattachPodDebugger(ctx, cli, opts, config, client, pod)
+	}
+
+	switch opts.pid {
+	case pidHost:
+		if !pod.Spec.HostPID {
+			return fmt.Errorf("--pid host requires the target pod to run with hostPID: true")
+		}
+	case pidNone:
+		return fmt.Errorf("--pid none is not supported for Kubernetes runtime")
+	}
+
+	// A pod's network namespace cannot be changed, but the standalone
+	// debug pod (--service-account) always uses the host network.
+	if opts.network == networkHost && !pod.Spec.HostNetwork && opts.serviceAccount == "" {
+		return fmt.Errorf("--network host requires the target pod to run with hostNetwork: true (or the --service-account flag)")
+	}
+
+	// Containers of a pod always share its IPC namespace, so only
+	// --ipc host needs special treatment.
+	if opts.ipc == ipcHost {
+		if opts.serviceAccount != "" {
+			cli.PrintErr("Warning: --ipc host sets hostIPC: true on the debug pod, which usually requires cluster-admin privileges\n")
+		} else if !pod.Spec.HostIPC {
+			return fmt.Errorf("--ipc host requires the target pod to run with hostIPC: true (a pod's IPC namespace cannot be changed after creation)")
+		}
+	}
+
+	if opts.waitFor > 0 {
+		// Pod IPs are reachable only from within the cluster network
+		// (e.g., when cdebug runs in a pod or on a node).
+		if pod.Status.PodIP == "" {
+			return fmt.Errorf("--wait-for flag requires the target pod to have an IP address")
+		}
+		if err := waitForPort(ctx, cli, opts, dialProbe(pod.Status.PodIP, opts.waitFor)); err != nil {
+			return err
+		}
+	}
+
+	if opts.serviceAccount == "" {
+		if err := checkEphemeralContainerPermission(ctx, client, namespace); err != nil {
+			return err
+		}
+	}
+
+	if isUserName(opts.user) {
+		user, err := resolveUserKubernetes(ctx, cli, opts, client, pod, targetName)
+		if err != nil {
+			return err
+		}
+		cli.PrintAux("Resolved user %q to %s\n", opts.user, user)
+		opts.user = user
+
+		// The helper container has changed the pod.
+		if pod, err = client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("error getting target pod: %v", err)
+		}
+	}
+
 	runID := uuid.ShortID()
-	debuggerName := debuggerName(opts.name, runID)
+	debuggerName := debuggerName(opts.name)
 	cli.PrintAux("Debugger container name: %s\n", debuggerName)
 
 	cli.PrintAux("Starting debugger container...\n")
 
-	useChroot := isRootUser(opts.user) && !isReadOnlyRootFS(pod, targetName) && !runsAsNonRoot(pod, targetName)
-	if err := runPodDebugger(
+	useChroot := isRootUser(opts.user) && !runsAsNonRoot(pod, targetName) && opts.serviceAccount == ""
+	if useChroot && isReadOnlyRootFS(pod, targetName) {
+		cli.PrintAux("Target has read-only rootfs, using simple mode\n")
+		useChroot = false
+	}
+	entrypoint := debuggerEntrypoint(cli, runID, 1, opts, useChroot)
+
+	if len(opts.addHosts) > 0 {
+		// Ephemeral containers have no hostAliases, so the entries are appended by the entrypoint.
+		if opts.serviceAccount == "" {
+			cli.PrintErr("Warning: --add-host entries are appended to /etc/hosts, which the kubelet shares between all containers of the pod\n")
+		}
+		entrypoint = addHostsScript(opts.addHosts) + entrypoint
+	}
+
+	if specs := copyToSpecs(opts); len(specs) > 0 {
+		script, err := copyToPodScript(cli, specs)
+		if err != nil {
+			return fmt.Errorf("error preparing files for debugger container: %v", err)
+		}
+		entrypoint = script + entrypoint
+	}
+
+	// The pod the debugger container ends up in - either the target pod
+	// (ephemeral container) or a standalone debug pod (--service-account).
+	debuggerPodName := podName
+	keepDebuggerPod := false
+
+	if opts.serviceAccount != "" {
+		cli.PrintErr("Warning: --service-account creates a separate debug pod %q in namespace %q "+
+			"that is visible to cluster operators; it shares only the host namespaces of the target (if any)\n",
+			debuggerName, namespace)
+
+		// The pod is deleted on Ctrl-C, too.
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		defer func() {
+			if !keepDebuggerPod {
+				deleteStandaloneDebugger(cli, client, namespace, debuggerName)
+			}
+		}()
+
+		if err := runStandaloneDebugger(
+			ctx,
+			opts,
+			client,
+			pod,
+			debuggerName,
+			entrypoint,
+		); err != nil {
+			return fmt.Errorf("error creating debugger pod: %v", err)
+		}
+		debuggerPodName = debuggerName
+	} else if err := runPodDebugger(
 		ctx,
 		cli,
 		opts,
@@ -102,38 +319,95 @@ func runDebuggerKubernetes(ctx context.Context, cli cliutil.CLI, opts *options)
 		pod,
 		targetName,
 		debuggerName,
-		debuggerEntrypoint(cli, runID, 1, opts.image, opts.cmd, useChroot),
+		entrypoint,
 	); err != nil {
 		return fmt.Errorf("error adding debugger container: %v", err)
 	}
 
 	if opts.detach {
-		attachCmd := []string{"kubectl", "attach", "-n", namespace, "-c", debuggerName}
-		if opts.stdin {
-			attachCmd = append(attachCmd, "-i")
-		}
-		if opts.tty {
-			attachCmd = append(attachCmd, "-t")
-		}
-		attachCmd = append(attachCmd, podName)
-
+		keepDebuggerPod = true
 		cli.PrintAux("Debugger container %q started in the background.\n", debuggerName)
-		cli.PrintAux("Use %#q if you need to attach to it.\n", strings.Join(attachCmd, " "))
+		printAttachHint(cli, opts, namespace, debuggerPodName, debuggerName)
 		return nil
 	}
 
-	return attachPodDebugger(
+	if err := attachPodDebugger(
 		ctx,
 		cli,
 		opts,
 		config,
 		client,
 		namespace,
-		podName,
+		debuggerPodName,
 		debuggerName,
+		kubernetesTargetID(pod, targetName),
+	); err != nil {
+		return err
+	}
+
+	if opts.logFile != "" {
+		if err := saveDebuggerLogsKubernetes(ctx, client, namespace, debuggerPodName, debuggerName, opts.logFile); err != nil {
+			cli.PrintErr("Warning: cannot save debugger logs: %s\n", err)
+		}
+	}
+
+	// The debugger container cannot be exec-ed into after it has exited,
+	// so the files are copied from the target container instead.
+	for _, spec := range copyFromSpecs(opts.copyFrom) {
+		if err := copyFromPod(ctx, config, client, namespace, podName, targetName, spec); err != nil {
+			cli.PrintErr("Warning: cannot copy %s from the target container: %s\n", spec.containerPath, err)
+		}
+	}
+
+	return nil
+}
+
+// reattachPodDebugger attaches to an ephemeral container left in the pod by
+// a previous session with the same (deterministic) debugger name.
+func reattachPodDebugger(
+	ctx context.Context,
+	cli cliutil.CLI,
+	opts *options,
+	config *restclient.Config,
+	client kubernetes.Interface,
+	pod *corev1.Pod,
+) error {
+	// Ephemeral containers can be neither restarted nor removed.
+	if s := containerStatusByName(pod, opts.name); s != nil && s.State.Terminated != nil {
+		return fmt.Errorf("ephemeral container %q already exists in pod %q and has exited (use another name)", opts.name, pod.Name)
+	}
+
+	cli.PrintAux("Debugger container %q already exists in pod %q, attaching to it...\n", opts.name, pod.Name)
+
+	if opts.detach {
+		printAttachHint(cli, opts, pod.Namespace, pod.Name, opts.name)
+		return nil
+	}
+
+	var targetName string
+	if ec := ephemeralContainerByName(pod, opts.name); ec != nil {
+		targetName = ec.TargetContainerName
+	}
+
+	return attachPodDebugger(
+		ctx, cli, opts, config, client, pod.Namespace, pod.Name, opts.name,
+		kubernetesTargetID(pod, targetName),
 	)
 }
 
+func printAttachHint(cli cliutil.CLI, opts *options, ns string, podName string, debuggerName string) {
+	attachCmd := []string{"kubectl", "attach", "-n", ns, "-c", debuggerName}
+	if opts.stdin {
+		attachCmd = append(attachCmd, "-i")
+	}
+	if opts.tty {
+		attachCmd = append(attachCmd, "-t")
+	}
+	attachCmd = append(attachCmd, podName)
+
+	cli.PrintAux("Use %#q if you need to attach to it.\n", strings.Join(attachCmd, " "))
+}
+
 func runPodDebugger(
 	ctx context.Context,
 	cli cliutil.CLI,
@@ -144,6 +418,18 @@ func runPodDebugger(
 	debuggerName string,
 	entrypoint string,
 ) error {
+	annotations, err := debuggerAnnotations(opts)
+	if err != nil {
+		return err
+	}
+
+	// Before the injection - admission webhooks may look for the annotations.
+	if len(annotations) > 0 {
+		if err := annotateDebuggerPod(ctx, client, pod, debuggerName, annotations); err != nil {
+			return fmt.Errorf("error annotating target pod: %v", err)
+		}
+	}
+
 	podJSON, err := json.Marshal(pod)
 	if err != nil {
 		return fmt.Errorf("error creating JSON for pod: %v", err)
@@ -159,6 +445,16 @@ func runPodDebugger(
 		return fmt.Errorf("error creating JSON for debug container: %v", err)
 	}
 
+	return patchEphemeralContainers(ctx, client, pod, podJSON, debugJSON)
+}
+
+func patchEphemeralContainers(
+	ctx context.Context,
+	client kubernetes.Interface,
+	pod *corev1.Pod,
+	podJSON []byte,
+	debugJSON []byte,
+) error {
 	patch, err := strategicpatch.CreateTwoWayMergePatch(podJSON, debugJSON, pod)
 	if err != nil {
 		return fmt.Errorf("error creating patch to add debug container: %v", err)
@@ -181,6 +477,9 @@ func runPodDebugger(
 		if serr, ok := err.(*apierrors.StatusError); ok && serr.Status().Reason == metav1.StatusReasonNotFound && serr.ErrStatus.Details.Name == "" {
 			return fmt.Errorf("ephemeral containers are disabled for this cluster (error from server: %q)", err)
 		}
+		if apierrors.IsForbidden(err) {
+			return errEphemeralContainersForbidden(pod.Namespace)
+		}
 
 		return err
 	}
@@ -188,6 +487,419 @@ func runPodDebugger(
 	return nil
 }
 
+const ephemeralContainersRule = `  - apiGroups: [""]
+    resources: ["pods/ephemeralcontainers"]
+    verbs: ["patch"]`
+
+func errEphemeralContainersForbidden(namespace string) error {
+	return fmt.Errorf("not allowed to add ephemeral containers to pods in namespace %q; "+
+		"ask your cluster admin for a Role (or ClusterRole) with the following rule:\n%s",
+		namespace, ephemeralContainersRule)
+}
+
+// checkEphemeralContainerPermission asks the API server whether the current
+// user can add ephemeral containers (i.e., patch the pods/ephemeralcontainers
+// subresource) in the namespace - a clear error message beats the cryptic
+// patch rejection. If the access review itself fails, the check is skipped.
+func checkEphemeralContainerPermission(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace string,
+) error {
+	review, err := client.
+		AuthorizationV1().
+		SelfSubjectAccessReviews().
+		Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        "patch",
+					Resource:    "pods",
+					Subresource: "ephemeralcontainers",
+				},
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		logrus.Debugf("Cannot check ephemeral containers permission: %s", err)
+		return nil
+	}
+
+	if !review.Status.Allowed {
+		logrus.Debugf("Ephemeral containers permission denied: %s", review.Status.Reason)
+		return errEphemeralContainersForbidden(namespace)
+	}
+	return nil
+}
+
+// resolveUserKubernetes turns a user name (and optionally a group name) into
+// the numeric UID:GID using the target's /etc/passwd and /etc/group. Unlike
+// the Docker daemon, the API server cannot look into the image, so a short-lived
+// ephemeral container does the lookup (in the target's rootfs via /proc/1/root).
+func resolveUserKubernetes(
+	ctx context.Context,
+	cli cliutil.CLI,
+	opts *options,
+	client kubernetes.Interface,
+	pod *corev1.Pod,
+	targetName string,
+) (string, error) {
+	user, group, _ := strings.Cut(opts.user, ":")
+
+	name := "cdebug-id-" + uuid.ShortID()
+	cli.PrintAux("Resolving user %q with a helper container %s...\n", opts.user, name)
+
+	podJSON, err := json.Marshal(pod)
+	if err != nil {
+		return "", fmt.Errorf("error creating JSON for pod: %v", err)
+	}
+
+	copied := pod.DeepCopy()
+	copied.Spec.EphemeralContainers = append(copied.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    opts.image,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			Command:                  []string{"sh", "-c", resolveUserScript, "sh", user, group},
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+		},
+		TargetContainerName: targetName,
+	})
+
+	debugJSON, err := json.Marshal(copied)
+	if err != nil {
+		return "", fmt.Errorf("error creating JSON for helper container: %v", err)
+	}
+
+	if err := patchEphemeralContainers(ctx, client, pod, podJSON, debugJSON); err != nil {
+		return "", err
+	}
+
+	pod, err = waitForContainer(ctx, cli, client, pod.Namespace, pod.Name, name, false, opts.imagePullTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := dumpDebuggerLogs(ctx, client, pod.Namespace, pod.Name, name, &out); err != nil {
+		return "", fmt.Errorf("error reading helper container logs: %v", err)
+	}
+
+	if s := containerStatusByName(pod, name); s == nil || s.State.Terminated == nil || s.State.Terminated.ExitCode != 0 {
+		return "", fmt.Errorf("cannot resolve user %q: %s", opts.user, strings.TrimSpace(out.String()))
+	}
+
+	resolved := strings.TrimSpace(out.String())
+	if err := validateUserFlag(resolved); err != nil {
+		return "", fmt.Errorf("cannot resolve user %q: unexpected lookup result %q", opts.user, resolved)
+	}
+	return resolved, nil
+}
+
+// Looks up $1 (user) and $2 (optional group, name or number) in the target's
+// rootfs and prints UID:GID. The toolkit image's own files are never used -
+// its users have nothing to do with the target's ones.
+const resolveUserScript = `
+root=/proc/1/root
+[ -r "$root/etc/passwd" ] || { echo "cannot read the target's /etc/passwd (use a numeric UID[:GID])"; exit 1; }
+ids=$(awk -F: -v n="$1" '$1 == n { print $3 ":" $4; exit }' "$root/etc/passwd")
+[ -n "$ids" ] || { echo "no such user in the target's /etc/passwd: $1"; exit 1; }
+uid=${ids%%:*}
+gid=${ids#*:}
+case "$2" in
+  "") ;;
+  *[!0-9]*)
+    gid=$(awk -F: -v n="$2" '$1 == n { print $3; exit }' "$root/etc/group")
+    [ -n "$gid" ] || { echo "no such group in the target's /etc/group: $2"; exit 1; } ;;
+  *) gid=$2 ;;
+esac
+echo "$uid:$gid"
+`
+
+// annotateDebuggerPod applies the debugger's annotations to the target pod.
+// Ephemeral containers have no metadata, so the annotations are scoped to the
+// debugger by debuggerAnnotationKey and added to the pod itself (with a separate
+// patch - the ephemeralcontainers subresource ignores everything but the containers).
+func annotateDebuggerPod(
+	ctx context.Context,
+	client kubernetes.Interface,
+	pod *corev1.Pod,
+	debuggerName string,
+	annotations map[string]string,
+) error {
+	prefixed := map[string]string{}
+	for k, v := range annotations {
+		prefixed[debuggerAnnotationKey(debuggerName, k)] = v
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": prefixed},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.
+		CoreV1().
+		Pods(pod.Namespace).
+		Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// debuggerAnnotations collects the annotations from the --override metadata
+// and the --annotation flags (the latter take precedence) and validates the
+// resulting keys, so that bad ones are rejected before the pod is touched.
+func debuggerAnnotations(opts *options) (map[string]string, error) {
+	annotations := map[string]string{}
+	if opts.override != "" && opts.overrideType != ckubernetes.OverrideTypeJSON {
+		_, overridden, err := ckubernetes.SplitAnnotations(opts.override)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range overridden {
+			annotations[k] = v
+		}
+	}
+
+	flagged, err := parseAnnotations(opts.annotations)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range flagged {
+		annotations[k] = v
+	}
+
+	for k := range annotations {
+		// The prefix is valid for any container name - only the key part is checked.
+		if errs := validation.IsQualifiedName(escapeAnnotationKey(k)); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid annotation %q: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	return annotations, nil
+}
+
+// parseAnnotations parses the --annotation KEY=VALUE flags.
+func parseAnnotations(specs []string) (map[string]string, error) {
+	annotations := map[string]string{}
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --annotation value %q (must be KEY=VALUE)", spec)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+// debuggerAnnotationKey turns an arbitrary annotation key into a valid one
+// scoped to the debugger, so that several debuggers in a pod don't collide.
+func debuggerAnnotationKey(debuggerName string, key string) string {
+	return debuggerName + "." + debuggerAnnotationDomain + "/" + escapeAnnotationKey(key)
+}
+
+// escapeAnnotationKey replaces the key's own prefix separator with '_' -
+// annotation keys may contain only one '/'.
+func escapeAnnotationKey(key string) string {
+	return strings.ReplaceAll(key, "/", "_")
+}
+
+// runStandaloneDebugger creates a separate single-container pod on the
+// target's node. Ephemeral containers cannot change the pod's service
+// account, so this is the only way to debug under a different identity.
+func runStandaloneDebugger(
+	ctx context.Context,
+	opts *options,
+	client kubernetes.Interface,
+	target *corev1.Pod,
+	debuggerName string,
+	entrypoint string,
+) error {
+	annotations, err := parseAnnotations(opts.annotations)
+	if err != nil {
+		return err
+	}
+
+	command, args := debuggerCommand(opts, entrypoint)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      debuggerName,
+			Namespace: target.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "cdebug",
+			},
+			// A standalone pod has metadata of its own.
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:           target.Spec.NodeName,
+			ServiceAccountName: opts.serviceAccount,
+			HostNetwork:        target.Spec.HostNetwork || opts.network == networkHost,
+			HostPID:            target.Spec.HostPID,
+			HostIPC:            target.Spec.HostIPC || opts.ipc == ipcHost,
+			RestartPolicy:      corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:            debuggerName,
+				Image:           opts.image,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Command:         command,
+				Args:            args,
+				Stdin:           opts.stdin,
+				StdinOnce:       opts.stdin,
+				TTY:             opts.tty,
+				SecurityContext: &corev1.SecurityContext{
+					Privileged: &opts.privileged,
+					RunAsUser:  uidPtr(opts.user),
+					RunAsGroup: gidPtr(opts.user),
+				},
+				TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+			}},
+		},
+	}
+
+	limits := corev1.ResourceList{}
+	if opts.memory > 0 {
+		limits[corev1.ResourceMemory] = *resource.NewQuantity(opts.memory, resource.BinarySI)
+	}
+	if opts.cpuQuota > 0 {
+		// CFS quota is per 100ms period, so 100000us == 1 CPU == 1000m.
+		limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(opts.cpuQuota/100, resource.DecimalSI)
+	}
+	if len(limits) > 0 {
+		pod.Spec.Containers[0].Resources.Limits = limits
+	}
+
+	if len(opts.dns)+len(opts.dnsSearch) > 0 {
+		pod.Spec.DNSConfig = &corev1.PodDNSConfig{
+			Nameservers: opts.dns,
+			Searches:    opts.dnsSearch,
+		}
+		if len(opts.dns) > 0 {
+			pod.Spec.DNSPolicy = corev1.DNSNone
+		} else {
+			pod.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		}
+	}
+
+	if opts.ptrace {
+		pod.Spec.Containers[0].SecurityContext.Capabilities = &corev1.Capabilities{
+			Add: []corev1.Capability{"SYS_PTRACE"},
+		}
+	}
+
+	_, err = client.
+		CoreV1().
+		Pods(pod.Namespace).
+		Create(ctx, pod, metav1.CreateOptions{})
+	return err
+}
+
+// deleteStandaloneDebugger removes the --service-account debug pod. The
+// command's context may be cancelled already, hence the background one.
+func deleteStandaloneDebugger(
+	cli cliutil.CLI,
+	client kubernetes.Interface,
+	namespace string,
+	name string,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := client.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{
+		GracePeriodSeconds: ptr(int64(0)),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		cli.PrintErr("Warning: cannot delete debug pod %q: %s\n", name, err)
+	}
+}
+
+// recreatePodWithSharedProcesses deletes the pod and creates its copy with
+// shareProcessNamespace: true. Pods managed by controllers are refused -
+// the controller would recreate them using its own (unchanged) template.
+// The copy is validated with a dry-run before the original pod is deleted.
+func recreatePodWithSharedProcesses(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client kubernetes.Interface,
+	pod *corev1.Pod,
+	targetName string,
+) (*corev1.Pod, error) {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return nil, fmt.Errorf("pod is managed by %s %q - set shareProcessNamespace: true in its pod template instead",
+			owner.Kind, owner.Name)
+	}
+	if pod.Spec.HostPID {
+		return nil, errors.New("pod uses hostPID: true, which cannot be combined with shareProcessNamespace: true")
+	}
+
+	recreated := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pod.Name,
+			Namespace:   pod.Namespace,
+			Labels:      pod.Labels,
+			Annotations: pod.Annotations,
+		},
+		Spec: *pod.Spec.DeepCopy(),
+	}
+	recreated.Spec.EphemeralContainers = nil
+	recreated.Spec.NodeName = ""
+	recreated.Spec.ShareProcessNamespace = ptr(true)
+
+	pods := client.CoreV1().Pods(pod.Namespace)
+	if _, err := pods.Create(ctx, recreated, metav1.CreateOptions{
+		DryRun: []string{metav1.DryRunAll},
+	}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("pod cannot be recreated with shareProcessNamespace: true: %w", err)
+	}
+
+	manifest, err := json.MarshalIndent(recreated, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot save pod manifest: %w", err)
+	}
+
+	cli.PrintErr("Warning: deleting and recreating pod %q with shareProcessNamespace: true\n", pod.Name)
+
+	if err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		return nil, err
+	}
+
+	// The original pod is gone - from now on, the copy must be created
+	// even if the command gets interrupted.
+	createCtx := context.WithoutCancel(ctx)
+
+	cli.PrintAux("Waiting for pod %q to be deleted...\n", pod.Name)
+	for {
+		_, err := pods.Get(createCtx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return nil, podRecreationError(cli, manifest, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	if _, err := pods.Create(createCtx, recreated, metav1.CreateOptions{}); err != nil {
+		return nil, podRecreationError(cli, manifest, err)
+	}
+
+	if targetName == "" {
+		targetName = recreated.Spec.Containers[0].Name
+	}
+
+	cli.PrintAux("Waiting for pod %q to start...\n", pod.Name)
+	return waitForContainer(ctx, cli, client, pod.Namespace, pod.Name, targetName, true, 0)
+}
+
+// podRecreationError prints the manifest of the deleted pod, so that
+// the user could recreate it manually (e.g., with kubectl apply -f).
+func podRecreationError(cli cliutil.CLI, manifest []byte, err error) error {
+	cli.PrintErr("The pod was deleted but its copy could not be created. Its manifest:\n%s\n", manifest)
+	return fmt.Errorf("cannot recreate pod: %w", err)
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
 func withDebugContainer(
 	cli cliutil.CLI,
 	pod *corev1.Pod,
@@ -196,12 +908,14 @@ func withDebugContainer(
 	debuggerName string,
 	entrypoint string,
 ) (*corev1.Pod, error) {
+	command, args := debuggerCommand(opts, entrypoint)
 	ec := &corev1.EphemeralContainer{
 		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
 			Name:            debuggerName,
 			Image:           opts.image,
 			ImagePullPolicy: corev1.PullIfNotPresent,
-			Command:         []string{"sh", "-c", entrypoint},
+			Command:         command,
+			Args:            args,
 			Stdin:           opts.stdin,
 			TTY:             opts.tty,
 			// Env:                   TODO...
@@ -216,6 +930,16 @@ func withDebugContainer(
 		TargetContainerName: targetName,
 	}
 
+	if opts.ptrace {
+		ec.SecurityContext.Capabilities = &corev1.Capabilities{
+			Add: []corev1.Capability{"SYS_PTRACE"},
+		}
+	}
+
+	if target := containerByName(pod, targetName); opts.capInherit && target != nil && target.SecurityContext != nil {
+		ec.SecurityContext.Capabilities = target.SecurityContext.Capabilities.DeepCopy()
+	}
+
 	if runsAsNonRoot(pod, targetName) && isRootUser(opts.user) {
 		ec.SecurityContext.RunAsNonRoot = ptr(true)
 		ec.SecurityContext.RunAsUser = preferredUID(pod, targetName)
@@ -226,13 +950,13 @@ func withDebugContainer(
 	}
 
 	target := containerByName(pod, targetName)
-	if target != nil && !isRootUser(opts.user) {
+	if target != nil && (opts.shareMounts || !isRootUser(opts.user)) {
 		// Copying volume mounts from the target container for convenience.
 		// No need to copy for root user because for it, the rootfs will
-		// look identical to the target container's.
+		// look identical to the target container's (unless --share-mounts).
 
 		for _, vm := range target.VolumeMounts {
-			if vm.SubPath == "" { // Subpath mounts are not allowed for ephemeral containers.
+			if vm.SubPath == "" && !isSystemMount(vm.MountPath) { // Subpath mounts are not allowed for ephemeral containers.
 				ec.VolumeMounts = append(ec.VolumeMounts, vm)
 			}
 		}
@@ -241,9 +965,32 @@ func withDebugContainer(
 	// TODO: Consider mounting all volumes if the target container is not specified.
 	//       Beware of potential path collisions.
 
+	if len(opts.overrideEnv) > 0 {
+		var targetEnv []corev1.EnvVar
+		if target != nil {
+			targetEnv = target.Env
+			ec.EnvFrom = target.EnvFrom
+		}
+		ec.Env = mergeTargetEnvKubernetes(targetEnv, opts.overrideEnv)
+	}
+
 	if opts.override != "" {
+		if err := ckubernetes.ValidateFragment(opts.override); err != nil {
+			return nil, err
+		}
+
+		// The annotations are applied to the pod by annotateDebuggerPod().
+		override := opts.override
+		if opts.overrideType != ckubernetes.OverrideTypeJSON {
+			var err error
+			override, _, err = ckubernetes.SplitAnnotations(override)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		var err error
-		ec, err = ckubernetes.Override(ec, opts.override, opts.overrideType)
+		ec, err = ckubernetes.Override(ec, override, opts.overrideType)
 		if err != nil {
 			return nil, fmt.Errorf("error overriding container: %v", err)
 		}
@@ -255,13 +1002,47 @@ func withDebugContainer(
 	return copied, nil
 }
 
+// mergeTargetEnvKubernetes is the mergeTargetEnv counterpart for the
+// container spec env vars (which may reference secrets, config maps, etc.).
+func mergeTargetEnvKubernetes(targetEnv []corev1.EnvVar, overrides []string) []corev1.EnvVar {
+	values := map[string]string{}
+	for _, spec := range overrides {
+		key, value, _ := parseEnvOverride(spec)
+		values[key] = value
+	}
+
+	var merged []corev1.EnvVar
+	for _, env := range targetEnv {
+		if env.Name == "PATH" {
+			continue
+		}
+		if value, ok := values[env.Name]; ok {
+			env = corev1.EnvVar{Name: env.Name, Value: value}
+			delete(values, env.Name)
+		}
+		merged = append(merged, env)
+	}
+
+	for _, spec := range overrides {
+		key, _, _ := parseEnvOverride(spec)
+		if value, ok := values[key]; ok {
+			merged = append(merged, corev1.EnvVar{Name: key, Value: value})
+			delete(values, key)
+		}
+	}
+
+	return merged
+}
+
 func waitForContainer(
 	ctx context.Context,
+	cli cliutil.CLI,
 	client kubernetes.Interface,
 	ns string,
 	podName string,
 	containerName string,
 	running bool,
+	imagePullTimeout time.Duration,
 ) (*corev1.Pod, error) {
 	ctx, cancel := watchtools.ContextWithOptionalTimeout(ctx, 0*time.Second)
 	defer cancel()
@@ -278,7 +1059,13 @@ func waitForContainer(
 		},
 	}
 
-	ev, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(ev watch.Event) (bool, error) {
+	var (
+		pullFailingSince time.Time
+		pullRetries      int
+		lastReason       string
+	)
+
+	condition := func(ev watch.Event) (bool, error) {
 		switch ev.Type {
 		case watch.Deleted:
 			return false, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "")
@@ -298,8 +1085,35 @@ func waitForContainer(
 			return true, nil
 		}
 
+		if w := s.State.Waiting; w != nil && isImagePullFailure(w.Reason) {
+			if pullFailingSince.IsZero() {
+				pullFailingSince = time.Now()
+			}
+			if w.Reason != lastReason {
+				if w.Reason == "ErrImagePull" {
+					pullRetries++
+				}
+				cli.PrintAux("Debugger image pull failed (%s, attempt %d), the kubelet will retry in ~%s...\n",
+					w.Reason, pullRetries, imagePullBackOff(pullRetries))
+			}
+			lastReason = w.Reason
+
+			if imagePullTimeout > 0 && time.Since(pullFailingSince) > imagePullTimeout {
+				return false, fmt.Errorf("debugger image pull did not succeed in %s: %s - %s",
+					imagePullTimeout, w.Reason, w.Message)
+			}
+		} else {
+			lastReason = ""
+		}
+
 		return false, nil
-	})
+	}
+
+	ev, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, condition)
+	if err != nil && isWatchDisconnect(err) {
+		logrus.Debugf("Pod watch disconnected (%s), falling back to polling", err)
+		return pollForContainer(ctx, client, ns, podName, condition)
+	}
 	if ev != nil {
 		return ev.Object.(*corev1.Pod), err
 	}
@@ -307,6 +1121,70 @@ func waitForContainer(
 	return nil, err
 }
 
+const (
+	podPollInitialInterval = 500 * time.Millisecond
+	podPollMaxInterval     = 5 * time.Second
+	podPollTimeout         = 60 * time.Second
+)
+
+// isWatchDisconnect tells if the watch failed because the connection to
+// the API server was dropped (as opposed to the condition failing).
+func isWatchDisconnect(err error) bool {
+	return errors.Is(err, watchtools.ErrWatchClosed) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsProbableEOF(err)
+}
+
+// pollForContainer is the watch-less fallback of waitForContainer - it
+// feeds the same condition with periodically fetched pod objects.
+func pollForContainer(
+	ctx context.Context,
+	client kubernetes.Interface,
+	ns string,
+	podName string,
+	condition watchtools.ConditionFunc,
+) (*corev1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, podPollTimeout)
+	defer cancel()
+
+	interval := podPollInitialInterval
+	for {
+		pod, err := client.CoreV1().Pods(ns).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) || ctx.Err() != nil {
+				return nil, err
+			}
+			logrus.Debugf("Cannot get pod %s/%s (retrying in %s): %s", ns, podName, interval, err)
+		} else {
+			done, err := condition(watch.Event{Type: watch.Modified, Object: pod})
+			if err != nil || done {
+				return pod, err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up polling pod %s/%s after %s: %w", ns, podName, podPollTimeout, ctx.Err())
+		case <-time.After(interval):
+		}
+		interval = min(2*interval, podPollMaxInterval)
+	}
+}
+
+func isImagePullFailure(reason string) bool {
+	return reason == "ErrImagePull" || reason == "ImagePullBackOff"
+}
+
+// imagePullBackOff approximates the kubelet's image pull back-off
+// (10s doubling on every failed attempt, capped at 5m).
+func imagePullBackOff(retries int) time.Duration {
+	backOff := 10 * time.Second
+	for i := 1; i < retries && backOff < 5*time.Minute; i++ {
+		backOff *= 2
+	}
+	return min(backOff, 5*time.Minute)
+}
+
 func attachPodDebugger(
 	ctx context.Context,
 	cli cliutil.CLI,
@@ -316,9 +1194,10 @@ func attachPodDebugger(
 	ns string,
 	podName string,
 	debuggerName string,
+	targetID string,
 ) error {
 	cli.PrintAux("Waiting for debugger container...\n")
-	pod, err := waitForContainer(ctx, client, ns, podName, debuggerName, true)
+	pod, err := waitForContainer(ctx, cli, client, ns, podName, debuggerName, true, opts.imagePullTimeout)
 	if err != nil {
 		return fmt.Errorf("error waiting for debugger container: %v", err)
 	}
@@ -329,9 +1208,19 @@ func attachPodDebugger(
 	}
 	logrus.Debugf("Debugger container %q status: %+v", debuggerName, status)
 
+	printDebuggerInfo(cli, opts, debuggerInfo{Name: debuggerName, ID: status.ContainerID}, map[string]any{
+		"pod":             podName,
+		"namespace":       ns,
+		"containerStatus": status,
+	})
+
 	if status.State.Terminated != nil {
 		dumpDebuggerLogs(ctx, client, ns, podName, debuggerName, cli.OutputStream())
 
+		if opts.onExit != nil {
+			opts.onExit(targetID, int(status.State.Terminated.ExitCode))
+		}
+
 		if status.State.Terminated.Reason == "Completed" {
 			return nil
 		}
@@ -343,17 +1232,17 @@ func attachPodDebugger(
 			status.State.Terminated.ExitCode)
 	}
 
-	debuggerContainer := ephemeralContainerByName(pod, debuggerName)
-	if debuggerContainer == nil {
+	debuggerTTY, found := containerTTY(pod, debuggerName)
+	if !found {
 		return fmt.Errorf("cannot find debugger container %q in pod %q", debuggerName, podName)
 	}
 
-	if opts.tty && !debuggerContainer.TTY {
+	if opts.tty && !debuggerTTY {
 		opts.tty = false
 		if !opts.quiet {
 			cli.PrintErr("Warning: Unable to use a TTY - container %s did not allocate one\n", debuggerName)
 		}
-	} else if !opts.tty && debuggerContainer.TTY {
+	} else if !opts.tty && debuggerTTY {
 		// the container was launched with a TTY, so we have to force a TTY here
 		// to avoid getting an error "Unrecognized input header"
 		opts.tty = true
@@ -379,17 +1268,28 @@ func attachPodDebugger(
 	defer cancelStreamingCtx()
 
 	go func() {
-		_, _ = waitForContainer(ctx, client, ns, podName, debuggerName, false)
+		_, _ = waitForContainer(ctx, cli, client, ns, podName, debuggerName, false, 0)
 		// Debugger container is not running anymore - streaming no longer needed.
 		cancelStreamingCtx()
 	}()
 
-	if err := stream(streamingCtx, cli, req.URL(), config, opts.tty); err != nil {
+	if err := stream(streamingCtx, cli, opts, req.URL(), config); err != nil {
 		return fmt.Errorf("error streaming to/from debugger container: %v", err)
 	}
 
 	cli.PrintAux("Debugger container %q terminated...\n", debuggerName)
 
+	if opts.onExit != nil {
+		// The attach API doesn't report the exit code.
+		pod, err := waitForContainer(ctx, cli, client, ns, podName, debuggerName, false, 0)
+		if err != nil {
+			return fmt.Errorf("error getting debugger container status: %v", err)
+		}
+		if s := containerStatusByName(pod, debuggerName); s != nil && s.State.Terminated != nil {
+			opts.onExit(targetID, int(s.State.Terminated.ExitCode))
+		}
+	}
+
 	if err := dumpDebuggerLogs(ctx, client, ns, podName, debuggerName, cli.OutputStream()); err != nil {
 		return fmt.Errorf("error dumping debugger logs: %v", err)
 	}
@@ -400,15 +1300,20 @@ func attachPodDebugger(
 func stream(
 	ctx context.Context,
 	cli cliutil.CLI,
+	opts *options,
 	url *url.URL,
 	config *restclient.Config,
-	raw bool,
 ) error {
 	var resizeQueue *tty.ResizeQueue
-	if raw {
-		if cli.OutputStream().IsTerminal() {
+	if opts.tty {
+		if opts.ttyCols > 0 {
+			resizeQueue = tty.NewFixedSizeQueue(ctx, opts.ttyRows, opts.ttyCols)
+			resizeQueue.Start()
+			defer resizeQueue.Stop()
+		} else if cli.OutputStream().IsTerminal() {
 			resizeQueue = tty.NewResizeQueue(ctx, cli.OutputStream())
 			resizeQueue.Start()
+			defer resizeQueue.Stop()
 		}
 
 		cli.InputStream().SetRawTerminal()
@@ -419,27 +1324,119 @@ func stream(
 		}()
 	}
 
+	exec, err := newRemoteExecutor(config, url)
+	if err != nil {
+		return err
+	}
+
+	stdin, stdout, stderr := teeStreams(opts, echoStdin(cli, opts, cli.InputStream()), cli.OutputStream(), cli.ErrorStream())
+	cli.PrintOut("%s", stdinPrompt(opts))
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:             stdin,
+		Stdout:            stdout,
+		Stderr:            stderr,
+		Tty:               opts.tty,
+		TerminalSizeQueue: resizeQueue,
+	})
+}
+
+func newRemoteExecutor(config *restclient.Config, url *url.URL) (remotecommand.Executor, error) {
 	spdyExec, err := remotecommand.NewSPDYExecutor(config, "POST", url)
 	if err != nil {
-		return fmt.Errorf("cannot create SPDY executor: %w", err)
+		return nil, fmt.Errorf("cannot create SPDY executor: %w", err)
 	}
 
 	websocketExec, err := remotecommand.NewWebSocketExecutor(config, "GET", url.String())
 	if err != nil {
-		return fmt.Errorf("cannot create WebSocket executor: %w", err)
+		return nil, fmt.Errorf("cannot create WebSocket executor: %w", err)
 	}
+
 	exec, err := remotecommand.NewFallbackExecutor(websocketExec, spdyExec, httpstream.IsUpgradeFailure)
 	if err != nil {
-		return fmt.Errorf("cannot create fallback executor: %w", err)
+		return nil, fmt.Errorf("cannot create fallback executor: %w", err)
 	}
 
-	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdin:             cli.InputStream(),
-		Stdout:            cli.OutputStream(),
-		Stderr:            cli.ErrorStream(),
-		Tty:               raw,
-		TerminalSizeQueue: resizeQueue,
-	})
+	return exec, nil
+}
+
+// copyFromPod mimics `kubectl cp` - it execs `tar` in the container
+// and unpacks the streamed archive on the host.
+func copyFromPod(
+	ctx context.Context,
+	config *restclient.Config,
+	client kubernetes.Interface,
+	ns string,
+	podName string,
+	containerName string,
+	spec copySpec,
+) error {
+	dir, base := path.Split(path.Clean(spec.containerPath))
+
+	req := client.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(ns).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
+			Command:   []string{"tar", "cf", "-", "-C", dir, base},
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := newRemoteExecutor(config, req.URL())
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdout: writer,
+			Stderr: &stderr,
+		}))
+	}()
+	defer reader.Close()
+
+	// Peeking at the first header to find out if the source is a directory.
+	content := bufio.NewReader(reader)
+	block, err := content.Peek(512)
+	if err != nil {
+		if stderr.Len() > 0 {
+			return errors.New(strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+
+	header, err := tar.NewReader(bytes.NewReader(block)).Next()
+	if err != nil {
+		return fmt.Errorf("cannot read archive header: %w", err)
+	}
+
+	return archive.CopyTo(content, archive.CopyInfo{
+		Path:   spec.containerPath,
+		Exists: true,
+		IsDir:  header.Typeflag == tar.TypeDir,
+	}, spec.hostPath)
+}
+
+func saveDebuggerLogsKubernetes(
+	ctx context.Context,
+	client kubernetes.Interface,
+	ns string,
+	podName string,
+	containerName string,
+	logFile string,
+) error {
+	f, err := os.Create(logFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return dumpDebuggerLogs(ctx, client, ns, podName, containerName, f)
 }
 
 func dumpDebuggerLogs(
@@ -476,6 +1473,46 @@ func dumpDebuggerLogs(
 	}
 }
 
+// Ephemeral containers cannot add volumes to the pod, so the files
+// are embedded right into the debugger's entrypoint as base64-encoded
+// tar archives (requires base64 and tar in the debugging toolkit image).
+func copyToPodScript(cli cliutil.CLI, specs []copySpec) (string, error) {
+	var script strings.Builder
+	for _, spec := range specs {
+		dir, base := path.Split(path.Clean(spec.containerPath))
+
+		content, err := archive.TarResourceRebase(spec.hostPath, base)
+		if err != nil {
+			return "", err
+		}
+
+		var encoded bytes.Buffer
+		encoder := base64.NewEncoder(base64.StdEncoding, &encoded)
+		_, err = io.Copy(encoder, content)
+		content.Close()
+		if err != nil {
+			return "", err
+		}
+		encoder.Close()
+
+		fmt.Fprintf(&script, "mkdir -p %s\n", shellquote(dir))
+		fmt.Fprintf(&script, "base64 -d <<'EOF' | tar -x -C %s\n", shellquote(dir))
+		for b := encoded.Bytes(); len(b) > 0; {
+			n := min(76, len(b))
+			script.Write(b[:n])
+			script.WriteByte('\n')
+			b = b[n:]
+		}
+		script.WriteString("EOF\n")
+	}
+
+	if script.Len() > maxCopyToPodSize {
+		cli.PrintErr("Warning: the files to copy take %d bytes, which likely exceeds the Kubernetes object size limit (~1MB)\n", script.Len())
+	}
+
+	return script.String(), nil
+}
+
 func isReadOnlyRootFS(pod *corev1.Pod, containerName string) bool {
 	c := containerByName(pod, containerName)
 	return c != nil &&
@@ -536,6 +1573,39 @@ func containerStatusByName(pod *corev1.Pod, containerName string) *corev1.Contai
 	return nil
 }
 
+// podNamespaces looks up the pod by name in all namespaces - a helper for
+// suggesting the right --namespace. Returns nothing if the user isn't allowed
+// to list pods cluster-wide.
+func podNamespaces(ctx context.Context, client kubernetes.Interface, podName string) []string {
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", podName).String(),
+	})
+	if err != nil {
+		logrus.Debugf("Cannot look up pod %q in all namespaces: %s", podName, err)
+		return nil
+	}
+
+	var namespaces []string
+	for _, pod := range pods.Items {
+		namespaces = append(namespaces, pod.Namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// kubernetesTargetID returns the runtime ID of the target container (as
+// reported in the pod status, e.g., "containerd://<id>") or the pod's UID
+// if the container isn't known (yet).
+func kubernetesTargetID(pod *corev1.Pod, targetName string) string {
+	if targetName == "" && len(pod.Spec.Containers) > 0 {
+		targetName = pod.Spec.Containers[0].Name
+	}
+	if s := containerStatusByName(pod, targetName); s != nil && s.ContainerID != "" {
+		return s.ContainerID
+	}
+	return string(pod.UID)
+}
+
 func containerByName(pod *corev1.Pod, containerName string) *corev1.Container {
 	for i := range pod.Spec.Containers {
 		if pod.Spec.Containers[i].Name == containerName {
@@ -554,6 +1624,18 @@ func ephemeralContainerByName(pod *corev1.Pod, containerName string) *corev1.Eph
 	return nil
 }
 
+// containerTTY reports whether the (ephemeral or regular) container
+// has a TTY allocated and whether such a container exists at all.
+func containerTTY(pod *corev1.Pod, containerName string) (bool, bool) {
+	if c := ephemeralContainerByName(pod, containerName); c != nil {
+		return c.TTY, true
+	}
+	if c := containerByName(pod, containerName); c != nil {
+		return c.TTY, true
+	}
+	return false, false
+}
+
 // Allowed values:
 //
 //	<empty> - use the user specified in the toolkit image
@@ -598,6 +1680,23 @@ func validateUserFlag(user string) error {
 	return nil
 }
 
+var userNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// isUserName reports whether the --user flag refers to a user by name
+// (NAME or NAME:GROUP, where the group can be a name or a number).
+func isUserName(user string) bool {
+	uid, gid, hasGID := strings.Cut(strings.TrimSpace(user), ":")
+	if !userNameRegexp.MatchString(uid) {
+		return false
+	}
+	if hasGID {
+		if _, err := strconv.ParseUint(gid, 10, 32); err != nil && !userNameRegexp.MatchString(gid) {
+			return false
+		}
+	}
+	return true
+}
+
 func uidPtr(user string) *int64 {
 	if user == "" {
 		return nil
@@ -0,0 +1,173 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/containerd"
+	"github.com/iximiuz/cdebug/pkg/docker"
+	"github.com/iximiuz/cdebug/pkg/imagecache"
+)
+
+// runCacheImage pulls the debugger image and stores it in the --image-cache
+// dir, so that later sessions could work without access to the registry.
+func runCacheImage(ctx context.Context, cli cliutil.CLI, opts *options) error {
+	if opts.imageCache == "" {
+		return errors.New("the --cache-image flag requires the --image-cache flag")
+	}
+
+	var client imagecache.Client
+	switch opts.schema {
+	case schemaContainerd, schemaNerdctl:
+		c, err := containerd.NewClient(containerd.Options{
+			Out:         cli.AuxStream(),
+			Address:     opts.runtime,
+			Namespace:   opts.namespace,
+			Snapshotter: opts.snapshotter,
+		})
+		if err != nil {
+			return err
+		}
+		ctx = namespaces.WithNamespace(ctx, c.Namespace())
+
+		platform := opts.platform
+		if len(platform) == 0 {
+			platform = platforms.Format(platforms.DefaultSpec())
+		}
+
+		cli.PrintAux("Pulling debugger image...\n")
+		if _, err := c.ImagePullEx(ctx, opts.image, platform); err != nil {
+			return errCannotPull(opts.image, err)
+		}
+		client = c
+
+	case schemaDocker:
+		c, err := docker.NewClient(docker.Options{
+			Out:  cli.AuxStream(),
+			Host: opts.runtime,
+		})
+		if err != nil {
+			return err
+		}
+
+		cli.PrintAux("Pulling debugger image...\n")
+		if err := c.ImagePullEx(ctx, opts.image, types.ImagePullOptions{
+			Platform: opts.platform,
+		}); err != nil {
+			return errCannotPull(opts.image, err)
+		}
+		client = c
+
+	default:
+		return fmt.Errorf("--cache-image flag is not supported for %s runtime", opts.schema)
+	}
+
+	if err := imagecache.Save(ctx, client, opts.image, opts.imageCache); err != nil {
+		return err
+	}
+
+	cli.PrintAux("Debugger image saved to %s\n", imagecache.Path(opts.imageCache, opts.image))
+	return nil
+}
+
+// runPreloadImages pulls the given images in parallel to warm up the
+// runtime's local image store - no target, no debugging session.
+func runPreloadImages(ctx context.Context, cli cliutil.CLI, opts *options, images []string) error {
+	var pull func(ctx context.Context, image string) error
+	switch opts.schema {
+	case schemaContainerd, schemaNerdctl:
+		// No Out - the per-layer progress of parallel pulls would be a mess.
+		c, err := containerd.NewClient(containerd.Options{
+			Address:     opts.runtime,
+			Namespace:   opts.namespace,
+			Snapshotter: opts.snapshotter,
+		})
+		if err != nil {
+			return err
+		}
+		ctx = namespaces.WithNamespace(ctx, c.Namespace())
+
+		platform := opts.platform
+		if len(platform) == 0 {
+			platform = platforms.Format(platforms.DefaultSpec())
+		}
+
+		pull = func(ctx context.Context, image string) error {
+			_, err := c.ImagePullEx(ctx, image, platform)
+			return err
+		}
+
+	case schemaDocker:
+		c, err := docker.NewClient(docker.Options{
+			Host: opts.runtime,
+		})
+		if err != nil {
+			return err
+		}
+
+		pull = func(ctx context.Context, image string) error {
+			return c.ImagePullEx(ctx, image, types.ImagePullOptions{
+				Platform: opts.platform,
+			})
+		}
+
+	default:
+		return fmt.Errorf("--preload-image flag is not supported for %s runtime", opts.schema)
+	}
+
+	var g errgroup.Group
+	for _, image := range images {
+		image := image
+		g.Go(func() error {
+			cli.PrintAux("Pulling %s...\n", image)
+			if err := pull(ctx, image); err != nil {
+				cli.PrintErr("Failed to pull %s: %s\n", image, err)
+				return errCannotPull(image, err)
+			}
+			cli.PrintOut("Pulled %s\n", image)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+func loadCachedImage(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client imagecache.Client,
+	opts *options,
+) error {
+	if opts.imageCache == "" {
+		return errors.New("image cache is not configured")
+	}
+
+	cli.PrintAux("Cannot pull debugger image, loading it from the cache...\n")
+	if err := imagecache.Load(ctx, client, opts.image, opts.imageCache); err != nil {
+		logrus.Debugf("Cannot load cached image: %s", err)
+		return err
+	}
+	return nil
+}
+
+func saveCachedImage(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client imagecache.Client,
+	opts *options,
+) {
+	if opts.imageCache == "" {
+		return
+	}
+
+	if err := imagecache.Save(ctx, client, opts.image, opts.imageCache); err != nil {
+		cli.PrintErr("Warning: cannot cache debugger image: %s\n", err)
+	}
+}
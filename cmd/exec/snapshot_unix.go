@@ -0,0 +1,11 @@
+//go:build !windows
+
+package exec
+
+import (
+	offcontainerd "github.com/containerd/containerd"
+)
+
+func withRemappedSnapshot(id string, image offcontainerd.Image, uid, gid uint32) offcontainerd.NewContainerOpts {
+	return offcontainerd.WithRemappedSnapshot(id, image, uid, gid)
+}
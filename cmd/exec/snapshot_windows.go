@@ -0,0 +1,16 @@
+package exec
+
+import (
+	"context"
+	"errors"
+
+	offcontainerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+)
+
+// Remapped snapshots rely on chown-ing the image files, which is Unix-only.
+func withRemappedSnapshot(string, offcontainerd.Image, uint32, uint32) offcontainerd.NewContainerOpts {
+	return func(context.Context, *offcontainerd.Client, *containers.Container) error {
+		return errors.New("--userns-remap flag is not supported on Windows")
+	}
+}
@@ -0,0 +1,548 @@
+package inspect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	offcontainerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/containerd"
+	"github.com/iximiuz/cdebug/pkg/docker"
+	ckubernetes "github.com/iximiuz/cdebug/pkg/kubernetes"
+	"github.com/iximiuz/cdebug/pkg/schema"
+)
+
+const (
+	redactedValue = "<redacted>"
+
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+)
+
+var errTargetNotFound = errors.New("target container not found")
+
+type options struct {
+	target    string
+	schema    string
+	namespace string
+	runtime   string
+	redactEnv bool
+
+	kubeconfig        string
+	kubeconfigContext string
+}
+
+// summary is a curated (and runtime-agnostic) subset of the target's
+// configuration - just enough to plan a debugging session.
+type summary struct {
+	name     string
+	image    string
+	platform string
+	status   string
+	networks []string
+	volumes  []string
+	ports    []string
+	env      []string
+	cmdline  string
+}
+
+func NewCommand(cli cliutil.CLI) *cobra.Command {
+	var opts options
+
+	cmd := &cobra.Command{
+		Use:   "inspect [OPTIONS] [schema://][POD][CONTAINER]",
+		Short: "Show a short summary of the target container (image, networks, volumes, ports, env, etc.)",
+		Long: `Show a curated summary of the target container's configuration - a condensed
+alternative to "docker inspect" useful before starting a debugging session.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.schema, opts.target = schema.Parse(args[0])
+
+			if cmd.Flags().Changed("context") && cmd.Flags().Changed("kubeconfig-context") {
+				return cliutil.WrapStatusError(errors.New("only one of --context and --kubeconfig-context can be provided"))
+			}
+
+			ctx := context.Background()
+
+			var (
+				s   *summary
+				err error
+			)
+			switch opts.schema {
+			case schema.Containerd, schema.Nerdctl:
+				s, err = inspectContainerd(ctx, cli, &opts)
+			case schema.Docker:
+				s, err = inspectDocker(ctx, cli, &opts)
+			case schema.KubeLong, schema.KubeShort:
+				s, err = inspectKubernetes(ctx, &opts)
+			default:
+				err = fmt.Errorf("unknown schema %q", opts.schema)
+			}
+			if err != nil {
+				return cliutil.WrapStatusError(err)
+			}
+
+			if opts.redactEnv {
+				s.env = redactEnv(s.env)
+			}
+
+			out := cli.OutputStream()
+			return cliutil.WrapStatusError(printSummary(out, s, out.IsTerminal()))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SetInterspersed(false) // Instead of relying on --
+
+	flags.BoolVar(
+		&opts.redactEnv,
+		"redact-env",
+		false,
+		`Hide the values of the target's environment variables (show the names only)`,
+	)
+	flags.StringVarP(
+		&opts.namespace,
+		"namespace",
+		"n",
+		os.Getenv("CDEBUG_NAMESPACE"),
+		`Namespace (the final meaning of this parameter is runtime specific; can also be set via $CDEBUG_NAMESPACE)`,
+	)
+	flags.StringVar(
+		&opts.runtime,
+		"runtime",
+		os.Getenv("CDEBUG_RUNTIME"),
+		`Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "https://<kube-api-addr>:8433/..."; can also be set via $CDEBUG_RUNTIME)`,
+	)
+	flags.StringVar(
+		&opts.kubeconfig,
+		"kubeconfig",
+		"",
+		`Path to the kubeconfig file (default is $HOME/.kube/config)`,
+	)
+	flags.StringVar(
+		&opts.kubeconfigContext,
+		"kubeconfig-context",
+		ckubernetes.DefaultContext(),
+		`Name of the kubeconfig context to use (can also be set via $KUBECONTEXT or $KUBECTL_CONTEXT)`,
+	)
+	flags.StringVar(
+		&opts.kubeconfigContext,
+		"context",
+		ckubernetes.DefaultContext(),
+		`Name of the kubeconfig context to use (same as --kubeconfig-context)`,
+	)
+
+	return cmd
+}
+
+func inspectDocker(ctx context.Context, cli cliutil.CLI, opts *options) (*summary, error) {
+	client, err := docker.NewClient(docker.Options{
+		Out:  cli.AuxStream(),
+		Host: opts.runtime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := client.ContainerInspect(ctx, opts.target)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &summary{
+		name:     strings.TrimPrefix(target.Name, "/"),
+		image:    target.Config.Image,
+		platform: target.Platform,
+		status:   target.State.Status,
+		env:      target.Config.Env,
+	}
+
+	if image, _, err := client.ImageInspectWithRaw(ctx, target.Image); err == nil {
+		s.platform = platforms.Format(platforms.Normalize(ocispecPlatform(image.Os, image.Architecture, image.Variant)))
+	}
+
+	for name, endpoint := range target.NetworkSettings.Networks {
+		s.networks = append(s.networks, formatNetwork(name, endpoint.IPAddress))
+	}
+
+	for _, m := range target.Mounts {
+		s.volumes = append(s.volumes, formatVolume(m.Source, m.Destination, m.RW))
+	}
+
+	for port := range target.Config.ExposedPorts {
+		binding := ""
+		for _, b := range target.NetworkSettings.Ports[port] {
+			binding = b.HostIP + ":" + b.HostPort
+			break
+		}
+		s.ports = append(s.ports, formatPort(string(port), binding))
+	}
+
+	if target.State.Running {
+		// The first listed process is the container's PID 1.
+		top, err := client.ContainerTop(ctx, target.ID, nil)
+		if err == nil && len(top.Processes) > 0 {
+			for i, title := range top.Titles {
+				if title == "CMD" || title == "COMMAND" {
+					s.cmdline = top.Processes[0][i]
+				}
+			}
+		}
+	}
+	if s.cmdline == "" {
+		s.cmdline = strings.Join(append(target.Config.Entrypoint, target.Config.Cmd...), " ")
+	}
+
+	return s, nil
+}
+
+func inspectContainerd(ctx context.Context, cli cliutil.CLI, opts *options) (*summary, error) {
+	client, err := containerd.NewClient(containerd.Options{
+		Out:       cli.AuxStream(),
+		Address:   opts.runtime,
+		Namespace: opts.namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = namespaces.WithNamespace(ctx, client.Namespace())
+
+	filters := []string{
+		fmt.Sprintf("id~=^%s.*$", regexp.QuoteMeta(opts.target)),
+	}
+	if opts.schema == schema.Nerdctl {
+		filters = append(filters, fmt.Sprintf(`labels."nerdctl/name"==%s`, opts.target))
+	}
+
+	found, err := client.Containers(ctx, filters...)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errTargetNotFound
+	}
+	if len(found) > 1 {
+		return nil, errors.New("ambiguous target partial ID")
+	}
+	target := found[0]
+
+	info, err := target.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := target.Spec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &summary{
+		name:   info.ID,
+		image:  info.Image,
+		status: string(offcontainerd.Unknown),
+	}
+	if name := info.Labels["nerdctl/name"]; name != "" {
+		s.name = name
+	}
+
+	if image, err := target.Image(ctx); err == nil {
+		if ispec, err := image.Spec(ctx); err == nil {
+			s.platform = platforms.Format(platforms.Normalize(ocispecPlatform(ispec.OS, ispec.Architecture, ispec.Variant)))
+		}
+	}
+
+	task, err := target.Task(ctx, nil)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return nil, err
+	}
+	if task != nil {
+		status, err := task.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.status = string(status.Status)
+
+		if status.Status == offcontainerd.Running {
+			if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", task.Pid())); err == nil {
+				s.cmdline = formatCmdline(cmdline)
+			}
+		}
+	} else {
+		s.status = "created"
+	}
+
+	if nets := info.Labels["nerdctl/networks"]; nets != "" {
+		// nerdctl stores the networks as a JSON array of names.
+		var names []string
+		if err := json.Unmarshal([]byte(nets), &names); err == nil {
+			for _, name := range names {
+				s.networks = append(s.networks, formatNetwork(name, info.Labels["nerdctl/ip"]))
+			}
+		}
+	}
+
+	for _, m := range spec.Mounts {
+		if m.Type != "bind" && m.Type != "volume" {
+			continue // Skip /proc, /sys, /dev and the like.
+		}
+		rw := true
+		for _, o := range m.Options {
+			if o == "ro" {
+				rw = false
+			}
+		}
+		s.volumes = append(s.volumes, formatVolume(m.Source, m.Destination, rw))
+	}
+
+	if spec.Process != nil {
+		s.env = spec.Process.Env
+		if s.cmdline == "" {
+			s.cmdline = strings.Join(spec.Process.Args, " ")
+		}
+	}
+
+	return s, nil
+}
+
+func inspectKubernetes(ctx context.Context, opts *options) (*summary, error) {
+	config, namespace, err := ckubernetes.GetRESTConfig(
+		opts.runtime,
+		opts.kubeconfig,
+		opts.kubeconfigContext,
+		"",
+		"",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Kubernetes REST config: %v", err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client: %v", err)
+	}
+
+	if opts.namespace != "" {
+		namespace = opts.namespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	target := strings.TrimPrefix(strings.TrimPrefix(opts.target, "pod/"), "pods/")
+	podName, containerName, _ := strings.Cut(target, "/")
+
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting target pod: %v", err)
+	}
+
+	if containerName == "" {
+		if len(pod.Spec.Containers) != 1 {
+			return nil, errors.New("target pod has more than one container, specify the target container as pod/<name>/<container>")
+		}
+		containerName = pod.Spec.Containers[0].Name
+	}
+
+	var cont *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == containerName {
+			cont = &pod.Spec.Containers[i]
+		}
+	}
+	if cont == nil {
+		return nil, errTargetNotFound
+	}
+
+	s := &summary{
+		name:   pod.Name + "/" + cont.Name,
+		image:  cont.Image,
+		status: string(pod.Status.Phase),
+	}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != cont.Name {
+			continue
+		}
+		switch {
+		case cs.State.Running != nil:
+			s.status = "running"
+		case cs.State.Waiting != nil:
+			s.status = "waiting (" + cs.State.Waiting.Reason + ")"
+		case cs.State.Terminated != nil:
+			s.status = "terminated (" + cs.State.Terminated.Reason + ")"
+		}
+	}
+
+	if pod.Spec.NodeName != "" {
+		// Best effort - listing nodes may be forbidden by RBAC.
+		if node, err := client.CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{}); err == nil {
+			s.platform = node.Status.NodeInfo.OperatingSystem + "/" + node.Status.NodeInfo.Architecture
+		}
+	}
+
+	network := "pod"
+	if pod.Spec.HostNetwork {
+		network = "host"
+	}
+	for _, ip := range pod.Status.PodIPs {
+		s.networks = append(s.networks, formatNetwork(network, ip.IP))
+	}
+
+	for _, m := range cont.VolumeMounts {
+		s.volumes = append(s.volumes, formatVolume(m.Name, m.MountPath, !m.ReadOnly))
+	}
+
+	for _, p := range cont.Ports {
+		binding := ""
+		if p.HostPort != 0 {
+			binding = fmt.Sprintf("%s:%d", p.HostIP, p.HostPort)
+		}
+		s.ports = append(s.ports, formatPort(fmt.Sprintf("%d/%s", p.ContainerPort, strings.ToLower(string(p.Protocol))), binding))
+	}
+
+	for _, e := range cont.Env {
+		if e.ValueFrom != nil {
+			s.env = append(s.env, e.Name+"=<from "+envSource(e.ValueFrom)+">")
+		} else {
+			s.env = append(s.env, e.Name+"="+e.Value)
+		}
+	}
+
+	// The API doesn't expose /proc/1/cmdline - the spec is the closest thing.
+	s.cmdline = strings.Join(append(cont.Command, cont.Args...), " ")
+	if s.cmdline == "" {
+		s.cmdline = "<image default>"
+	}
+
+	return s, nil
+}
+
+func ocispecPlatform(os, arch, variant string) ocispec.Platform {
+	return ocispec.Platform{OS: os, Architecture: arch, Variant: variant}
+}
+
+func envSource(src *corev1.EnvVarSource) string {
+	switch {
+	case src.SecretKeyRef != nil:
+		return "secret " + src.SecretKeyRef.Name
+	case src.ConfigMapKeyRef != nil:
+		return "configmap " + src.ConfigMapKeyRef.Name
+	case src.FieldRef != nil:
+		return "field " + src.FieldRef.FieldPath
+	case src.ResourceFieldRef != nil:
+		return "resource " + src.ResourceFieldRef.Resource
+	}
+	return "unknown"
+}
+
+func printSummary(out io.Writer, s *summary, color bool) error {
+	paint := func(c, text string) string {
+		if !color {
+			return text
+		}
+		return c + text + colorReset
+	}
+
+	statusColor := colorYellow
+	switch s.status {
+	case "running":
+		statusColor = colorGreen
+	case "exited", "dead", "stopped":
+		statusColor = colorRed
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	row := func(key string, values ...string) {
+		if len(values) == 0 {
+			values = []string{"-"}
+		}
+		for i, v := range values {
+			if i == 0 {
+				fmt.Fprintf(w, "%s\t%s\n", paint(colorBold, key+":"), v)
+			} else {
+				// Same invisible escape sequences as above to keep the columns aligned.
+				fmt.Fprintf(w, "%s\t%s\n", paint(colorBold, ""), v)
+			}
+		}
+	}
+
+	sort.Strings(s.networks)
+	sort.Strings(s.volumes)
+	sort.Strings(s.ports)
+
+	row("Name", s.name)
+	row("Image", s.image)
+	row("Platform", orDash(s.platform))
+	row("Status", paint(statusColor, s.status))
+	row("Networks", s.networks...)
+	row("Volumes", s.volumes...)
+	row("Ports", s.ports...)
+	row("Env", s.env...)
+	row("Command", orDash(s.cmdline))
+
+	return w.Flush()
+}
+
+func redactEnv(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		redacted = append(redacted, name+"="+redactedValue)
+	}
+	return redacted
+}
+
+func formatNetwork(name, ip string) string {
+	if ip == "" {
+		return name
+	}
+	return name + " " + ip
+}
+
+func formatVolume(source, destination string, rw bool) string {
+	mode := "rw"
+	if !rw {
+		mode = "ro"
+	}
+	return fmt.Sprintf("%s -> %s (%s)", source, destination, mode)
+}
+
+func formatPort(port, binding string) string {
+	if binding == "" || binding == ":" {
+		return port
+	}
+	return binding + " -> " + port
+}
+
+// formatCmdline turns the NUL-separated /proc/<pid>/cmdline into a command string.
+func formatCmdline(raw []byte) string {
+	return strings.TrimSpace(strings.ReplaceAll(string(raw), "\x00", " "))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
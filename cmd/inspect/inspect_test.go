@@ -0,0 +1,39 @@
+package inspect
+
+import (
+	"bytes"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestPrintSummary(t *testing.T) {
+	s := &summary{
+		name:     "web",
+		image:    "nginx:alpine",
+		platform: "linux/amd64",
+		status:   "running",
+		networks: []string{"bridge 172.17.0.2"},
+		ports:    []string{"0.0.0.0:8080 -> 80/tcp"},
+		env:      redactEnv([]string{"PATH=/usr/bin", "TOKEN=s3cr3t"}),
+		cmdline:  "nginx -g daemon off;",
+	}
+
+	var out bytes.Buffer
+	assert.NilError(t, printSummary(&out, s, false))
+	assert.Equal(t, out.String(), `Name:      web
+Image:     nginx:alpine
+Platform:  linux/amd64
+Status:    running
+Networks:  bridge 172.17.0.2
+Volumes:   -
+Ports:     0.0.0.0:8080 -> 80/tcp
+Env:       PATH=<redacted>
+           TOKEN=<redacted>
+Command:   nginx -g daemon off;
+`)
+}
+
+func TestFormatCmdline(t *testing.T) {
+	assert.Equal(t, formatCmdline([]byte("sleep\x00infinity\x00")), "sleep infinity")
+}
@@ -0,0 +1,22 @@
+package portforward
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/iximiuz/cdebug/pkg/completion"
+)
+
+func completeTarget(opts *options) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), completion.Timeout)
+		defer cancel()
+
+		return completion.DockerContainerNames(ctx, opts.runtime)
+	}
+}
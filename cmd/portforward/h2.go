@@ -0,0 +1,299 @@
+package portforward
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/h2tunnel"
+	"github.com/iximiuz/cdebug/pkg/ldd"
+	"github.com/iximiuz/cdebug/pkg/uuid"
+)
+
+const (
+	// The h2 forwarder runs the cdebug binary itself (it must be statically
+	// linked - the forwarder image has no matching libc) in the hidden
+	// server mode.
+	h2ServerCommand = "__h2-server"
+	h2ServerBinary  = "/.cdebug-h2/cdebug"
+	h2ServerPort    = "4443"
+)
+
+func newH2ServerCommand() *cobra.Command {
+	var listen, connect string
+
+	cmd := &cobra.Command{
+		Use:    h2ServerCommand + " --listen ADDR --connect ADDR",
+		Short:  "Serve HTTP/2 CONNECT tunnels (used by the --h2 forwarders)",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logrus.Debugf("Tunneling HTTP/2 CONNECT streams from %s to %s", listen, connect)
+			return cliutil.WrapStatusError(http.ListenAndServe(listen, h2tunnel.NewHandler(connect)))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&listen, "listen", ":"+h2ServerPort, `Address to serve the tunnels on`)
+	flags.StringVar(&connect, "connect", "", `Address to tunnel the connections to`)
+
+	return cmd
+}
+
+func validateH2Forwarding(fwd forwarding) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("--h2 flag is supported only when cdebug runs on Linux (the forwarder runs a copy of the cdebug binary)")
+	}
+	if len(fwd.remoteSocket) > 0 {
+		return errors.New("--h2 flag is not supported for unix socket forwarding")
+	}
+	return nil
+}
+
+func runLocalH2Forwarder(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client dockerclient.CommonAPIClient,
+	opts *options,
+	fwd directForwarding,
+	ready func(),
+) error {
+	tunnelHost := h2TunnelHost(client)
+	if !isLoopbackHost(tunnelHost) {
+		if !opts.h2Public {
+			return errors.New("--h2 flag with a remote Docker daemon requires publishing the (unauthenticated) tunnel port on all the daemon host's interfaces - use --h2-public to allow it")
+		}
+		cli.PrintErr("Warning: the Docker daemon is remote - the HTTP/2 tunnel port is published on all its interfaces\n")
+	}
+
+	forwarderID, err := startLocalH2Forwarder(ctx, client, fwd, tunnelHost)
+	defer cleanupContainerIfExist(client, forwarderID)
+	if err != nil {
+		return fmt.Errorf("starting forwarder failed: %w", err)
+	}
+
+	if opts.verbose {
+		go streamForwarderLogs(ctx, cli, client, forwarderID)
+	}
+
+	forwarder, err := client.ContainerInspect(ctx, forwarderID)
+	if err != nil {
+		return fmt.Errorf("cannot inspect forwarder container: %w", err)
+	}
+	bindings := lookupPortBindings(forwarder, h2ServerPort)
+	if len(bindings) == 0 {
+		return fmt.Errorf("forwarder %s has no published tunnel port", forwarderID)
+	}
+
+	tunnel := h2tunnel.NewClient(net.JoinHostPort(tunnelHost, bindings[0].HostPort))
+	defer tunnel.Close()
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(fwd.localHost, fwd.localPort))
+	if err != nil {
+		return fmt.Errorf("cannot listen on local port: %w", err)
+	}
+	defer ln.Close()
+
+	_, fwd.localPort, _ = net.SplitHostPort(ln.Addr().String())
+	cli.PrintOut(
+		"Forwarding %s:%s to %s:%s over HTTP/2\n",
+		fwd.localHost, fwd.localPort,
+		fwd.remoteHost, fwd.remotePort,
+	)
+	ready()
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	fwderStatusCh, fwderErrCh := client.ContainerWait(
+		ctx,
+		forwarderID,
+		container.WaitConditionNotRunning,
+	)
+
+	// The tunnels are multiplexed over a single connection, so the limit
+	// is enforced on the local side - the excess connections are rejected.
+	var active atomic.Int64
+	var limitReported atomic.Bool
+
+	acceptErrCh := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				acceptErrCh <- err
+				return
+			}
+
+			if opts.connectionLimit > 0 && active.Load() >= int64(opts.connectionLimit) {
+				if !limitReported.Swap(true) {
+					cli.PrintAux(
+						"Forwarder %s reached the limit of %d simultaneous connections (see --connection-limit)\n",
+						forwarderID[:12], opts.connectionLimit,
+					)
+				}
+				conn.Close()
+				continue
+			}
+			active.Add(1)
+
+			go func() {
+				defer active.Add(-1)
+				defer conn.Close()
+
+				if opts.verbose {
+					cli.PrintAux("Tunneling connection from %s\n", conn.RemoteAddr())
+				}
+				if err := tunnel.Tunnel(ctx, conn); err != nil {
+					logrus.Debugf("Tunnel error: %s", err)
+				}
+			}()
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+
+	case status := <-fwderStatusCh:
+		return fmt.Errorf(
+			"forwarder %s exited with code %d: %v",
+			forwarderID, status.StatusCode, status.Error,
+		)
+
+	case err := <-fwderErrCh:
+		logrus.Debugf("Forwarder error: %s", err)
+		return fmt.Errorf("forwarder %s hiccuped: %w", forwarderID, err)
+
+	case err := <-acceptErrCh:
+		return fmt.Errorf("local listener failed: %w", err)
+	}
+}
+
+func startLocalH2Forwarder(
+	ctx context.Context,
+	client dockerclient.CommonAPIClient,
+	fwd directForwarding,
+	tunnelHost string,
+) (string, error) {
+	// The tunnel port is published on a random host port - the local
+	// side listens on the requested one itself.
+	bindIP := ""
+	if isLoopbackHost(tunnelHost) {
+		bindIP = tunnelHost
+	}
+	port := nat.Port(h2ServerPort + "/tcp")
+	exposedPorts := nat.PortSet{port: struct{}{}}
+	portBindings := nat.PortMap{port: []nat.PortBinding{{HostIP: bindIP}}}
+
+	resp, err := client.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image:      forwarderImage,
+			Entrypoint: []string{h2ServerBinary},
+			Cmd: []string{
+				"port-forward", h2ServerCommand,
+				"--listen", ":" + h2ServerPort,
+				"--connect", net.JoinHostPort(fwd.remoteHost, fwd.remotePort),
+			},
+			ExposedPorts: exposedPorts,
+		},
+		&container.HostConfig{
+			PortBindings: portBindings,
+			NetworkMode:  container.NetworkMode(fwd.targetNetwork),
+		},
+		nil,
+		nil,
+		"cdebug-fwd-"+uuid.ShortID(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("cannot create forwarder container: %w", err)
+	}
+
+	if err := copySelfToContainer(ctx, client, resp.ID); err != nil {
+		return resp.ID, fmt.Errorf("cannot copy cdebug binary to forwarder container: %w", err)
+	}
+
+	if err := client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return resp.ID, fmt.Errorf("cannot start forwarder container: %w", err)
+	}
+
+	return resp.ID, nil
+}
+
+// h2TunnelHost returns the address the forwarder's tunnel port is reachable
+// at: the daemon's host for a remote (TCP) daemon, and the loopback otherwise.
+func h2TunnelHost(client dockerclient.CommonAPIClient) string {
+	u, err := dockerclient.ParseHostURL(client.DaemonHost())
+	if err == nil && u.Scheme == "tcp" {
+		if host, _, err := net.SplitHostPort(u.Host); err == nil && host != "" {
+			return host
+		}
+	}
+	return "127.0.0.1"
+}
+
+func copySelfToContainer(
+	ctx context.Context,
+	client dockerclient.CommonAPIClient,
+	contID string,
+) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// E.g., a "go install"-ed cdebug built with cgo enabled.
+	if interp, err := ldd.Interpreter(self); err != nil {
+		return fmt.Errorf("cannot inspect cdebug binary: %w", err)
+	} else if interp != "" {
+		return fmt.Errorf("cdebug binary %s is dynamically linked (with %s) and cannot run in the forwarder container - use a static build (CGO_ENABLED=0) for --h2", self, interp)
+	}
+
+	f, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Name: h2ServerBinary[1:],
+			Mode: 0755,
+			Size: stat.Size(),
+		})
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return client.CopyToContainer(ctx, contID, "/", pr, types.CopyToContainerOptions{})
+}
@@ -0,0 +1,137 @@
+package portforward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusDegraded = "degraded"
+)
+
+// forwarderHealth tracks the state of the forwarders for the
+// --health-endpoint. All methods are no-ops on a nil receiver,
+// so the forwarders don't have to care if the endpoint is enabled.
+type forwarderHealth struct {
+	mu         sync.Mutex
+	forwarders []forwarderState
+}
+
+type forwarderState struct {
+	Forwarding string `json:"forwarding"`
+	Up         bool   `json:"up"`
+	Error      string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status     string           `json:"status"`
+	Forwarders []forwarderState `json:"forwarders"`
+}
+
+func newForwarderHealth(locals []string) *forwarderHealth {
+	h := &forwarderHealth{}
+	for _, l := range locals {
+		h.forwarders = append(h.forwarders, forwarderState{
+			Forwarding: l,
+			Error:      "not started yet",
+		})
+	}
+	return h
+}
+
+func (h *forwarderHealth) up(forwarding string) {
+	h.set(forwarding, true, "")
+}
+
+func (h *forwarderHealth) down(forwarding string, err error) {
+	if err == nil {
+		err = errors.New("stopped")
+	}
+	h.set(forwarding, false, err.Error())
+}
+
+func (h *forwarderHealth) set(forwarding string, up bool, reason string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.forwarders {
+		if h.forwarders[i].Forwarding == forwarding {
+			h.forwarders[i].Up = up
+			h.forwarders[i].Error = reason
+		}
+	}
+}
+
+func (h *forwarderHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.Lock()
+	resp := healthResponse{
+		Status:     healthStatusOK,
+		Forwarders: append([]forwarderState{}, h.forwarders...),
+	}
+	h.mu.Unlock()
+
+	code := http.StatusOK
+	for _, f := range resp.Forwarders {
+		if !f.Up {
+			resp.Status = healthStatusDegraded
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logrus.Debugf("Cannot write health response: %s", err)
+	}
+}
+
+// startHealthServer serves GET /healthz on the given port until
+// the context is cancelled.
+func startHealthServer(
+	ctx context.Context,
+	cli cliutil.CLI,
+	port int,
+	health *forwarderHealth,
+) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("cannot listen on health endpoint port: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			cli.PrintErr("Warning: health endpoint failed: %s\n", err)
+		}
+	}()
+
+	cli.PrintAux("Serving health checks on http://%s/healthz\n", ln.Addr())
+	return nil
+}
@@ -0,0 +1,45 @@
+package portforward
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestForwarderHealth(t *testing.T) {
+	health := newForwarderHealth([]string{"8080:80", "9090:90"})
+
+	check := func(wantCode int, wantStatus string) healthResponse {
+		rec := httptest.NewRecorder()
+		health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, rec.Code, wantCode)
+
+		var resp healthResponse
+		assert.NilError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(t, resp.Status, wantStatus)
+		return resp
+	}
+
+	check(http.StatusServiceUnavailable, healthStatusDegraded)
+
+	health.up("8080:80")
+	health.up("9090:90")
+	check(http.StatusOK, healthStatusOK)
+
+	health.down("9090:90", errors.New("boom"))
+	resp := check(http.StatusServiceUnavailable, healthStatusDegraded)
+	assert.DeepEqual(t, resp.Forwarders, []forwarderState{
+		{Forwarding: "8080:80", Up: true},
+		{Forwarding: "9090:90", Error: "boom"},
+	})
+}
+
+func TestForwarderHealthNil(t *testing.T) {
+	var health *forwarderHealth
+	health.up("8080:80")
+	health.down("8080:80", nil)
+}
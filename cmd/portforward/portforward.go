@@ -1,18 +1,27 @@
 package portforward
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -28,7 +37,8 @@ import (
 //       cdebug exec --name helper --image socat <target> <target-port> <proxy-port>
 //       cdebug port-forward helper <host-port>:<proxy-port>
 //
-//   - Remote port forwarding: implement me!
+//   - Remote port forwarding: tunnel back to cdebug itself instead of
+//     relying on the target's network gateway (see remoteForwardingConnectHost)
 //
 // Local port forwarding's possible modes (kinda sorta as in ssh -L):
 //   - REMOTE_PORT                                # binds TARGET_IP:REMOTE_PORT to a random port on localhost
@@ -43,8 +53,13 @@ import (
 //   - LOCAL_HOST:LOCAL_PORT:REMOTE_PORT          # similar to LOCAL_PORT:REMOTE_PORT but LOCAL_HOST is used instead of 127.0.0.1
 //   - LOCAL_HOST:LOCAL_PORT:REMOTE_<IP|ALIAS|NET>:REMOTE_PORT
 //
+//   - unix:///REMOTE_SOCKET[:LOCAL_PORT]         # binds a unix socket in the target's filesystem to a (random) port on localhost
+//   - [LOCAL_HOST:]LOCAL_PORT:unix:///REMOTE_SOCKET
+//
 // Remote port forwarding's possible modes (kinda sorta as in ssh -R):
-//   - coming soon...
+//   - REMOTE_PORT:LOCAL_HOST:LOCAL_PORT              # listens on 127.0.0.1:REMOTE_PORT in the target's netns
+//                                                    # and forwards to LOCAL_HOST:LOCAL_PORT on the cdebug side
+//   - REMOTE_HOST:REMOTE_PORT:LOCAL_HOST:LOCAL_PORT  # same but listens on REMOTE_HOST (e.g., 0.0.0.0)
 
 const (
 	forwarderImage = "nixery.dev/shell/socat:latest"
@@ -53,13 +68,19 @@ const (
 	outFormatJSON = "json"
 
 	cleanupTimeout = 3 * time.Second
+
+	connectionPollInterval = time.Second
 )
 
 var (
+	// Linux interface names are up to 15 characters long.
+	interfaceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.@-]{1,15}$`)
+
 	errNoAddr        = errors.New("target container must have at least one IP address")
 	errBadLocalPort  = errors.New("bad local port")
 	errBadRemoteHost = errors.New("bad remote host")
 	errBadRemotePort = errors.New("bad remote port")
+	errBadSocketPath = errors.New("bad remote socket path (must be absolute)")
 )
 
 type options struct {
@@ -67,8 +88,28 @@ type options struct {
 	locals         []string
 	remotes        []string
 	runningTimeout time.Duration
+	wait           time.Duration
 	output         string
 	quiet          bool
+	verbose        bool
+	h2             bool
+	h2Public       bool
+	iface          string
+
+	tlsCertFile string
+	tlsKeyFile  string
+	// Loaded from --tls-cert and --tls-key.
+	tlsCertificate *tls.Certificate
+
+	connectionLimit int
+
+	healthPort int
+	// Set up in runPortForward if --health-endpoint is set.
+	health *forwarderHealth
+
+	noPull           bool
+	pullAlways       bool
+	pullIfNotPresent bool
 
 	runtime string
 }
@@ -77,19 +118,49 @@ func NewCommand(cli cliutil.CLI) *cobra.Command {
 	var opts options
 
 	cmd := &cobra.Command{
-		Use:   "port-forward CONTAINER -L [LOCAL:]REMOTE [-L ...] | -R [REMOTE:]:LOCAL [-R ...]",
+		Use:   "port-forward CONTAINER -L [LOCAL:]REMOTE [-L ...] | -R [REMOTE:]LOCAL [-R ...]",
 		Short: `Forward one or more local or remote ports`,
 		Long: `While the implementation for sure differs, the behavior and semantic of the command
 are meant to be similar to SSH local (-L) and remote (-R) port forwarding. The word "local" always
 refers to the cdebug side. The word "remote" always refers to the target container side.`,
 		Args: cobra.ExactArgs(1),
+
+		ValidArgsFunction: completeTarget(&opts),
+
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(opts.locals)+len(opts.remotes) == 0 {
 				return cliutil.NewStatusError(1, "at least one -L or -R flag must be provided")
 			}
-			if len(opts.remotes) > 0 {
-				// TODO: Implement me!
-				return cliutil.NewStatusError(1, "remote port forwarding is not implemented yet")
+			if _, err := parseRemoteForwardings(opts.remotes); err != nil {
+				return cliutil.NewStatusError(1, "%s", err)
+			}
+
+			if countTrue(opts.noPull, opts.pullAlways, opts.pullIfNotPresent) > 1 {
+				return cliutil.NewStatusError(1, "only one of --no-pull, --pull-always, and --pull-if-not-present can be provided")
+			}
+			if opts.connectionLimit < 0 {
+				return cliutil.NewStatusError(1, "--connection-limit must not be negative")
+			}
+			if opts.healthPort < 0 || opts.healthPort > 65535 {
+				return cliutil.NewStatusError(1, "--health-endpoint must be a valid port number")
+			}
+
+			if opts.h2Public && !opts.h2 {
+				return cliutil.NewStatusError(1, "--h2-public requires --h2")
+			}
+
+			if opts.tlsCertFile != "" || opts.tlsKeyFile != "" {
+				if opts.tlsCertFile == "" || opts.tlsKeyFile == "" {
+					return cliutil.NewStatusError(1, "--tls-cert and --tls-key must be provided together")
+				}
+				if opts.h2 {
+					return cliutil.NewStatusError(1, "--tls-cert and --tls-key cannot be used with --h2")
+				}
+				cert, err := tls.LoadX509KeyPair(opts.tlsCertFile, opts.tlsKeyFile)
+				if err != nil {
+					return cliutil.NewStatusError(1, "cannot load TLS certificate: %s", err)
+				}
+				opts.tlsCertificate = &cert
 			}
 
 			cli.SetQuiet(opts.quiet)
@@ -100,6 +171,8 @@ refers to the cdebug side. The word "remote" always refers to the target contain
 		},
 	}
 
+	cmd.AddCommand(newH2ServerCommand())
+
 	flags := cmd.Flags()
 
 	flags.StringSliceVarP(
@@ -122,6 +195,12 @@ refers to the cdebug side. The word "remote" always refers to the target contain
 		10*time.Second,
 		`How long to wait until the target is up and running`,
 	)
+	flags.DurationVar(
+		&opts.wait,
+		"wait",
+		0,
+		`Wait up to the given duration until the forwarded local port accepts connections before reporting it`,
+	)
 	flags.BoolVarP(
 		&opts.quiet,
 		"quiet",
@@ -129,11 +208,78 @@ refers to the cdebug side. The word "remote" always refers to the target contain
 		false,
 		`Suppress verbose output`,
 	)
+	flags.BoolVarP(
+		&opts.verbose,
+		"verbose",
+		"v",
+		false,
+		`Log every connection going through the forwarders`,
+	)
+	flags.StringVar(
+		&opts.iface,
+		"interface",
+		"",
+		`Forward to the target's IP on this network interface (e.g., eth1) - for targets attached to multiple networks`,
+	)
+	flags.IntVar(
+		&opts.connectionLimit,
+		"connection-limit",
+		100,
+		`Maximum number of simultaneous connections per forwarding (0 means unlimited)`,
+	)
+	flags.IntVar(
+		&opts.healthPort,
+		"health-endpoint",
+		0,
+		`Serve GET /healthz on this local port: 200 if all forwarders are up, 503 otherwise (e.g., for liveness probes)`,
+	)
+	flags.BoolVar(
+		&opts.h2,
+		"h2",
+		false,
+		`Tunnel the forwarded connections over cleartext HTTP/2 (CONNECT) instead of raw TCP (Linux only)`,
+	)
+	flags.BoolVar(
+		&opts.h2Public,
+		"h2-public",
+		false,
+		`Allow publishing the --h2 tunnel port on all interfaces of a remote (tcp://) Docker daemon's host - the tunnel is unauthenticated`,
+	)
+	flags.StringVar(
+		&opts.tlsCertFile,
+		"tls-cert",
+		"",
+		`Serve the forwarded ports over HTTPS with this certificate (PEM) and proxy the requests to the target as plain HTTP`,
+	)
+	flags.StringVar(
+		&opts.tlsKeyFile,
+		"tls-key",
+		"",
+		`Private key (PEM) for the --tls-cert certificate`,
+	)
+	flags.BoolVar(
+		&opts.noPull,
+		"no-pull",
+		false,
+		`Never pull the forwarder image (it must already be present locally)`,
+	)
+	flags.BoolVar(
+		&opts.pullAlways,
+		"pull-always",
+		false,
+		`Always pull the forwarder image, even if it's present locally`,
+	)
+	flags.BoolVar(
+		&opts.pullIfNotPresent,
+		"pull-if-not-present",
+		false,
+		`Pull the forwarder image only if it's not present locally (default)`,
+	)
 	flags.StringVar(
 		&opts.runtime,
 		"runtime",
-		"",
-		`Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "https://<kube-api-addr>:8433/...)`,
+		os.Getenv("CDEBUG_RUNTIME"),
+		`Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "https://<kube-api-addr>:8433/..."; can also be set via $CDEBUG_RUNTIME)`,
 	)
 
 	return cmd
@@ -148,27 +294,20 @@ func runPortForward(ctx context.Context, cli cliutil.CLI, opts *options) error {
 		return err
 	}
 
-	// Find existing forwarder image.
-	images, err := client.ImageList(ctx, types.ImageListOptions{
-		All: true,
-		Filters: filters.NewArgs(
-			filters.Arg("reference", forwarderImage),
-		),
-	})
-	if err != nil || len(images) == 0 {
-		cli.PrintAux("Pulling forwarder image...\n")
-		if err := client.ImagePullEx(ctx, forwarderImage, types.ImagePullOptions{
-			// Platform: ... TODO: Test if an arm64 sidecar can be attached to an amd64 target and vice versa.
-		}); err != nil {
-			return fmt.Errorf("cannot pull forwarder image %q: %w", forwarderImage, err)
-		}
-	} else {
-		cli.PrintAux("Using existing forwarder image...\n")
+	if err := ensureForwarderImage(ctx, cli, client, opts); err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithCancel(signalutil.InterruptibleContext(ctx))
 	defer cancel()
 
+	if opts.healthPort > 0 {
+		opts.health = newForwarderHealth(append(append([]string{}, opts.locals...), opts.remotes...))
+		if err := startHealthServer(ctx, cli, opts.healthPort, opts.health); err != nil {
+			return err
+		}
+	}
+
 	for {
 		cont, err := runLocalPortForwarding(ctx, cli, client, opts)
 		if err != nil {
@@ -183,6 +322,50 @@ func runPortForward(ctx context.Context, cli cliutil.CLI, opts *options) error {
 	}
 }
 
+func ensureForwarderImage(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client *docker.Client,
+	opts *options,
+) error {
+	if opts.noPull {
+		cli.PrintAux("Skipping forwarder image pull...\n")
+		return nil
+	}
+
+	if !opts.pullAlways {
+		// Find existing forwarder image.
+		images, err := client.ImageList(ctx, types.ImageListOptions{
+			All: true,
+			Filters: filters.NewArgs(
+				filters.Arg("reference", forwarderImage),
+			),
+		})
+		if err == nil && len(images) > 0 {
+			cli.PrintAux("Using existing forwarder image...\n")
+			return nil
+		}
+	}
+
+	cli.PrintAux("Pulling forwarder image...\n")
+	if err := client.ImagePullEx(ctx, forwarderImage, types.ImagePullOptions{
+		// Platform: ... TODO: Test if an arm64 sidecar can be attached to an amd64 target and vice versa.
+	}); err != nil {
+		return fmt.Errorf("cannot pull forwarder image %q: %w", forwarderImage, err)
+	}
+	return nil
+}
+
+func countTrue(flags ...bool) int {
+	n := 0
+	for _, f := range flags {
+		if f {
+			n++
+		}
+	}
+	return n
+}
+
 func runLocalPortForwarding(
 	ctx context.Context,
 	cli cliutil.CLI,
@@ -198,18 +381,31 @@ func runLocalPortForwarding(
 		return false, err
 	}
 
+	if len(opts.iface) > 0 {
+		network, err := targetInterfaceNetwork(ctx, client, target, opts.iface)
+		if err != nil {
+			return false, err
+		}
+		target = withOnlyNetwork(target, network)
+	}
+
 	locals, err := parseLocalForwardings(target, opts.locals)
 	if err != nil {
 		return false, err
 	}
 
+	remotes, err := parseRemoteForwardings(opts.remotes)
+	if err != nil {
+		return false, err
+	}
+
 	// Start a new context bound to a single target lifecycle.
 	// It'll be used mostly to terminate the forwarders if a
 	// given instance of the target terminates.
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	fwdersErrorCh := startLocalForwarders(ctx, cli, client, target, locals)
+	fwdersErrorCh := startForwarders(ctx, cli, client, opts, target, locals, remotes)
 
 	targetStatusCh, targetErrorCh := client.ContainerWait(
 		ctx,
@@ -287,10 +483,18 @@ func validateTarget(target types.ContainerJSON) error {
 }
 
 type forwarding struct {
-	localHost  string
-	localPort  string
-	remoteHost string
-	remotePort string
+	localHost    string
+	localPort    string
+	remoteHost   string
+	remotePort   string
+	remoteSocket string
+}
+
+func (f forwarding) remoteAddr() string {
+	if len(f.remoteSocket) > 0 {
+		return "unix://" + f.remoteSocket
+	}
+	return f.remoteHost + ":" + f.remotePort
 }
 
 type directForwarding struct {
@@ -301,6 +505,7 @@ type directForwarding struct {
 type sidecarForwarding struct {
 	forwarding
 	targetID      string // for netns
+	targetPID     int    // for unix sockets
 	targetNetwork string
 	targetHost    string
 	sidecarPort   string
@@ -325,6 +530,10 @@ func parseLocalForwarding(
 	target types.ContainerJSON,
 	local string,
 ) (forwarding, error) {
+	if strings.Contains(local, "unix://") {
+		return parseLocalSocketForwarding(local)
+	}
+
 	parts := strings.Split(local, ":")
 	if len(parts) == 1 {
 		// Case 1: REMOTE_PORT only
@@ -412,6 +621,50 @@ func parseLocalForwarding(
 	}, nil
 }
 
+func parseLocalSocketForwarding(local string) (forwarding, error) {
+	idx := strings.Index(local, "unix://")
+
+	var fwd forwarding
+	if idx == 0 {
+		// unix:///REMOTE_SOCKET or unix:///REMOTE_SOCKET:LOCAL_PORT
+		fwd.remoteSocket = strings.TrimPrefix(local, "unix://")
+		if sep := strings.LastIndex(fwd.remoteSocket, ":"); sep != -1 {
+			fwd.localPort = fwd.remoteSocket[sep+1:]
+			fwd.remoteSocket = fwd.remoteSocket[:sep]
+		}
+	} else {
+		// LOCAL_PORT:unix:///REMOTE_SOCKET or LOCAL_HOST:LOCAL_PORT:unix:///REMOTE_SOCKET
+		if local[idx-1] != ':' {
+			return forwarding{}, errBadSocketPath
+		}
+
+		fwd.remoteSocket = local[idx+len("unix://"):]
+
+		parts := strings.Split(local[:idx-1], ":")
+		switch len(parts) {
+		case 1:
+			fwd.localPort = parts[0]
+		case 2:
+			fwd.localHost = parts[0]
+			fwd.localPort = parts[1]
+		default:
+			return forwarding{}, errBadLocalPort
+		}
+	}
+
+	if len(fwd.localPort) > 0 {
+		if _, err := nat.ParsePort(fwd.localPort); err != nil {
+			return forwarding{}, errBadLocalPort
+		}
+	}
+
+	if !strings.HasPrefix(fwd.remoteSocket, "/") {
+		return forwarding{}, errBadSocketPath
+	}
+
+	return fwd, nil
+}
+
 func unambiguousIP(target types.ContainerJSON) (string, error) {
 	var found string
 	for _, net := range target.NetworkSettings.Networks {
@@ -431,6 +684,93 @@ func unambiguousIP(target types.ContainerJSON) (string, error) {
 	return found, nil
 }
 
+// targetInterfaceNetwork finds the target's network the given interface
+// is attached to. Docker doesn't report the interface names, so the MAC
+// address of the interface is read by a short-lived helper container
+// sharing the target's network namespace.
+func targetInterfaceNetwork(
+	ctx context.Context,
+	client dockerclient.CommonAPIClient,
+	target types.ContainerJSON,
+	iface string,
+) (string, error) {
+	if !interfaceNameRegex.MatchString(iface) || iface == "." || iface == ".." {
+		return "", fmt.Errorf("bad network interface name %q", iface)
+	}
+
+	resp, err := client.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image:      forwarderImage,
+			Entrypoint: []string{"cat"},
+			Cmd:        []string{"/sys/class/net/" + iface + "/address"},
+		},
+		&container.HostConfig{
+			NetworkMode: container.NetworkMode("container:" + target.ID),
+		},
+		nil,
+		nil,
+		"cdebug-fwd-"+uuid.ShortID(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("cannot create interface lookup container: %w", err)
+	}
+	defer cleanupContainerIfExist(client, resp.ID)
+
+	statusCh, errCh := client.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return "", fmt.Errorf("cannot start interface lookup container: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case err := <-errCh:
+		return "", fmt.Errorf("interface lookup container failed: %w", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return "", fmt.Errorf("target has no network interface %q", iface)
+		}
+	}
+
+	logs, err := client.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true})
+	if err != nil {
+		return "", fmt.Errorf("cannot read interface lookup container logs: %w", err)
+	}
+	defer logs.Close()
+
+	var mac strings.Builder
+	if _, err := stdcopy.StdCopy(&mac, io.Discard, logs); err != nil {
+		return "", fmt.Errorf("cannot read interface lookup container logs: %w", err)
+	}
+
+	network, err := networkByMAC(target, strings.TrimSpace(mac.String()))
+	if err != nil {
+		return "", fmt.Errorf("network interface %q: %w", iface, err)
+	}
+	return network, nil
+}
+
+func networkByMAC(target types.ContainerJSON, mac string) (string, error) {
+	for name, net := range target.NetworkSettings.Networks {
+		if len(net.IPAddress) > 0 && strings.EqualFold(net.MacAddress, mac) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no target's network has an IP address on MAC %s", mac)
+}
+
+// withOnlyNetwork narrows the target's networks down to the given one,
+// so that the forwardings are resolved against its IP address only.
+func withOnlyNetwork(target types.ContainerJSON, name string) types.ContainerJSON {
+	settings := *target.NetworkSettings
+	settings.Networks = map[string]*network.EndpointSettings{
+		name: target.NetworkSettings.Networks[name],
+	}
+	target.NetworkSettings = &settings
+	return target
+}
+
 func lookupTargetIP(target types.ContainerJSON, ipAliasNetwork string) (string, error) {
 	for name, net := range target.NetworkSettings.Networks {
 		if len(net.IPAddress) == 0 {
@@ -473,34 +813,58 @@ func targetNetworkByIP(target types.ContainerJSON, ip string) (string, error) {
 	return "", errors.New("cannot deduce target network by IP")
 }
 
-func startLocalForwarders(
+func startForwarders(
 	ctx context.Context,
 	cli cliutil.CLI,
 	client dockerclient.CommonAPIClient,
+	opts *options,
 	target types.ContainerJSON,
 	locals []forwarding,
+	remotes []forwarding,
 ) <-chan error {
 	doneCh := make(chan error, 1)
 
 	go func() {
-		var errored bool
+		var errored atomic.Bool
 		var wg sync.WaitGroup
 
-		for _, fwd := range locals {
+		for i, fwd := range locals {
 			wg.Add(1)
 
-			go func(fwd forwarding) {
+			// locals are parsed from opts.locals one by one.
+			go func(name string, fwd forwarding) {
 				defer wg.Done()
 
-				if err := runLocalForwarder(ctx, cli, client, target, fwd); err != nil {
+				err := runLocalForwarder(ctx, cli, client, opts, target, fwd, func() {
+					opts.health.up(name)
+				})
+				opts.health.down(name, err)
+				if err != nil {
 					logrus.Debugf("Forwarding error: %s", err)
-					errored = true
+					errored.Store(true)
+				}
+			}(opts.locals[i], fwd)
+		}
+
+		for i, fwd := range remotes {
+			wg.Add(1)
+
+			go func(name string, fwd forwarding) {
+				defer wg.Done()
+
+				err := runRemoteForwarder(ctx, cli, client, opts, target, fwd, func() {
+					opts.health.up(name)
+				})
+				opts.health.down(name, err)
+				if err != nil {
+					logrus.Debugf("Remote forwarding error: %s", err)
+					errored.Store(true)
 				}
-			}(fwd)
+			}(opts.remotes[i], fwd)
 		}
 
 		wg.Wait()
-		if errored {
+		if errored.Load() {
 			doneCh <- errors.New("one or more forwarders failed")
 		}
 		close(doneCh)
@@ -513,14 +877,30 @@ func runLocalForwarder(
 	ctx context.Context,
 	cli cliutil.CLI,
 	client dockerclient.CommonAPIClient,
+	opts *options,
 	target types.ContainerJSON,
 	fwd forwarding,
+	ready func(),
 ) error {
 	if len(fwd.localHost) == 0 {
 		fwd.localHost = "127.0.0.1"
 	}
 
-	if len(fwd.remoteHost) == 0 {
+	runDirectForwarder := runLocalDirectForwarder
+	if opts.h2 {
+		if err := validateH2Forwarding(fwd); err != nil {
+			return err
+		}
+		runDirectForwarder = runLocalH2Forwarder
+	}
+	if opts.tlsCertificate != nil {
+		if err := validateTLSForwarding(fwd); err != nil {
+			return err
+		}
+		runDirectForwarder = runLocalTLSForwarder
+	}
+
+	if len(fwd.remoteHost) == 0 && len(fwd.remoteSocket) == 0 {
 		remoteIP, err := unambiguousIP(target)
 		if err != nil {
 			return err
@@ -531,10 +911,11 @@ func runLocalForwarder(
 			return err
 		}
 
-		return runLocalDirectForwarder(
+		return runDirectForwarder(
 			ctx,
 			cli,
 			client,
+			opts,
 			directForwarding{
 				targetNetwork: network,
 				forwarding: forwarding{
@@ -544,19 +925,21 @@ func runLocalForwarder(
 					remotePort: fwd.remotePort,
 				},
 			},
+			ready,
 		)
 	}
 
-	if remoteIP, err := lookupTargetIP(target, fwd.remoteHost); err == nil {
+	if remoteIP, err := lookupTargetIP(target, fwd.remoteHost); err == nil && len(fwd.remoteSocket) == 0 {
 		network, err := targetNetworkByIP(target, remoteIP)
 		if err != nil {
 			return err
 		}
 
-		return runLocalDirectForwarder(
+		return runDirectForwarder(
 			ctx,
 			cli,
 			client,
+			opts,
 			directForwarding{
 				targetNetwork: network,
 				forwarding: forwarding{
@@ -566,9 +949,18 @@ func runLocalForwarder(
 					remotePort: fwd.remotePort,
 				},
 			},
+			ready,
 		)
 	}
 
+	if opts.h2 {
+		// The tunnel server runs in the target's network, not in its netns.
+		return errors.New("--h2 flag supports forwarding only to the target's own IP addresses")
+	}
+	if opts.tlsCertificate != nil {
+		return errors.New("--tls-cert flag supports forwarding only to the target's own IP addresses")
+	}
+
 	// In a multi-network case, pick a random one.
 	var targetNetwork, targetIP string
 	for name, settings := range target.NetworkSettings.Networks {
@@ -582,16 +974,24 @@ func runLocalForwarder(
 		return errors.New("target is not attached to any networks")
 	}
 
+	targetPID := 1
+	if target.HostConfig.PidMode.IsHost() {
+		targetPID = target.State.Pid
+	}
+
 	return runLocalSidecarForwarder(
 		ctx,
 		cli,
 		client,
+		opts,
 		sidecarForwarding{
 			targetID:      target.ID,
+			targetPID:     targetPID,
 			targetNetwork: targetNetwork,
 			targetHost:    targetIP,
 			forwarding:    fwd, // as is
 		},
+		ready,
 	)
 }
 
@@ -599,19 +999,29 @@ func runLocalDirectForwarder(
 	ctx context.Context,
 	cli cliutil.CLI,
 	client dockerclient.CommonAPIClient,
+	opts *options,
 	fwd directForwarding,
+	ready func(),
 ) error {
 	// TODO: Try start() N times.
 
-	forwarderID, err := startLocalDirectForwarder(ctx, client, fwd)
+	forwarderID, err := startLocalDirectForwarder(ctx, client, fwd, opts)
 	defer cleanupContainerIfExist(client, forwarderID)
 	if err != nil {
 		return fmt.Errorf("starting forwarder failed: %w", err)
 	}
 
-	if err := printLocalDirectForwarding(ctx, cli, client, fwd, forwarderID); err != nil {
+	if opts.verbose {
+		go streamForwarderLogs(ctx, cli, client, forwarderID)
+	}
+	if opts.connectionLimit > 0 {
+		go watchConnectionLimit(ctx, cli, client, opts, forwarderID)
+	}
+
+	if err := printLocalDirectForwarding(ctx, cli, client, opts, fwd, forwarderID); err != nil {
 		return err
 	}
+	ready()
 
 	fwderStatusCh, fwderErrCh := client.ContainerWait(
 		ctx,
@@ -641,6 +1051,7 @@ func startLocalDirectForwarder(
 	ctx context.Context,
 	client dockerclient.CommonAPIClient,
 	fwd directForwarding,
+	opts *options,
 ) (string, error) {
 	portMapSpec := fwd.localHost + ":" + fwd.localPort + ":" + fwd.remotePort
 	exposedPorts, portBindings, err := nat.ParsePortSpecs([]string{portMapSpec})
@@ -653,10 +1064,10 @@ func startLocalDirectForwarder(
 		&container.Config{
 			Image:      forwarderImage,
 			Entrypoint: []string{"socat"},
-			Cmd: []string{
-				fmt.Sprintf("TCP4-LISTEN:%s,fork", fwd.remotePort),
+			Cmd: append(socatLogFlags(opts.verbose),
+				socatListenAddress(fwd.remotePort, opts.connectionLimit),
 				fmt.Sprintf("TCP-CONNECT:%s:%s", fwd.remoteHost, fwd.remotePort),
-			},
+			),
 			Env:          []string{"SOCAT_DEFAULT_LISTEN_IP=0.0.0.0"},
 			ExposedPorts: exposedPorts,
 		},
@@ -679,16 +1090,124 @@ func startLocalDirectForwarder(
 	return resp.ID, nil
 }
 
+// socatLogFlags makes socat log every accepted connection
+// (and its termination) to stderr - only with --verbose.
+func socatLogFlags(enabled bool) []string {
+	if enabled {
+		return []string{"-d", "-d"}
+	}
+	return nil
+}
+
+// socatListenAddress caps the number of forked socat children (i.e.,
+// simultaneous connections) - otherwise a burst of connections may
+// exhaust the forwarder container's PID limit.
+func socatListenAddress(port string, limit int) string {
+	addr := fmt.Sprintf("TCP4-LISTEN:%s,fork", port)
+	if limit > 0 {
+		addr += fmt.Sprintf(",max-children=%d", limit)
+	}
+	return addr
+}
+
+// connectionCounter reports when the number of active forwarder
+// connections hits the limit.
+type connectionCounter struct {
+	limit   int
+	reached bool
+}
+
+// observe returns true when the active connections make the counter
+// hit the limit for the first time.
+func (c *connectionCounter) observe(active int) bool {
+	if c.limit > 0 && !c.reached && active >= c.limit {
+		c.reached = true
+		return true
+	}
+	return false
+}
+
+// watchConnectionLimit polls the forwarder's processes (socat forks a child
+// per connection) until the forwarder stops or the context is canceled, and
+// reports when the --connection-limit is reached.
+func watchConnectionLimit(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client dockerclient.CommonAPIClient,
+	opts *options,
+	forwarderID string,
+) {
+	counter := connectionCounter{limit: opts.connectionLimit}
+
+	ticker := time.NewTicker(connectionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		top, err := client.ContainerTop(ctx, forwarderID, nil)
+		if err != nil {
+			logrus.Debugf("Cannot list forwarder %s processes: %s", forwarderID, err)
+			return
+		}
+
+		// The first process is the listening socat itself.
+		if counter.observe(len(top.Processes) - 1) {
+			cli.PrintAux(
+				"Forwarder %s reached the limit of %d simultaneous connections (see --connection-limit)\n",
+				forwarderID[:12], opts.connectionLimit,
+			)
+			return
+		}
+	}
+}
+
+// streamForwarderLogs relays the forwarder's socat logs to the aux stream
+// until the forwarder stops or the context is canceled.
+func streamForwarderLogs(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client dockerclient.CommonAPIClient,
+	forwarderID string,
+) {
+	logs, err := client.ContainerLogs(ctx, forwarderID, container.LogsOptions{
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		logrus.Debugf("Cannot stream forwarder %s logs: %s", forwarderID, err)
+		return
+	}
+	defer logs.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(io.Discard, pw, logs)
+		pw.CloseWithError(err)
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		cli.PrintAux("[forwarder %s] %s\n", forwarderID[:12], scanner.Text())
+	}
+}
+
 func runLocalSidecarForwarder(
 	ctx context.Context,
 	cli cliutil.CLI,
 	client dockerclient.CommonAPIClient,
+	opts *options,
 	fwd sidecarForwarding,
+	ready func(),
 ) error {
 	// TODO: Try starting sidecar and forwarder N times.
 
 	sidecarID, sidecarPort, err := startLocalSidecarForwarder(
-		ctx, client, fwd.targetID, fwd.remoteHost, fwd.remotePort,
+		ctx, client, fwd.targetID, fwd.targetPID, socatRemoteAddress(fwd), opts.connectionLimit,
 	)
 	defer cleanupContainerIfExist(client, sidecarID)
 	if err != nil {
@@ -709,15 +1228,24 @@ func runLocalSidecarForwarder(
 				remotePort: fwd.sidecarPort,
 			},
 		},
+		opts,
 	)
 	defer cleanupContainerIfExist(client, forwarderID)
 	if err != nil {
 		return fmt.Errorf("starting forwarder faield: %w", err)
 	}
 
-	if err := printLocalSidecarForwarding(ctx, cli, client, fwd, forwarderID); err != nil {
+	if opts.verbose {
+		go streamForwarderLogs(ctx, cli, client, forwarderID)
+	}
+	if opts.connectionLimit > 0 {
+		go watchConnectionLimit(ctx, cli, client, opts, forwarderID)
+	}
+
+	if err := printLocalSidecarForwarding(ctx, cli, client, opts, fwd, forwarderID); err != nil {
 		return err
 	}
+	ready()
 
 	sidecarStatusCh, sidecarErrCh := client.ContainerWait(
 		ctx,
@@ -763,8 +1291,9 @@ func startLocalSidecarForwarder(
 	ctx context.Context,
 	client dockerclient.CommonAPIClient,
 	targetID string,
-	remoteHost string,
-	remotePort string,
+	targetPID int,
+	remoteAddr string,
+	connectionLimit int,
 ) (string, string, error) {
 	// TODO: This random port may conflict with a port already used by the
 	//       target container. Instead, we should use socat TCP-LISTEN:0 and
@@ -776,13 +1305,15 @@ func startLocalSidecarForwarder(
 			Image:      forwarderImage,
 			Entrypoint: []string{"socat"},
 			Cmd: []string{
-				fmt.Sprintf("TCP4-LISTEN:%s,fork", randomPort),
-				fmt.Sprintf("TCP-CONNECT:%s:%s", remoteHost, remotePort),
+				socatListenAddress(randomPort, connectionLimit),
+				remoteAddr,
 			},
 			Env: []string{"SOCAT_DEFAULT_LISTEN_IP=0.0.0.0"},
 		},
 		&container.HostConfig{
 			NetworkMode: container.NetworkMode("container:" + targetID),
+			// Unix sockets are reachable via /proc/<pid>/root of the target.
+			PidMode: container.PidMode("container:" + targetID),
 		},
 		nil,
 		nil,
@@ -799,10 +1330,18 @@ func startLocalSidecarForwarder(
 	return resp.ID, randomPort, nil
 }
 
+func socatRemoteAddress(fwd sidecarForwarding) string {
+	if len(fwd.remoteSocket) > 0 {
+		return fmt.Sprintf("UNIX-CONNECT:/proc/%d/root%s", fwd.targetPID, fwd.remoteSocket)
+	}
+	return fmt.Sprintf("TCP-CONNECT:%s:%s", fwd.remoteHost, fwd.remotePort)
+}
+
 func printLocalDirectForwarding(
 	ctx context.Context,
 	cli cliutil.CLI,
 	client dockerclient.CommonAPIClient,
+	opts *options,
 	fwd directForwarding,
 	forwarderID string,
 ) error {
@@ -822,6 +1361,12 @@ func printLocalDirectForwarding(
 		}
 	}
 
+	if opts.wait > 0 {
+		if err := waitForLocalPort(ctx, fwd.localHost, fwd.localPort, opts.wait); err != nil {
+			return err
+		}
+	}
+
 	cli.PrintOut(
 		"Forwarding %s:%s to %s:%s\n",
 		fwd.localHost, fwd.localPort,
@@ -835,6 +1380,7 @@ func printLocalSidecarForwarding(
 	ctx context.Context,
 	cli cliutil.CLI,
 	client dockerclient.CommonAPIClient,
+	opts *options,
 	fwd sidecarForwarding,
 	forwarderID string,
 ) error {
@@ -854,16 +1400,62 @@ func printLocalSidecarForwarding(
 		}
 	}
 
+	if opts.wait > 0 {
+		if err := waitForLocalPort(ctx, fwd.localHost, fwd.localPort, opts.wait); err != nil {
+			return err
+		}
+	}
+
 	cli.PrintOut(
-		"Forwarding %s:%s to %s:%s through %s:%s\n",
+		"Forwarding %s:%s to %s through %s:%s\n",
 		fwd.localHost, fwd.localPort,
-		fwd.remoteHost, fwd.remotePort,
+		fwd.remoteAddr(),
 		fwd.targetHost, fwd.sidecarPort,
 	)
 
 	return nil
 }
 
+func waitForLocalPort(
+	ctx context.Context,
+	host string,
+	port string,
+	timeout time.Duration,
+) error {
+	if host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	addr := net.JoinHostPort(host, port)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	readyCh := make(chan struct{})
+	go func() {
+		for ctx.Err() == nil {
+			conn, err := net.DialTimeout("tcp", addr, 500*time.Millisecond)
+			if err == nil {
+				conn.Close()
+				close(readyCh)
+				return
+			}
+
+			logrus.Debugf("Forwarded port %s is not reachable yet: %s", addr, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+
+	select {
+	case <-readyCh:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("forwarded port %s didn't become reachable in %s", addr, timeout)
+	}
+}
+
 func cleanupContainerIfExist(
 	client dockerclient.CommonAPIClient,
 	contID string,
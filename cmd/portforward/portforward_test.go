@@ -0,0 +1,182 @@
+package portforward
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	dockerclient "github.com/docker/docker/client"
+	"gotest.tools/assert"
+)
+
+func TestParseLocalForwardingUnixSocket(t *testing.T) {
+	target := types.ContainerJSON{
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"bridge": {IPAddress: "172.17.0.2"},
+			},
+		},
+	}
+
+	tests := []struct {
+		spec string
+		want forwarding
+	}{
+		{
+			spec: "unix:///var/run/app.sock",
+			want: forwarding{remoteSocket: "/var/run/app.sock"},
+		},
+		{
+			spec: "unix:///var/run/app.sock:8080",
+			want: forwarding{localPort: "8080", remoteSocket: "/var/run/app.sock"},
+		},
+		{
+			spec: "8080:unix:///var/run/app.sock",
+			want: forwarding{localPort: "8080", remoteSocket: "/var/run/app.sock"},
+		},
+		{
+			spec: "0.0.0.0:8080:unix:///var/run/app.sock",
+			want: forwarding{localHost: "0.0.0.0", localPort: "8080", remoteSocket: "/var/run/app.sock"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseLocalForwarding(target, tt.spec)
+			assert.NilError(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseLocalForwardingUnixSocketErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want error
+	}{
+		{spec: "unix://var/run/app.sock", want: errBadSocketPath},
+		{spec: "unix:///var/run/app.sock:http", want: errBadLocalPort},
+		{spec: "abc:bar:8080:unix:///var/run/app.sock", want: errBadLocalPort},
+		{spec: "8080unix:///var/run/app.sock", want: errBadSocketPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := parseLocalForwarding(types.ContainerJSON{}, tt.spec)
+			assert.Equal(t, err, tt.want)
+		})
+	}
+}
+
+func TestSocatListenAddress(t *testing.T) {
+	assert.Equal(t, socatListenAddress("8080", 0), "TCP4-LISTEN:8080,fork")
+	assert.Equal(t, socatListenAddress("8080", 100), "TCP4-LISTEN:8080,fork,max-children=100")
+}
+
+func TestConnectionCounter(t *testing.T) {
+	c := connectionCounter{limit: 2}
+
+	assert.Check(t, !c.observe(0))
+	assert.Check(t, !c.observe(1))
+	assert.Check(t, c.observe(2))
+
+	// Reported only once.
+	assert.Check(t, !c.observe(1))
+	assert.Check(t, !c.observe(3))
+
+	unlimited := connectionCounter{}
+	assert.Check(t, !unlimited.observe(1000))
+}
+
+func TestWithOnlyNetworkByMAC(t *testing.T) {
+	target := types.ContainerJSON{
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"frontend": {IPAddress: "172.18.0.2", MacAddress: "02:42:ac:12:00:02"},
+				"backend":  {IPAddress: "172.19.0.2", MacAddress: "02:42:ac:13:00:02"},
+			},
+		},
+	}
+
+	_, err := unambiguousIP(target)
+	assert.ErrorContains(t, err, "multiple network interfaces")
+
+	name, err := networkByMAC(target, "02:42:AC:13:00:02")
+	assert.NilError(t, err)
+	assert.Equal(t, name, "backend")
+
+	_, err = networkByMAC(target, "02:42:ac:14:00:02")
+	assert.ErrorContains(t, err, "no target's network")
+
+	narrowed := withOnlyNetwork(target, name)
+	ip, err := unambiguousIP(narrowed)
+	assert.NilError(t, err)
+	assert.Equal(t, ip, "172.19.0.2")
+
+	// The original target is left intact.
+	assert.Equal(t, len(target.NetworkSettings.Networks), 2)
+}
+
+func TestParseRemoteForwarding(t *testing.T) {
+	tests := []struct {
+		spec string
+		want forwarding
+	}{
+		{
+			spec: "8080:localhost:80",
+			want: forwarding{remoteHost: "127.0.0.1", remotePort: "8080", localHost: "localhost", localPort: "80"},
+		},
+		{
+			spec: "0.0.0.0:8080:192.168.1.10:80",
+			want: forwarding{remoteHost: "0.0.0.0", remotePort: "8080", localHost: "192.168.1.10", localPort: "80"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRemoteForwarding(tt.spec)
+			assert.NilError(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseRemoteForwardingErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want error
+	}{
+		{spec: "8080:localhost:http", want: errBadLocalPort},
+		{spec: "8080::80", want: errBadLocalHost},
+		{spec: ":8080:localhost:80", want: errBadRemoteHost},
+		{spec: "0.0.0.0::localhost:80", want: errBadRemotePort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := parseRemoteForwarding(tt.spec)
+			assert.Equal(t, err, tt.want)
+		})
+	}
+
+	_, err := parseRemoteForwarding("8080:80")
+	assert.ErrorContains(t, err, "bad remote forwarding")
+}
+
+func TestH2TunnelHost(t *testing.T) {
+	tests := []struct {
+		daemon string
+		want   string
+	}{
+		{daemon: "unix:///var/run/docker.sock", want: "127.0.0.1"},
+		{daemon: "tcp://10.0.0.5:2376", want: "10.0.0.5"},
+		{daemon: "tcp://[fd00::5]:2376", want: "fd00::5"},
+		{daemon: "tcp://docker.example.com:2375", want: "docker.example.com"},
+	}
+
+	for _, tt := range tests {
+		client, err := dockerclient.NewClientWithOpts(dockerclient.WithHost(tt.daemon))
+		assert.NilError(t, err)
+		assert.Equal(t, h2TunnelHost(client), tt.want, tt.daemon)
+	}
+}
@@ -0,0 +1,219 @@
+package portforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/uuid"
+)
+
+// As in ssh -R, the remote port is bound to the target's loopback by default.
+const defaultRemoteListenHost = "127.0.0.1"
+
+var errBadLocalHost = errors.New("bad local host")
+
+func parseRemoteForwardings(remotes []string) ([]forwarding, error) {
+	var parsed []forwarding
+	for _, r := range remotes {
+		next, err := parseRemoteForwarding(r)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, next)
+	}
+	return parsed, nil
+}
+
+// parseRemoteForwarding parses the -R [REMOTE_HOST:]REMOTE_PORT:LOCAL_HOST:LOCAL_PORT
+// flag. The remote side is where the (sidecar) listener runs, i.e., the target.
+func parseRemoteForwarding(remote string) (forwarding, error) {
+	parts := strings.Split(remote, ":")
+	if len(parts) == 3 {
+		parts = append([]string{defaultRemoteListenHost}, parts...)
+	}
+	if len(parts) != 4 {
+		return forwarding{}, fmt.Errorf("bad remote forwarding %q (must be [REMOTE_HOST:]REMOTE_PORT:LOCAL_HOST:LOCAL_PORT)", remote)
+	}
+
+	if len(parts[0]) == 0 {
+		return forwarding{}, errBadRemoteHost
+	}
+	if _, err := nat.ParsePort(parts[1]); err != nil || len(parts[1]) == 0 {
+		return forwarding{}, errBadRemotePort
+	}
+	if len(parts[2]) == 0 {
+		return forwarding{}, errBadLocalHost
+	}
+	if _, err := nat.ParsePort(parts[3]); err != nil || len(parts[3]) == 0 {
+		return forwarding{}, errBadLocalPort
+	}
+
+	return forwarding{
+		remoteHost: parts[0],
+		remotePort: parts[1],
+		localHost:  parts[2],
+		localPort:  parts[3],
+	}, nil
+}
+
+// runRemoteForwarder starts a socat sidecar in the target's network namespace
+// that listens on the remote port and connects back to the local host.
+func runRemoteForwarder(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client dockerclient.CommonAPIClient,
+	opts *options,
+	target types.ContainerJSON,
+	fwd forwarding,
+	ready func(),
+) error {
+	connectHost, err := remoteForwardingConnectHost(ctx, cli, client, target, fwd.localHost)
+	if err != nil {
+		return err
+	}
+
+	sidecarID, err := startRemoteForwarder(ctx, client, opts, target, fwd, connectHost)
+	defer cleanupContainerIfExist(client, sidecarID)
+	if err != nil {
+		return fmt.Errorf("starting remote forwarder failed: %w", err)
+	}
+
+	if opts.verbose {
+		go streamForwarderLogs(ctx, cli, client, sidecarID)
+	}
+	if opts.connectionLimit > 0 {
+		go watchConnectionLimit(ctx, cli, client, opts, sidecarID)
+	}
+
+	if connectHost == fwd.localHost {
+		cli.PrintOut(
+			"Forwarding %s:%s in the target to %s:%s\n",
+			fwd.remoteHost, fwd.remotePort,
+			fwd.localHost, fwd.localPort,
+		)
+	} else {
+		cli.PrintOut(
+			"Forwarding %s:%s in the target to %s:%s (through %s:%s)\n",
+			fwd.remoteHost, fwd.remotePort,
+			fwd.localHost, fwd.localPort,
+			connectHost, fwd.localPort,
+		)
+	}
+	ready()
+
+	statusCh, errCh := client.ContainerWait(
+		ctx,
+		sidecarID,
+		container.WaitConditionNotRunning,
+	)
+
+	select {
+	case <-ctx.Done():
+		return nil
+
+	case status := <-statusCh:
+		return fmt.Errorf(
+			"remote forwarder %s exited with code %d: %v",
+			sidecarID, status.StatusCode, status.Error,
+		)
+
+	case err := <-errCh:
+		logrus.Debugf("Remote forwarder error: %s", err)
+		return fmt.Errorf("remote forwarder %s hiccuped: %w", sidecarID, err)
+	}
+}
+
+func startRemoteForwarder(
+	ctx context.Context,
+	client dockerclient.CommonAPIClient,
+	opts *options,
+	target types.ContainerJSON,
+	fwd forwarding,
+	connectHost string,
+) (string, error) {
+	netMode := container.NetworkMode("container:" + target.ID)
+	if target.HostConfig.NetworkMode.IsHost() {
+		netMode = "host"
+	}
+
+	resp, err := client.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image:      forwarderImage,
+			Entrypoint: []string{"socat"},
+			Cmd: append(
+				socatLogFlags(opts.verbose),
+				socatListenAddress(fwd.remotePort, opts.connectionLimit)+",reuseaddr,bind="+fwd.remoteHost,
+				fmt.Sprintf("TCP-CONNECT:%s:%s", connectHost, fwd.localPort),
+			),
+		},
+		&container.HostConfig{
+			NetworkMode: netMode,
+		},
+		nil,
+		nil,
+		"cdebug-rfwd-"+uuid.ShortID(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("cannot create remote forwarder container: %w", err)
+	}
+
+	if err := client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return resp.ID, fmt.Errorf("cannot start remote forwarder container: %w", err)
+	}
+
+	return resp.ID, nil
+}
+
+// remoteForwardingConnectHost returns the address the local host is reachable
+// at from the target's network namespace. The cdebug's loopback is not the
+// target's one (unless the target uses the host network), so it's replaced
+// with host.docker.internal for Docker Desktop or with the target's network
+// gateway (i.e., the host's bridge address) otherwise.
+func remoteForwardingConnectHost(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client dockerclient.CommonAPIClient,
+	target types.ContainerJSON,
+	localHost string,
+) (string, error) {
+	if !isLoopbackHost(localHost) || target.HostConfig.NetworkMode.IsHost() {
+		return localHost, nil
+	}
+
+	if info, err := client.Info(ctx); err != nil {
+		logrus.Debugf("Cannot get Docker info: %s", err)
+	} else if strings.Contains(info.OperatingSystem, "Docker Desktop") {
+		return "host.docker.internal", nil
+	}
+
+	for _, settings := range target.NetworkSettings.Networks {
+		if len(settings.Gateway) > 0 {
+			cli.PrintErr(
+				"Warning: the target reaches %s via its network gateway %s - the local service must listen on it (or on 0.0.0.0)\n",
+				localHost, settings.Gateway,
+			)
+			return settings.Gateway, nil
+		}
+	}
+
+	return "", errors.New("cannot reach the local host from the target: it has no network gateway")
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
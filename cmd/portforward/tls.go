@@ -0,0 +1,132 @@
+package portforward
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/docker/docker/api/types/container"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+)
+
+func validateTLSForwarding(fwd forwarding) error {
+	if len(fwd.remoteSocket) > 0 {
+		return errors.New("--tls-cert flag is not supported for unix socket forwarding")
+	}
+	return nil
+}
+
+// runLocalTLSForwarder terminates TLS on the local side and proxies the
+// plain HTTP requests to the target. The usual socat forwarder is still
+// used to reach the target's network (container IPs aren't routable from
+// the host with Docker Desktop), but it's published on a random loopback
+// port that only the in-process reverse proxy talks to.
+func runLocalTLSForwarder(
+	ctx context.Context,
+	cli cliutil.CLI,
+	client dockerclient.CommonAPIClient,
+	opts *options,
+	fwd directForwarding,
+	ready func(),
+) error {
+	upstream := fwd
+	upstream.localHost = "127.0.0.1"
+	upstream.localPort = ""
+
+	forwarderID, err := startLocalDirectForwarder(ctx, client, upstream, opts)
+	defer cleanupContainerIfExist(client, forwarderID)
+	if err != nil {
+		return fmt.Errorf("starting forwarder failed: %w", err)
+	}
+
+	if opts.verbose {
+		go streamForwarderLogs(ctx, cli, client, forwarderID)
+	}
+	if opts.connectionLimit > 0 {
+		go watchConnectionLimit(ctx, cli, client, opts, forwarderID)
+	}
+
+	forwarder, err := client.ContainerInspect(ctx, forwarderID)
+	if err != nil {
+		return fmt.Errorf("cannot inspect forwarder container: %w", err)
+	}
+	bindings := lookupPortBindings(forwarder, fwd.remotePort)
+	if len(bindings) == 0 {
+		return fmt.Errorf("forwarder %s has no published port", forwarderID)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort("127.0.0.1", bindings[0].HostPort),
+	})
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		if opts.verbose {
+			cli.PrintAux("Proxying %s %s from %s\n", req.Method, req.URL.Path, req.RemoteAddr)
+		}
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(fwd.localHost, fwd.localPort))
+	if err != nil {
+		return fmt.Errorf("cannot listen on local port: %w", err)
+	}
+	defer ln.Close()
+
+	_, fwd.localPort, _ = net.SplitHostPort(ln.Addr().String())
+	cli.PrintOut(
+		"Forwarding https://%s:%s to http://%s:%s\n",
+		fwd.localHost, fwd.localPort,
+		fwd.remoteHost, fwd.remotePort,
+	)
+
+	server := &http.Server{
+		Handler: proxy,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{*opts.tlsCertificate},
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- server.ServeTLS(ln, "", "")
+	}()
+	ready()
+
+	fwderStatusCh, fwderErrCh := client.ContainerWait(
+		ctx,
+		forwarderID,
+		container.WaitConditionNotRunning,
+	)
+
+	select {
+	case <-ctx.Done():
+		return nil
+
+	case status := <-fwderStatusCh:
+		return fmt.Errorf(
+			"forwarder %s exited with code %d: %v",
+			forwarderID, status.StatusCode, status.Error,
+		)
+
+	case err := <-fwderErrCh:
+		logrus.Debugf("Forwarder error: %s", err)
+		return fmt.Errorf("forwarder %s hiccuped: %w", forwarderID, err)
+
+	case err := <-serveErrCh:
+		return fmt.Errorf("local TLS listener failed: %w", err)
+	}
+}
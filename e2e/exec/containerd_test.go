@@ -1,6 +1,8 @@
 package exec
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -84,3 +86,62 @@ func TestExecContainerdNixery(t *testing.T) {
 	res.Assert(t, icmd.Success)
 	assert.Check(t, cmp.Contains(res.Stdout(), "VIM - Vi IMproved"))
 }
+
+func TestExecContainerdVolumesFrom(t *testing.T) {
+	dataDir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dataDir, "hello.txt"), []byte("hello from volume"), 0o644))
+
+	targetID, cleanup := fixture.ContainerdRunBackground(t, fixture.ImageNginx,
+		[]string{"--mount", "type=bind,src=" + dataDir + ",dst=/data,options=rbind:ro"},
+	)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "-n", fixture.ContainerdCtrNamespace, "--rm", "-q",
+			"-u", "1000",
+			"--volumes-from", targetID,
+			"containerd://"+targetID,
+			"cat", "/data/hello.txt",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "hello from volume"))
+}
+
+func TestExecContainerdDistrolessChroot(t *testing.T) {
+	targetID, cleanup := fixture.ContainerdRunBackground(t, fixture.ImageDistrolessNodejs, nil,
+		"/nodejs/bin/node", "-e", "setInterval(() => console.log('hello'), 5000);",
+	)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "-n", fixture.ContainerdCtrNamespace, "--rm", "-q",
+			"containerd://"+targetID,
+			"ls", "/nodejs/bin",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "node"))
+
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "-n", fixture.ContainerdCtrNamespace, "--rm", "-q",
+			"containerd://"+targetID,
+			"ls", "$CDEBUG_ROOTFS/bin",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "busybox"))
+
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "-n", fixture.ContainerdCtrNamespace, "--rm", "-q",
+			"containerd://"+targetID,
+			"/nodejs/bin/node", "--version",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "v20."))
+}
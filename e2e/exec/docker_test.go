@@ -3,6 +3,7 @@ package exec
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"gotest.tools/assert"
 	"gotest.tools/assert/cmp"
@@ -119,3 +120,433 @@ func TestExecCdebugRootfsEnvVar(t *testing.T) {
 	res.Assert(t, icmd.Success)
 	assert.Check(t, cmp.Contains(res.Stdout(), "/.cdebug-"))
 }
+
+func TestExecDockerCopyBinary(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageDistrolessNodejs, nil,
+		"-e", "setInterval(() => console.log('hello'), 5000);",
+	)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q",
+			"--copy-binary", "/bin/ls:/.cdebug-bin/host-ls",
+			targetID,
+			"host-ls", "--version",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "GNU coreutils"))
+
+	// Executed directly (not by a shell), so the wrapper needs a working shebang.
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q",
+			"--copy-binary", "/bin/ls:/.cdebug-bin/host-ls",
+			targetID,
+			"env", "host-ls", "--version",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "GNU coreutils"))
+}
+
+func TestExecDockerPtrace(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--ptrace",
+			"--image", "nixery.dev/shell/strace",
+			targetID,
+			"timeout", "2", "strace", "-p", "1",
+		),
+	)
+	assert.Check(t, cmp.Contains(res.Stderr(), "attached"))
+}
+
+func TestExecDockerCustomDNS(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--dns", "8.8.8.8", "--user", "65534",
+			targetID,
+			"nslookup", "example.com",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "8.8.8.8"))
+
+	// The chrooted shell would still use the target's resolv.conf.
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--dns", "8.8.8.8",
+			targetID,
+			"nslookup", "example.com",
+		),
+	)
+	res.Assert(t, icmd.Expected{ExitCode: 1, Err: "not supported in the chroot mode"})
+}
+
+func TestExecDockerChrootBinary(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--chroot-binary", "/bin/busybox",
+			targetID,
+			"/bin/busybox", "echo", "hello from busybox",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "hello from busybox"))
+
+	// Missing files are skipped instead of leaving dangling symlinks.
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--chroot-binary", "/cdebug/no-such-file",
+			targetID,
+			"ls", "/cdebug",
+		),
+	)
+	assert.Check(t, cmp.Contains(res.Stderr(), "not found in the debugger image, skipping"))
+	assert.Check(t, res.ExitCode != 0)
+}
+
+func TestExecDockerInit(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--init",
+			targetID,
+			"cat", "/proc/1/cmdline",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, !strings.Contains(res.Stdout(), "nginx"))
+}
+
+func TestExecDockerAddHost(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--add-host", "cdebug.test:127.0.0.42", "--user", "65534",
+			targetID,
+			"wget", "-q", "-O-", "http://cdebug.test/",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "Welcome to nginx"))
+
+	// The chrooted shell would still use the target's /etc/hosts.
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--add-host", "cdebug.test:127.0.0.42",
+			targetID,
+			"wget", "-q", "-O-", "http://cdebug.test/",
+		),
+	)
+	res.Assert(t, icmd.Expected{ExitCode: 1, Err: "not supported in the chroot mode"})
+}
+
+func TestExecDockerOverrideEnv(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--override-env", "NGINX_VERSION=cdebug",
+			targetID,
+			"env",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "NGINX_VERSION=cdebug"))
+	assert.Check(t, cmp.Contains(res.Stdout(), "NJS_VERSION="))
+}
+
+func TestExecDockerShareNetFalse(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	// The target's nginx listens on :80 - unreachable from a separate network namespace.
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--share-net=false",
+			targetID,
+			"sh", "-c", "wget -q -T 2 -O- http://127.0.0.1:80 || echo unreachable",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "unreachable"))
+}
+
+func TestExecDockerSnapshot(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--snapshot",
+			targetID,
+			"sh", "-c", "touch /cdebug-snapshot-test && ls /",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "cdebug-snapshot-test"))
+
+	// The write must not reach the live target.
+	res = icmd.RunCmd(icmd.Command("docker", "exec", targetID, "ls", "/cdebug-snapshot-test"))
+	assert.Check(t, res.ExitCode != 0)
+}
+
+func TestExecDockerWaitFor(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--wait-for", "80",
+			targetID,
+			"echo", "ready",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "ready"))
+
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--wait-for", "8081", "--wait-for-timeout", "1s",
+			targetID,
+			"echo", "ready",
+		),
+	)
+	res.Assert(t, icmd.Expected{ExitCode: 1, Err: "didn't open within 1s"})
+}
+
+func TestExecDockerOnExit(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--name", "cdebug-on-exit-test",
+			"--on-exit", "echo exit-code=$CDEBUG_EXIT_CODE",
+			"--on-exit", "echo debugger=$CDEBUG_DEBUGGER_NAME target=$CDEBUG_TARGET_ID",
+			targetID[:12], // A partial ID is resolved to the full one.
+			"sh", "-c", "exit 3",
+		),
+	)
+	assert.Check(t, cmp.Contains(res.Stdout(), "exit-code=3"))
+	assert.Check(t, cmp.Contains(res.Stdout(), "debugger=cdebug-on-exit-test target="+targetID))
+}
+
+func TestExecDockerDistrolessChroot(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageDistrolessNodejs, nil,
+		"-e", "setInterval(() => console.log('hello'), 5000);",
+	)
+	defer cleanup()
+
+	// The target's filesystem is the debugger's root...
+	res := icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "--rm", "-q", targetID, "ls", "/nodejs/bin"),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "node"))
+
+	// ...while the debugger's own tools stay reachable via $CDEBUG_ROOTFS.
+	res = icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "--rm", "-q", targetID, "ls", "$CDEBUG_ROOTFS/bin"),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "busybox"))
+
+	res = icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "--rm", "-q", targetID, "/nodejs/bin/node", "--version"),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "v20."))
+}
+
+func TestExecDockerExecTimeoutDistroless(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageDistrolessNodejs, nil,
+		"-e", "setInterval(() => console.log('hello'), 5000);",
+	)
+	defer cleanup()
+
+	// Neither timeout nor perl in the target - the watchdog must not need them.
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--exec-timeout", "2s",
+			targetID,
+			"sleep", "60",
+		),
+		icmd.WithTimeout(30*time.Second),
+	)
+	assert.Check(t, !res.Timeout)
+	assert.Check(t, res.ExitCode != 0)
+}
+
+func TestExecDockerEntrypoint(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	// The bash image's ENTRYPOINT turns "-c <script>" into "bash -c <script>".
+	for _, entrypoint := range []string{"bash", ""} {
+		res := icmd.RunCmd(
+			icmd.Command(
+				"cdebug", "exec", "--rm", "-q",
+				"--image", "bash", "--entrypoint", entrypoint,
+				targetID,
+				"cat", "/etc/os-release",
+			),
+		)
+		res.Assert(t, icmd.Success)
+		assert.Check(t, cmp.Contains(res.Stdout(), "debian"))
+	}
+}
+
+func TestExecDockerExecUser(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	// The coreutils' chroot (unlike the busybox one) supports --userspec.
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q",
+			"--image", "nixery.dev/shell",
+			"--exec-user", "1000:1000",
+			targetID,
+			"id", "-u",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "1000"))
+}
+
+func TestExecDockerHostRootfs(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q",
+			"--privileged", "--host-rootfs",
+			targetID,
+			"sh", "-c", "ls /host/etc/hostname $CDEBUG_ROOTFS/etc/nginx/nginx.conf",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "/host/etc/hostname"))
+	assert.Check(t, cmp.Contains(res.Stdout(), "/etc/nginx/nginx.conf"))
+	assert.Check(t, cmp.Contains(res.Stderr(), "Warning: --host-rootfs"))
+
+	res = icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--host-rootfs", targetID,
+		),
+	)
+	res.Assert(t, icmd.Expected{ExitCode: 1, Err: "requires the --privileged flag"})
+}
+
+func TestExecDockerShareMounts(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx,
+		[]string{"--tmpfs", "/cdebug-tmpfs", "-v", "/cdebug-data"},
+	)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--share-mounts",
+			targetID,
+			"cat", "/proc/self/mounts",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), " /cdebug-data "))
+}
+
+func TestExecDockerDetach(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "-d", "-q", "--name", "cdebug-e2e-detach",
+			targetID,
+			"sleep", "30",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	defer icmd.RunCmd(icmd.Command("docker", "rm", "-f", "cdebug-e2e-detach"))
+	assert.Check(t, cmp.Equal(strings.TrimSpace(res.Stdout()), "cdebug-e2e-detach"))
+
+	state := icmd.RunCmd(icmd.Command("docker", "inspect", "-f", "{{.State.Running}}", "cdebug-e2e-detach"))
+	state.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Equal(strings.TrimSpace(state.Stdout()), "true"))
+}
+
+func TestExecDockerVolumeDriver(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q",
+			"--volume", "cdebug-e2e-volume:/cdebug-data",
+			"--volume-driver", "local",
+			"--volume-opt", "type=tmpfs",
+			"--volume-opt", "device=tmpfs",
+			targetID,
+			"grep", "/cdebug-data", "/proc/self/mounts",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "tmpfs"))
+
+	// The volume created by cdebug is removed together with the --rm debugger.
+	res = icmd.RunCmd(icmd.Command("docker", "volume", "inspect", "cdebug-e2e-volume"))
+	res.Assert(t, icmd.Expected{ExitCode: 1})
+}
+
+func TestExecDockerInteractiveNoTTYEcho(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "--rm", "-q", "-i", "--interactive-no-tty-echo", targetID),
+		icmd.WithStdin(strings.NewReader("echo \"hello $((6*7)) world\"\nexit 0\n")),
+	)
+
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), `echo "hello $((6*7)) world"`))
+	assert.Check(t, cmp.Contains(res.Stdout(), "hello 42 world"))
+}
+
+func TestExecDockerCmdShell(t *testing.T) {
+	targetID, cleanup := fixture.DockerRunBackground(t, fixture.ImageNginx, nil)
+	defer cleanup()
+
+	res := icmd.RunCmd(
+		icmd.Command(
+			"cdebug", "exec", "--rm", "-q", "--cmd-shell", "ash",
+			targetID,
+			"cat", "/etc/os-release",
+		),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "debian"))
+
+	res = icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "--rm", "-q", "--cmd-shell", "/bin/sh", targetID),
+	)
+	res.Assert(t, icmd.Expected{ExitCode: 1, Err: "invalid --cmd-shell value"})
+}
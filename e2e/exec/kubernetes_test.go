@@ -27,6 +27,21 @@ spec:
       imagePullPolicy: IfNotPresent
       name: app
 `))
+
+	distrolessNodejsPod = template.Must(template.New("distroless-nodejs-pod").Parse(`---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: {{.PodName}}
+  namespace: default
+spec:
+  restartPolicy: Never
+  containers:
+    - image: {{.Image}}
+      imagePullPolicy: IfNotPresent
+      name: app
+      args: ["-e", "setInterval(() => console.log('hello'), 5000);"]
+`))
 )
 
 func TestExecKubernetesSimple(t *testing.T) {
@@ -72,3 +87,32 @@ func TestExecKubernetesShell(t *testing.T) {
 	assert.Equal(t, res.Stderr(), "")
 	assert.Check(t, cmp.Contains(res.Stdout(), "hello 42 world"))
 }
+
+func TestExecKubernetesDistrolessChroot(t *testing.T) {
+	podName := "cdebug-" + strings.ToLower(t.Name()) + "-" + uuid.ShortID()
+	cleanup := fixture.KubectlApply(t, distrolessNodejsPod, map[string]string{
+		"PodName": podName,
+		"Image":   fixture.ImageDistrolessNodejs,
+	})
+	defer cleanup()
+
+	fixture.KubectlWaitFor(t, "pod", podName, "Ready")
+
+	res := icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "-q", "pod/"+podName+"/app", "ls", "/nodejs/bin"),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "node"))
+
+	res = icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "-q", "pod/"+podName+"/app", "ls", "$CDEBUG_ROOTFS/bin"),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "busybox"))
+
+	res = icmd.RunCmd(
+		icmd.Command("cdebug", "exec", "-q", "pod/"+podName+"/app", "/nodejs/bin/node", "--version"),
+	)
+	res.Assert(t, icmd.Success)
+	assert.Check(t, cmp.Contains(res.Stdout(), "v20."))
+}
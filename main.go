@@ -6,15 +6,20 @@ import (
 	"fmt"
 	mathrand "math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/moby/term"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	configcmd "github.com/iximiuz/cdebug/cmd/config"
 	"github.com/iximiuz/cdebug/cmd/exec"
+	"github.com/iximiuz/cdebug/cmd/inspect"
 	"github.com/iximiuz/cdebug/cmd/portforward"
 	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/config"
 )
 
 var (
@@ -36,15 +41,25 @@ func main() {
 	stdin, stdout, stderr := term.StdStreams()
 	cli := cliutil.NewCLI(stdin, stdout, stderr)
 
-	var logLevel string
+	var (
+		logLevel     string
+		outputFormat string
+	)
 	logrus.SetOutput(cli.ErrorStream())
 
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		cli.PrintErr("cdebug: %s\n", err)
+		os.Exit(1)
+	}
+
 	cmd := &cobra.Command{
 		Use:     "cdebug [OPTIONS] COMMAND [ARG...]",
 		Short:   "cdebug - a swiss army knife of container debugging",
 		Version: fmt.Sprintf("%s (built: %s commit: %s)", version, date, commit),
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			setLogLevel(cli, logLevel)
+			setOutputFormat(cli, outputFormat)
 			cmd.SilenceUsage = true
 			cmd.SilenceErrors = true
 		},
@@ -55,8 +70,11 @@ func main() {
 	cmd.AddCommand(
 		exec.NewCommand(cli),
 		portforward.NewCommand(cli),
+		inspect.NewCommand(cli),
+		configcmd.NewCommand(cli, cfg),
 		// TODO: other commands
 	)
+	cmd.InitDefaultCompletionCmd()
 
 	flags := cmd.PersistentFlags()
 	flags.SetInterspersed(false) // Instead of relying on --
@@ -68,15 +86,60 @@ func main() {
 		"info",
 		`log level for cdebug ("debug" | "info" | "warn" | "error" | "fatal")`,
 	)
+	flags.StringVar(
+		&outputFormat,
+		"output",
+		"text",
+		`output format for errors and command metadata ("text" | "json")`,
+	)
+
+	if err := config.Apply(cmd, cfg); err != nil {
+		cli.PrintErr("cdebug: %s\n", err)
+		os.Exit(1)
+	}
+
+	if isKubectlPlugin() {
+		// kubectl cdebug [exec] pod/mypod ... - no need for the schema prefix.
+		os.Setenv("CDEBUG_DEFAULT_SCHEMA", "kubernetes://")
+		cmd.Annotations = map[string]string{
+			cobra.CommandDisplayNameAnnotation: "kubectl cdebug",
+		}
+
+		args := os.Args[1:]
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			if c, _, err := cmd.Find(args); err != nil || c == cmd {
+				args = append([]string{"exec"}, args...)
+			}
+		}
+		cmd.SetArgs(args)
+	}
 
 	if err := cmd.Execute(); err != nil {
 		if sterr, ok := err.(cliutil.StatusError); ok {
-			cli.PrintErr("cdebug: %s\n", sterr)
+			if outputFormat == "json" {
+				cli.PrintOut("%s\n", cliutil.WrapStatusErrorJSON(sterr))
+			} else {
+				cli.PrintErr("cdebug: %s\n", sterr)
+			}
 			os.Exit(sterr.Code())
 		}
 
 		// Hopefully, only usage errors.
-		logrus.Debug("Exit error: %s", err)
+		logrus.Debugf("Exit error: %s", err)
+		os.Exit(1)
+	}
+}
+
+// isKubectlPlugin reports whether cdebug has been invoked by kubectl
+// (via the kubectl-cdebug symlink somewhere in the $PATH).
+func isKubectlPlugin() bool {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	return strings.HasSuffix(name, "kubectl-cdebug")
+}
+
+func setOutputFormat(cli cliutil.CLI, outputFormat string) {
+	if outputFormat != "text" && outputFormat != "json" {
+		cli.PrintErr("Unknown output format: %s\n", outputFormat)
 		os.Exit(1)
 	}
 }
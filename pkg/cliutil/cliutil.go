@@ -1,6 +1,7 @@
 package cliutil
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
@@ -113,3 +114,45 @@ func (e StatusError) Error() string {
 func (e StatusError) Code() int {
 	return e.code
 }
+
+func (e StatusError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}{
+		Error: e.status,
+		Code:  e.code,
+	})
+}
+
+// WrapStatusErrorJSON is like WrapStatusError, but the resulting error
+// message is the JSON representation of the status error (for the
+// machine-readable --output json mode). Codes of StatusError values
+// are preserved.
+func WrapStatusErrorJSON(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	sterr, ok := err.(StatusError)
+	if !ok {
+		sterr = NewStatusError(1, err.Error())
+	}
+	return jsonStatusError{sterr}
+}
+
+type jsonStatusError struct {
+	StatusError
+}
+
+func (e jsonStatusError) Error() string {
+	data, err := json.Marshal(e.StatusError)
+	if err != nil {
+		return e.StatusError.Error()
+	}
+	return string(data)
+}
+
+func (e jsonStatusError) Unwrap() error {
+	return e.StatusError
+}
@@ -0,0 +1,12 @@
+package cliutil
+
+import "os"
+
+// EnvOr returns the value of the environment variable key, or fallback if
+// the variable is unset or empty. Handy for env-overridable flag defaults.
+func EnvOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
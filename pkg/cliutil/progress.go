@@ -0,0 +1,74 @@
+package cliutil
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-units"
+)
+
+const progressBarWidth = 30
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// Progress is a single-line progress bar that is redrawn in place
+// using ANSI escape codes. Meant to be used only with terminals.
+type Progress struct {
+	out io.Writer
+
+	mu    sync.Mutex
+	msg   string
+	frame int
+}
+
+func NewProgress(out io.Writer) *Progress {
+	return &Progress{out: out}
+}
+
+func (p *Progress) Start(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.msg = msg
+	p.frame = 0
+	p.draw(p.msg)
+}
+
+func (p *Progress) Update(current, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.frame = (p.frame + 1) % len(spinnerFrames)
+
+	if total <= 0 {
+		p.draw(fmt.Sprintf("%s %s %s", spinnerFrames[p.frame], p.msg, units.HumanSize(float64(current))))
+		return
+	}
+
+	current = min(current, total)
+	filled := int(float64(progressBarWidth) * float64(current) / float64(total))
+	p.draw(fmt.Sprintf(
+		"%s %s [%s%s] %s/%s",
+		spinnerFrames[p.frame],
+		p.msg,
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled),
+		units.HumanSize(float64(current)),
+		units.HumanSize(float64(total)),
+	))
+}
+
+func (p *Progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.draw(p.msg + " done")
+	fmt.Fprint(p.out, "\n")
+}
+
+func (p *Progress) draw(line string) {
+	// Return the carriage and erase the current line.
+	fmt.Fprint(p.out, "\r\033[2K"+line)
+}
@@ -0,0 +1,35 @@
+package completion
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/iximiuz/cdebug/pkg/docker"
+)
+
+// Completions are computed on every <TAB> press, so an unreachable
+// runtime must not make the shell hang.
+const Timeout = 2 * time.Second
+
+// DockerContainerNames completes the names of the running containers
+// of the Docker daemon at host (the default one if empty).
+func DockerContainerNames(ctx context.Context, host string) ([]string, cobra.ShellCompDirective) {
+	client, err := docker.NewClient(docker.Options{Host: host})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer client.Close()
+
+	containers, err := client.ListRunningContainers(ctx)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var comps []string
+	for _, c := range containers {
+		comps = append(comps, c.Name)
+	}
+	return comps, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// Config holds the user-level defaults for the cdebug flags. The keys
+// match the flag names, e.g.:
+//
+//	image: nixery.dev/shell/vim/ps
+//	namespace: kube-system
+//	log-level: debug
+type Config struct {
+	Image             string `json:"image,omitempty"`
+	Runtime           string `json:"runtime,omitempty"`
+	Namespace         string `json:"namespace,omitempty"`
+	Platform          string `json:"platform,omitempty"`
+	Kubeconfig        string `json:"kubeconfig,omitempty"`
+	KubeconfigContext string `json:"kubeconfig-context,omitempty"`
+	LogLevel          string `json:"log-level,omitempty"`
+}
+
+type Field struct {
+	Key   string
+	Value string
+
+	// Envs are the environment variables that take precedence
+	// over the config file value (if any), in the order of priority.
+	Envs []string
+}
+
+// EnvValue returns the first set environment variable of the field
+// and its value, or empty strings if none is set.
+func (f Field) EnvValue() (string, string) {
+	for _, env := range f.Envs {
+		if v := os.Getenv(env); v != "" {
+			return env, v
+		}
+	}
+	return "", ""
+}
+
+func (c *Config) Fields() []Field {
+	return []Field{
+		{Key: "image", Value: c.Image, Envs: []string{"CDEBUG_DEFAULT_IMAGE"}},
+		{Key: "runtime", Value: c.Runtime, Envs: []string{"CDEBUG_RUNTIME"}},
+		{Key: "namespace", Value: c.Namespace, Envs: []string{"CDEBUG_NAMESPACE"}},
+		{Key: "platform", Value: c.Platform, Envs: []string{"CDEBUG_PLATFORM"}},
+		{Key: "kubeconfig", Value: c.Kubeconfig, Envs: []string{"KUBECONFIG"}},
+		{Key: "kubeconfig-context", Value: c.KubeconfigContext, Envs: []string{"KUBECONTEXT", "KUBECTL_CONTEXT"}},
+		{Key: "log-level", Value: c.LogLevel},
+	}
+}
+
+// Path returns $CDEBUG_CONFIG if set, or ~/.cdebug/config.yaml otherwise.
+func Path() string {
+	if path := os.Getenv("CDEBUG_CONFIG"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".cdebug", "config.yaml")
+}
+
+// Load reads the config file. A missing file is not an error
+// unless the path was set explicitly via $CDEBUG_CONFIG.
+func Load(path string) (*Config, error) {
+	var cfg Config
+	if path == "" {
+		return &cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && os.Getenv("CDEBUG_CONFIG") == "" {
+			return &cfg, nil
+		}
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Apply turns the config values into the default values of the matching
+// flags of the command and all its subcommands. It must be called before
+// the flags are parsed, so that the precedence is:
+//
+//	CLI flag > environment variable > config file > built-in default
+func Apply(cmd *cobra.Command, cfg *Config) error {
+	for _, field := range cfg.Fields() {
+		if env, _ := field.EnvValue(); field.Value == "" || env != "" {
+			continue
+		}
+
+		if err := walkFlags(cmd, field.Key, func(flag *pflag.Flag) error {
+			if err := flag.Value.Set(field.Value); err != nil {
+				return fmt.Errorf("invalid %s value in config file: %w", field.Key, err)
+			}
+			flag.DefValue = flag.Value.String()
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Lookup finds the first flag with the given name in the command tree.
+func Lookup(cmd *cobra.Command, name string) *pflag.Flag {
+	var found *pflag.Flag
+	walkFlags(cmd, name, func(flag *pflag.Flag) error {
+		if found == nil {
+			found = flag
+		}
+		return nil
+	})
+	return found
+}
+
+func walkFlags(cmd *cobra.Command, name string, fn func(*pflag.Flag) error) error {
+	flag := cmd.PersistentFlags().Lookup(name)
+	if flag == nil {
+		flag = cmd.Flags().Lookup(name)
+	}
+	if flag != nil {
+		if err := fn(flag); err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range cmd.Commands() {
+		if err := walkFlags(sub, name, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
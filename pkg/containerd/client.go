@@ -2,15 +2,32 @@ package containerd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
 	"github.com/containerd/containerd/cmd/ctr/commands/content"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/archive"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/plugin"
+	dockerref "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/continuity/fs"
 	"github.com/docker/cli/cli/streams"
+	dockerarchive "github.com/docker/docker/pkg/archive"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+
+	"github.com/iximiuz/cdebug/pkg/socketutil"
 )
 
 const (
@@ -24,14 +41,19 @@ var wellKnownAddresses = []string{
 
 type Client struct {
 	*containerd.Client
-	out       *streams.Out
-	namespace string
+	out         *streams.Out
+	namespace   string
+	snapshotter string
 }
 
 type Options struct {
 	Out       *streams.Out
 	Address   string
 	Namespace string
+
+	// Snapshotter to unpack images and create container snapshots with.
+	// If empty, the daemon's default snapshotter is used.
+	Snapshotter string
 }
 
 func NewClient(opts Options) (*Client, error) {
@@ -50,15 +72,23 @@ func NewClient(opts Options) (*Client, error) {
 		return nil, err
 	}
 
+	if len(opts.Snapshotter) > 0 {
+		if err := checkSnapshotter(inner, opts.Snapshotter); err != nil {
+			inner.Close()
+			return nil, err
+		}
+	}
+
 	out := opts.Out
 	if out == nil {
 		out = streams.NewOut(io.Discard)
 	}
 
 	return &Client{
-		Client:    inner,
-		out:       out,
-		namespace: namespace,
+		Client:      inner,
+		out:         out,
+		namespace:   namespace,
+		snapshotter: opts.Snapshotter,
 	}, nil
 }
 
@@ -66,6 +96,36 @@ func (c *Client) Namespace() string {
 	return c.namespace
 }
 
+// SetNamespace switches the client to a different namespace
+// (e.g., after the target container has been found in it).
+func (c *Client) SetNamespace(namespace string) {
+	c.namespace = namespace
+}
+
+// Snapshotter returns the explicitly requested snapshotter name
+// or an empty string if the daemon's default one should be used.
+func (c *Client) Snapshotter() string {
+	return c.snapshotter
+}
+
+func checkSnapshotter(c *containerd.Client, name string) error {
+	resp, err := c.IntrospectionService().Plugins(
+		context.Background(),
+		[]string{fmt.Sprintf("type==%s,id==%s", plugin.SnapshotPlugin, name)},
+	)
+	if err != nil {
+		return fmt.Errorf("cannot list containerd snapshotters: %w", err)
+	}
+
+	for _, p := range resp.Plugins {
+		if p.InitErr != nil {
+			return fmt.Errorf("containerd snapshotter %q is not available: %s", name, p.InitErr.Message)
+		}
+		return nil
+	}
+	return fmt.Errorf("containerd snapshotter %q not found", name)
+}
+
 func (c *Client) ContainerRemoveEx(
 	ctx context.Context,
 	cont containerd.Container,
@@ -95,6 +155,15 @@ func (c *Client) ImagePullEx(
 		ref = ref + ":latest"
 	}
 
+	spec, err := platforms.Parse(platform)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platform %q: %w", platform, err)
+	}
+
+	if err := checkImagePlatform(ctx, ref, spec); err != nil {
+		return nil, err
+	}
+
 	pctx, stopProgress := context.WithCancel(ctx)
 	jobs := content.NewJobs(ref)
 	progressCh := make(chan struct{})
@@ -103,12 +172,15 @@ func (c *Client) ImagePullEx(
 		close(progressCh)
 	}()
 
-	image, err := c.Pull(
-		ctx,
-		ref,
+	pullOpts := []containerd.RemoteOpt{
 		containerd.WithPullUnpack,
-		containerd.WithPlatform(platform),
-	)
+		containerd.WithPlatformMatcher(platforms.Only(spec)),
+	}
+	if len(c.snapshotter) > 0 {
+		pullOpts = append(pullOpts, containerd.WithPullSnapshotter(c.snapshotter))
+	}
+
+	image, err := c.Pull(ctx, ref, pullOpts...)
 	stopProgress()
 	if err != nil {
 		return image, err
@@ -118,6 +190,130 @@ func (c *Client) ImagePullEx(
 	return image, nil
 }
 
+// checkImagePlatform makes sure the image's index has a manifest for the
+// platform - otherwise, the pull would fail with a rather cryptic error.
+// Single-manifest images and unreachable registries are not checked here
+// (the latter to keep the --image-cache fallback working).
+func checkImagePlatform(ctx context.Context, ref string, platform ocispec.Platform) error {
+	named, err := dockerref.ParseDockerRef(ref)
+	if err != nil {
+		return err
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(),
+	})
+
+	name, desc, err := resolver.Resolve(ctx, named.String())
+	if err != nil {
+		logrus.Debugf("Cannot resolve image %s to check its platforms: %s", ref, err)
+		return nil
+	}
+
+	if !images.IsIndexType(desc.MediaType) {
+		return nil
+	}
+
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		logrus.Debugf("Cannot fetch image %s index: %s", ref, err)
+		return nil
+	}
+
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		logrus.Debugf("Cannot fetch image %s index: %s", ref, err)
+		return nil
+	}
+	defer rc.Close()
+
+	var index ocispec.Index
+	if err := json.NewDecoder(rc).Decode(&index); err != nil {
+		logrus.Debugf("Cannot decode image %s index: %s", ref, err)
+		return nil
+	}
+
+	return matchIndexPlatform(ref, index, platform)
+}
+
+func matchIndexPlatform(ref string, index ocispec.Index, platform ocispec.Platform) error {
+	matcher := platforms.Only(platform)
+
+	var available []string
+	for _, m := range index.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		if matcher.Match(*m.Platform) {
+			return nil
+		}
+		if p := platforms.Format(*m.Platform); p != "unknown/unknown" {
+			available = append(available, p)
+		}
+	}
+
+	return fmt.Errorf("image %s doesn't support platform %s (available platforms: %s)",
+		ref, platforms.Format(platform), strings.Join(available, ", "))
+}
+
+// CopyFromContainer copies a file or directory from the container's rootfs
+// snapshot to the host (the semantics is the same as of `docker cp`).
+func (c *Client) CopyFromContainer(
+	ctx context.Context,
+	cont containerd.Container,
+	srcPath string,
+	dstPath string,
+) error {
+	info, err := cont.Info(ctx)
+	if err != nil {
+		return err
+	}
+
+	mounts, err := c.SnapshotService(info.Snapshotter).Mounts(ctx, info.SnapshotKey)
+	if err != nil {
+		return err
+	}
+
+	return mount.WithReadonlyTempMount(ctx, mounts, func(root string) error {
+		src, err := fs.RootPath(root, srcPath)
+		if err != nil {
+			return err
+		}
+		return dockerarchive.CopyResource(src, dstPath, false)
+	})
+}
+
+// CopyToContainer copies a file or directory from the host to the
+// container's rootfs snapshot (the semantics is the same as of `docker cp`).
+// The container must not be running yet.
+func (c *Client) CopyToContainer(
+	ctx context.Context,
+	cont containerd.Container,
+	srcPath string,
+	dstPath string,
+) error {
+	info, err := cont.Info(ctx)
+	if err != nil {
+		return err
+	}
+
+	mounts, err := c.SnapshotService(info.Snapshotter).Mounts(ctx, info.SnapshotKey)
+	if err != nil {
+		return err
+	}
+
+	return mount.WithTempMount(ctx, mounts, func(root string) error {
+		dst, err := fs.RootPath(root, dstPath)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		return dockerarchive.CopyResource(srcPath, dst, false)
+	})
+}
+
 func (c *Client) taskRemove(
 	ctx context.Context,
 	task containerd.Task,
@@ -156,16 +352,122 @@ func (c *Client) containerRemove(ctx context.Context, cont containerd.Container)
 }
 
 func detectAddress(opts Options) (string, error) {
-	addresses := wellKnownAddresses[:]
 	if len(opts.Address) > 0 {
-		addresses = []string{strings.TrimPrefix(opts.Address, "unix://")}
+		addr := strings.TrimPrefix(opts.Address, "unix://")
+		if err := socketutil.CheckAccessible(addr); err != nil {
+			return "", err
+		}
+		return addr, nil
 	}
 
-	for _, addr := range addresses {
-		if isSocketAccessible(addr) == nil {
+	for _, addr := range wellKnownAddresses {
+		if socketutil.CheckAccessible(addr) == nil {
 			return addr, nil
 		}
 	}
 
 	return "", errors.New("cannot detect (good enough) containerd address")
 }
+
+func (c *Client) ExportImage(ctx context.Context, ref string, w io.Writer) error {
+	return c.Export(
+		ctx,
+		w,
+		archive.WithImage(c.ImageService(), ref),
+		archive.WithPlatform(platforms.Default()),
+		archive.WithSkipMissing(c.ContentStore()),
+	)
+}
+
+func (c *Client) ImportImage(ctx context.Context, r io.Reader) error {
+	imgs, err := c.Import(ctx, r, containerd.WithImportPlatform(platforms.Default()))
+	if err != nil {
+		return err
+	}
+
+	for _, img := range imgs {
+		if err := containerd.NewImage(c.Client, img).Unpack(ctx, c.snapshotter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ContainerInfo struct {
+	ID     string
+	Labels map[string]string
+	Status containerd.ProcessStatus
+}
+
+// ListByLabel returns all (running and stopped) containers that have
+// the label (in the "key" or "key=value" form).
+func (c *Client) ListByLabel(ctx context.Context, label string) ([]ContainerInfo, error) {
+	containers, err := c.Containers(ctx, labelFilter(label))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []ContainerInfo
+	for _, cont := range containers {
+		labels, err := cont.Labels(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var status containerd.ProcessStatus
+		if task, err := cont.Task(ctx, nil); err == nil {
+			if st, err := task.Status(ctx); err == nil {
+				status = st.Status
+			}
+		}
+
+		found = append(found, ContainerInfo{
+			ID:     cont.ID(),
+			Labels: labels,
+			Status: status,
+		})
+	}
+
+	return found, nil
+}
+
+func labelFilter(label string) string {
+	if key, value, ok := strings.Cut(label, "="); ok {
+		return fmt.Sprintf("labels.%q==%q", key, value)
+	}
+	return fmt.Sprintf("labels.%q", label)
+}
+
+// ListRunningContainers returns the containers with a running task.
+func (c *Client) ListRunningContainers(ctx context.Context) ([]ContainerInfo, error) {
+	containers, err := c.Containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var running []ContainerInfo
+	for _, cont := range containers {
+		task, err := cont.Task(ctx, nil)
+		if err != nil {
+			continue // No task - not running.
+		}
+
+		status, err := task.Status(ctx)
+		if err != nil || status.Status != containerd.Running {
+			continue
+		}
+
+		labels, err := cont.Labels(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		running = append(running, ContainerInfo{
+			ID:     cont.ID(),
+			Labels: labels,
+			Status: status.Status,
+		})
+	}
+
+	return running, nil
+}
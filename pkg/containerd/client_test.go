@@ -0,0 +1,32 @@
+package containerd
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/assert"
+)
+
+func TestLabelFilter(t *testing.T) {
+	assert.Equal(t, labelFilter("cdebug"), `labels."cdebug"`)
+	assert.Equal(t, labelFilter("cdebug=true"), `labels."cdebug"=="true"`)
+	assert.Equal(t, labelFilter("nerdctl/name=my app"), `labels."nerdctl/name"=="my app"`)
+}
+
+func TestMatchIndexPlatform(t *testing.T) {
+	index := ocispec.Index{
+		Manifests: []ocispec.Descriptor{
+			{Platform: &ocispec.Platform{OS: "linux", Architecture: "amd64"}},
+			{Platform: &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+			{Platform: &ocispec.Platform{OS: "unknown", Architecture: "unknown"}}, // attestations
+		},
+	}
+
+	assert.NilError(t, matchIndexPlatform("busybox", index, ocispec.Platform{OS: "linux", Architecture: "amd64"}))
+	assert.NilError(t, matchIndexPlatform("busybox", index, platforms.Normalize(ocispec.Platform{OS: "linux", Architecture: "arm64"})))
+	assert.Error(t,
+		matchIndexPlatform("busybox", index, ocispec.Platform{OS: "linux", Architecture: "s390x"}),
+		"image busybox doesn't support platform linux/s390x (available platforms: linux/amd64, linux/arm64/v8)",
+	)
+}
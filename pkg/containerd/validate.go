@@ -0,0 +1,36 @@
+package containerd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ValidateMountOverlap detects mounts shadowing the earlier ones - the mounts
+// are applied in order, so a mount at the same path or at a parent directory
+// of an earlier mount hides it (e.g., a --volumes-from volume at /etc hides
+// the generated /etc/hosts).
+func ValidateMountOverlap(mounts []specs.Mount) error {
+	var overlaps []string
+	for i := range mounts {
+		for j := i + 1; j < len(mounts); j++ {
+			if isSameOrParentDir(mounts[j].Destination, mounts[i].Destination) {
+				overlaps = append(overlaps, fmt.Sprintf("%s (from %s) shadows %s (from %s)",
+					mounts[j].Destination, mounts[j].Source,
+					mounts[i].Destination, mounts[i].Source))
+			}
+		}
+	}
+
+	if len(overlaps) > 0 {
+		return fmt.Errorf("overlapping mounts: %s", strings.Join(overlaps, "; "))
+	}
+	return nil
+}
+
+func isSameOrParentDir(dir, p string) bool {
+	dir, p = path.Clean(dir), path.Clean(p)
+	return dir == p || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,65 @@
+package containerd
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"gotest.tools/assert"
+)
+
+func TestValidateMountOverlap(t *testing.T) {
+	tests := []struct {
+		name    string
+		mounts  []specs.Mount
+		wantErr string
+	}{
+		{
+			name: "disjoint",
+			mounts: []specs.Mount{
+				{Destination: "/data", Source: "/var/lib/data"},
+				{Destination: "/etc/hosts", Source: "/tmp/hosts"},
+			},
+		},
+		{
+			name: "nested mount after its parent",
+			mounts: []specs.Mount{
+				{Destination: "/data", Source: "/var/lib/data"},
+				{Destination: "/data/cache", Source: "/var/lib/cache"},
+			},
+		},
+		{
+			name: "same destination",
+			mounts: []specs.Mount{
+				{Destination: "/data", Source: "/var/lib/a"},
+				{Destination: "/data/", Source: "/var/lib/b"},
+			},
+			wantErr: "overlapping mounts: /data/ (from /var/lib/b) shadows /data (from /var/lib/a)",
+		},
+		{
+			name: "parent mount after the nested one",
+			mounts: []specs.Mount{
+				{Destination: "/etc/hosts", Source: "/tmp/hosts"},
+				{Destination: "/etc", Source: "/var/lib/etc"},
+			},
+			wantErr: "overlapping mounts: /etc (from /var/lib/etc) shadows /etc/hosts (from /tmp/hosts)",
+		},
+		{
+			name: "sibling with a common prefix",
+			mounts: []specs.Mount{
+				{Destination: "/data-old", Source: "/var/lib/a"},
+				{Destination: "/data", Source: "/var/lib/b"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMountOverlap(tt.mounts)
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.Error(t, err, tt.wantErr)
+			}
+		})
+	}
+}
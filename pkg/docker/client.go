@@ -2,15 +2,35 @@ package docker
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/docker/cli/cli/streams"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/sirupsen/logrus"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+	"github.com/iximiuz/cdebug/pkg/socketutil"
 )
 
+// MinRequiredAPIVersion is the oldest Docker Engine API version cdebug
+// can work with. Older daemons silently ignore some of the options
+// the debugger container relies on (e.g., NetworkMode: container:<id>).
+const MinRequiredAPIVersion = "1.25"
+
+// How long to wait for the daemon's version before skipping the check.
+const apiVersionCheckTimeout = 5 * time.Second
+
 type Client struct {
 	client.CommonAPIClient
 	out *streams.Out
@@ -37,6 +57,16 @@ func NewClient(opts Options) (*Client, error) {
 		return nil, fmt.Errorf("cannot initialize Docker client: %w", err)
 	}
 
+	if sockfile, ok := strings.CutPrefix(inner.DaemonHost(), "unix://"); ok {
+		if err := socketutil.CheckAccessible(sockfile); err != nil {
+			return nil, fmt.Errorf("cannot initialize Docker client: %w", err)
+		}
+	}
+
+	if err := checkAPIVersion(inner); err != nil {
+		return nil, err
+	}
+
 	out := opts.Out
 	if out == nil {
 		out = streams.NewOut(io.Discard)
@@ -48,6 +78,25 @@ func NewClient(opts Options) (*Client, error) {
 	}, nil
 }
 
+func checkAPIVersion(c client.CommonAPIClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiVersionCheckTimeout)
+	defer cancel()
+
+	sv, err := c.ServerVersion(ctx)
+	if err != nil {
+		// Not fatal here - an unreachable daemon will be reported
+		// by the first actual API call with a more specific error.
+		logrus.Debugf("Cannot check Docker API version: %s", err)
+		return nil
+	}
+
+	if versions.LessThan(sv.APIVersion, MinRequiredAPIVersion) {
+		return fmt.Errorf("Docker API version %s is below the minimum required %s",
+			sv.APIVersion, MinRequiredAPIVersion)
+	}
+	return nil
+}
+
 func (c *Client) ImagePullEx(
 	ctx context.Context,
 	image string,
@@ -59,5 +108,171 @@ func (c *Client) ImagePullEx(
 	}
 	defer resp.Close()
 
-	return jsonmessage.DisplayJSONMessagesToStream(resp, c.out, nil)
+	if !c.out.IsTerminal() {
+		return jsonmessage.DisplayJSONMessagesToStream(resp, c.out, nil)
+	}
+
+	return displayPullProgress(resp, cliutil.NewProgress(c.out), image)
+}
+
+// displayPullProgress squashes the per-layer pull progress messages
+// into a single (constantly updated in place) progress bar.
+func displayPullProgress(in io.Reader, progress *cliutil.Progress, image string) error {
+	type layer struct {
+		current int64
+		total   int64
+	}
+	layers := map[string]*layer{}
+
+	progress.Start("Pulling " + image)
+
+	dec := json.NewDecoder(in)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.ID == "" {
+			continue
+		}
+
+		l, ok := layers[msg.ID]
+		if !ok {
+			l = &layer{}
+			layers[msg.ID] = l
+		}
+
+		switch {
+		case msg.Status == "Downloading" && msg.Progress != nil:
+			l.current = msg.Progress.Current
+			l.total = msg.Progress.Total
+		case msg.Status == "Download complete" || msg.Status == "Pull complete":
+			l.current = l.total
+		}
+
+		var current, total int64
+		for _, l := range layers {
+			current += l.current
+			total += l.total
+		}
+		progress.Update(current, total)
+	}
+
+	progress.Done()
+	return nil
+}
+
+func (c *Client) ExportImage(ctx context.Context, image string, w io.Writer) error {
+	content, err := c.CommonAPIClient.ImageSave(ctx, []string{image})
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	_, err = io.Copy(w, content)
+	return err
+}
+
+func (c *Client) ImportImage(ctx context.Context, r io.Reader) error {
+	resp, err := c.CommonAPIClient.ImageLoad(ctx, r, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return jsonmessage.DisplayJSONMessagesToStream(resp.Body, streams.NewOut(io.Discard), nil)
+}
+
+type ContainerInfo struct {
+	ID     string
+	Name   string
+	Labels map[string]string
+	Status string
+}
+
+// ContainerListFiltered returns all (running and stopped) containers
+// whose name starts with the prefix and that have the label (in the
+// "key" or "key=value" form). Empty prefix or label matches everything.
+func (c *Client) ContainerListFiltered(
+	ctx context.Context,
+	prefix string,
+	label string,
+) ([]types.Container, error) {
+	return c.CommonAPIClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: listFilters(prefix, label),
+	})
+}
+
+func listFilters(prefix string, label string) filters.Args {
+	args := filters.NewArgs()
+	if len(prefix) > 0 {
+		// The name filter is a regexp matched against "/<name>".
+		args.Add("name", "^/"+regexp.QuoteMeta(prefix))
+	}
+	if len(label) > 0 {
+		args.Add("label", label)
+	}
+	return args
+}
+
+// ListRunningContainers returns the running containers (as in `docker ps`)
+// that have all the labels (in the "key" or "key=value" form).
+func (c *Client) ListRunningContainers(ctx context.Context, labels ...string) ([]ContainerInfo, error) {
+	args := filters.NewArgs()
+	for _, label := range labels {
+		args.Add("label", label)
+	}
+
+	containers, err := c.CommonAPIClient.ContainerList(ctx, container.ListOptions{
+		All:     false,
+		Filters: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var running []ContainerInfo
+	for _, cont := range containers {
+		var name string
+		if len(cont.Names) > 0 {
+			name = strings.TrimPrefix(cont.Names[0], "/")
+		}
+
+		running = append(running, ContainerInfo{
+			ID:     cont.ID,
+			Name:   name,
+			Labels: cont.Labels,
+			Status: cont.Status,
+		})
+	}
+
+	return running, nil
+}
+
+// IsRootless reports whether the Docker daemon runs in the rootless mode.
+// The container IPs of a rootless daemon live in its user-mode network
+// namespace (slirp4netns, pasta, etc.) and aren't reachable from the host.
+func (c *Client) IsRootless(ctx context.Context) (bool, error) {
+	info, err := c.CommonAPIClient.Info(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	opts, err := system.DecodeSecurityOptions(info.SecurityOptions)
+	if err != nil {
+		return false, err
+	}
+	for _, opt := range opts {
+		if opt.Name == "rootless" {
+			return true, nil
+		}
+	}
+	return false, nil
 }
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"gotest.tools/assert"
+)
+
+type fakeAPIClient struct {
+	client.CommonAPIClient
+
+	listOptions     container.ListOptions
+	securityOptions []string
+}
+
+func (c *fakeAPIClient) Info(_ context.Context) (system.Info, error) {
+	return system.Info{SecurityOptions: c.securityOptions}, nil
+}
+
+func (c *fakeAPIClient) ContainerList(_ context.Context, options container.ListOptions) ([]types.Container, error) {
+	c.listOptions = options
+	return []types.Container{{ID: "abc"}}, nil
+}
+
+func TestContainerListFiltered(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		label  string
+		names  []string
+		labels []string
+	}{
+		{name: "no filters", names: []string{}, labels: []string{}},
+		{name: "prefix", prefix: "cdebug-fwd-", names: []string{"^/cdebug-fwd-"}, labels: []string{}},
+		{name: "label", label: "cdebug=true", names: []string{}, labels: []string{"cdebug=true"}},
+		{
+			name:   "prefix and label",
+			prefix: "cdebug.",
+			label:  "cdebug",
+			names:  []string{`^/cdebug\.`},
+			labels: []string{"cdebug"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeAPIClient{}
+			c := &Client{CommonAPIClient: fake}
+
+			found, err := c.ContainerListFiltered(context.Background(), tt.prefix, tt.label)
+			assert.NilError(t, err)
+			assert.Equal(t, len(found), 1)
+
+			assert.Check(t, fake.listOptions.All)
+			assert.DeepEqual(t, fake.listOptions.Filters.Get("name"), tt.names)
+			assert.DeepEqual(t, fake.listOptions.Filters.Get("label"), tt.labels)
+		})
+	}
+}
+
+func TestListRunningContainersByLabels(t *testing.T) {
+	fake := &fakeAPIClient{}
+	c := &Client{CommonAPIClient: fake}
+
+	found, err := c.ListRunningContainers(context.Background(), "com.docker.compose.service=app", "cdebug")
+	assert.NilError(t, err)
+	assert.Equal(t, len(found), 1)
+
+	assert.Check(t, !fake.listOptions.All)
+
+	labels := fake.listOptions.Filters.Get("label")
+	sort.Strings(labels)
+	assert.DeepEqual(t, labels, []string{"cdebug", "com.docker.compose.service=app"})
+}
+
+func TestIsRootless(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []string
+		rootless bool
+	}{
+		{name: "rootful", opts: []string{"name=apparmor", "name=seccomp,profile=builtin"}},
+		{name: "rootless", opts: []string{"name=seccomp,profile=builtin", "name=rootless", "name=cgroupns"}, rootless: true},
+		{name: "no options"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{CommonAPIClient: &fakeAPIClient{securityOptions: tt.opts}}
+
+			rootless, err := c.IsRootless(context.Background())
+			assert.NilError(t, err)
+			assert.Equal(t, rootless, tt.rootless)
+		})
+	}
+}
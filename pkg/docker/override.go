@@ -0,0 +1,26 @@
+package docker
+
+import (
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/iximiuz/cdebug/pkg/kubernetes"
+)
+
+// ContainerSpec bundles the two parts of a container definition the Docker
+// API expects, so that a single override fragment could patch both of them:
+//
+//	{"config": {"Env": ["DEBUG=1"]}, "hostConfig": {"Memory": 268435456}}
+type ContainerSpec struct {
+	Config     *container.Config     `json:"config,omitempty"`
+	HostConfig *container.HostConfig `json:"hostConfig,omitempty"`
+}
+
+// Override applies the JSON fragment to the container spec using the same
+// patching methods as the Kubernetes backend does.
+func Override(
+	spec ContainerSpec,
+	fragment string,
+	overrideType kubernetes.OverrideType,
+) (ContainerSpec, error) {
+	return kubernetes.Override(spec, fragment, overrideType)
+}
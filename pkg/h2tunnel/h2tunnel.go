@@ -0,0 +1,112 @@
+// Package h2tunnel implements TCP tunneling over cleartext HTTP/2 (h2c)
+// CONNECT streams - for networks where raw TCP tunneling is blocked
+// but HTTP/2 traffic is allowed.
+package h2tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// NewHandler returns an h2c handler serving CONNECT requests by tunneling
+// them to the upstream address. The requested authority is ignored - the
+// server is meant to forward to exactly one destination.
+func NewHandler(upstream string) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		conn, err := net.Dial("tcp", upstream)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer conn.Close()
+
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		go func() {
+			io.Copy(conn, r.Body)
+			if c, ok := conn.(*net.TCPConn); ok {
+				c.CloseWrite()
+			}
+		}()
+
+		io.Copy(flushWriter{w}, conn)
+	}), &http2.Server{})
+}
+
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.w.(http.Flusher).Flush()
+	return n, err
+}
+
+// Client opens CONNECT streams to an h2c tunnel server. All the streams
+// are multiplexed over a single TCP connection.
+type Client struct {
+	addr      string
+	transport *http2.Transport
+}
+
+func NewClient(addr string) *Client {
+	return &Client{
+		addr: addr,
+		transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// Tunnel relays the conn's traffic through a new CONNECT stream until
+// either side closes the connection. The conn is not closed.
+func (c *Client) Tunnel(ctx context.Context, conn io.ReadWriter) error {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, "http://"+c.addr, pr)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("cannot open tunnel stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("tunnel server responded with %s: %s", resp.Status, msg)
+	}
+
+	go func() {
+		io.Copy(pw, conn)
+		pw.Close()
+	}()
+
+	_, err = io.Copy(conn, resp.Body)
+	return err
+}
+
+func (c *Client) Close() {
+	c.transport.CloseIdleConnections()
+}
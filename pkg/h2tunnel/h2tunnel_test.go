@@ -0,0 +1,57 @@
+package h2tunnel
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestTunnel(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer upstream.Close()
+
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) // echo
+			}()
+		}
+	}()
+
+	server := httptest.NewServer(NewHandler(upstream.Addr().String()))
+	defer server.Close()
+
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"))
+	defer client.Close()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.Tunnel(context.Background(), remote)
+	}()
+
+	_, err = local.Write([]byte("ping\n"))
+	assert.NilError(t, err)
+
+	line, err := bufio.NewReader(local).ReadString('\n')
+	assert.NilError(t, err)
+	assert.Equal(t, line, "ping\n")
+
+	local.Close()
+	remote.Close()
+	<-errCh
+}
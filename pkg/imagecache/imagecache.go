@@ -0,0 +1,72 @@
+package imagecache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// Client is implemented by the Docker and containerd clients.
+type Client interface {
+	ExportImage(ctx context.Context, image string, w io.Writer) error
+	ImportImage(ctx context.Context, r io.Reader) error
+}
+
+// Path returns the location of the cached image archive in the cache dir.
+// The image reference is normalized first, so that, e.g., busybox and
+// docker.io/library/busybox:latest share the same archive.
+func Path(dir, image string) string {
+	name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(normalizeRef(image))
+	return filepath.Join(dir, name+".tar")
+}
+
+func normalizeRef(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+// Save exports the (already pulled) image to a tar archive in the cache dir.
+func Save(ctx context.Context, client Client, image, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create image cache dir: %w", err)
+	}
+
+	// Write to a temp file first to never leave a truncated archive behind.
+	tmp, err := os.CreateTemp(dir, ".cdebug-image-*.tar")
+	if err != nil {
+		return fmt.Errorf("cannot create image archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := client.ExportImage(ctx, image, tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot export image %q: %w", image, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write image archive: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), Path(dir, image))
+}
+
+// Load imports the image from the tar archive in the cache dir.
+func Load(ctx context.Context, client Client, image, dir string) error {
+	f, err := os.Open(Path(dir, image))
+	if err != nil {
+		return fmt.Errorf("image %q is not cached: %w", image, err)
+	}
+	defer f.Close()
+
+	if err := client.ImportImage(ctx, f); err != nil {
+		return fmt.Errorf("cannot import image %q: %w", image, err)
+	}
+	return nil
+}
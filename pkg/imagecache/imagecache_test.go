@@ -0,0 +1,27 @@
+package imagecache
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "busybox", want: "/cache/busybox_latest.tar"},
+		{image: "busybox:latest", want: "/cache/busybox_latest.tar"},
+		{image: "docker.io/library/busybox:latest", want: "/cache/busybox_latest.tar"},
+		{image: "docker.io/library/busybox:musl", want: "/cache/busybox_musl.tar"},
+		{image: "ghcr.io/iximiuz/labs/nginx", want: "/cache/ghcr.io_iximiuz_labs_nginx_latest.tar"},
+		{image: "Not A Reference", want: "/cache/Not A Reference.tar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			assert.Equal(t, Path("/cache", tt.image), tt.want)
+		})
+	}
+}
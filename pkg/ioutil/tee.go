@@ -0,0 +1,83 @@
+package ioutil
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// TimestampedTee writes everything to the underlying writer and,
+// simultaneously, to the transcript file prefixing each line with
+// a timestamp. Forks share the file, so the input and output streams
+// of a session can be recorded into a single transcript.
+type TimestampedTee struct {
+	w          io.Writer
+	transcript *transcript
+	lineStart  bool
+}
+
+type transcript struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewTimestampedTee(w io.Writer, file *os.File) *TimestampedTee {
+	return &TimestampedTee{
+		w:          w,
+		transcript: &transcript{file: file},
+		lineStart:  true,
+	}
+}
+
+// Fork returns a tee writing to w and to the same transcript file.
+func (t *TimestampedTee) Fork(w io.Writer) *TimestampedTee {
+	return &TimestampedTee{
+		w:          w,
+		transcript: t.transcript,
+		lineStart:  true,
+	}
+}
+
+// Reader returns a reader recording everything read from r.
+func (t *TimestampedTee) Reader(r io.Reader) io.Reader {
+	return io.TeeReader(r, t.Fork(io.Discard))
+}
+
+func (t *TimestampedTee) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+
+	t.transcript.mu.Lock()
+	defer t.transcript.mu.Unlock()
+
+	// Recording is best effort - it must never break the session.
+	for _, line := range splitLines(p[:n]) {
+		if t.lineStart {
+			t.transcript.file.WriteString(time.Now().UTC().Format(time.RFC3339Nano) + " ")
+		}
+		t.transcript.file.Write(line)
+		t.lineStart = line[len(line)-1] == '\n'
+	}
+
+	return n, err
+}
+
+func (t *TimestampedTee) Close() error {
+	t.transcript.mu.Lock()
+	defer t.transcript.mu.Unlock()
+
+	return t.transcript.file.Close()
+}
+
+func splitLines(p []byte) (lines [][]byte) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n') + 1
+		if i == 0 {
+			i = len(p)
+		}
+		lines = append(lines, p[:i])
+		p = p[i:]
+	}
+	return
+}
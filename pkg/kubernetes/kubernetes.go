@@ -1,26 +1,45 @@
 package kubernetes
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// DefaultContext returns the kubeconfig context set via $KUBECONTEXT
+// or $KUBECTL_CONTEXT (if any).
+func DefaultContext() string {
+	if ctx := os.Getenv("KUBECONTEXT"); ctx != "" {
+		return ctx
+	}
+	return os.Getenv("KUBECTL_CONTEXT")
+}
+
 func GetRESTConfig(
 	apiServer string,
 	kubeconfig string,
 	kubeconfigContext string,
+	saTokenFile string,
+	saCAFile string,
 ) (*rest.Config, string, error) {
 	if apiServer != "" {
-		return &rest.Config{
-			Host: apiServer,
-		}, "", nil
+		config := &rest.Config{
+			Host:            apiServer,
+			BearerTokenFile: saTokenFile,
+		}
+		config.TLSClientConfig.CAFile = saCAFile
+		return config, "", nil
 	}
 
 	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
-		config, err := rest.InClusterConfig()
+		config, err := inClusterConfig(saTokenFile, saCAFile)
 		if err != nil {
 			return nil, "", fmt.Errorf("error loading in-cluster kubeconfig: %v", err)
 		}
@@ -52,3 +71,68 @@ func GetRESTConfig(
 
 	return config, namespace, nil
 }
+
+// inClusterConfig is rest.InClusterConfig() that allows the service account
+// token and CA files to be mounted at non-default paths.
+func inClusterConfig(saTokenFile, saCAFile string) (*rest.Config, error) {
+	if saTokenFile == "" && saCAFile == "" {
+		return rest.InClusterConfig()
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, rest.ErrNotInCluster
+	}
+
+	config := &rest.Config{
+		Host:            "https://" + net.JoinHostPort(host, port),
+		BearerTokenFile: saTokenFile,
+	}
+	if config.BearerTokenFile == "" {
+		config.BearerTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	}
+
+	config.TLSClientConfig.CAFile = saCAFile
+	if config.TLSClientConfig.CAFile == "" {
+		config.TLSClientConfig.CAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	}
+
+	token, err := os.ReadFile(config.BearerTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	config.BearerToken = string(token)
+
+	return config, nil
+}
+
+// ServiceAccountName extracts the "system:serviceaccount:<ns>:<name>"
+// subject from the (unverified) service account token payload.
+func ServiceAccountName(tokenFile string) (string, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(strings.TrimSpace(string(token)), ".")
+	if len(parts) != 3 {
+		return "", errors.New("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("error decoding token payload: %v", err)
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("error parsing token payload: %v", err)
+	}
+	if claims.Subject == "" {
+		return "", errors.New("token has no subject")
+	}
+
+	return claims.Subject, nil
+}
@@ -2,6 +2,7 @@ package kubernetes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	jsonpatch "github.com/evanphx/json-patch"
@@ -29,6 +30,10 @@ func Override[D any](dest D, fragment string, overrideType OverrideType) (o D, e
 		overrideType = DefaultOverrideType
 	}
 
+	if err := ValidateFragment(fragment); err != nil {
+		return o, err
+	}
+
 	switch overrideType {
 	case OverrideTypeJSON:
 		return JSONPatch(dest, fragment)
@@ -44,6 +49,23 @@ func Override[D any](dest D, fragment string, overrideType OverrideType) (o D, e
 	}
 }
 
+// ValidateFragment makes sure the override fragment is a well-formed JSON
+// document - the errors from the patching libraries are rather cryptic.
+func ValidateFragment(fragment string) error {
+	if json.Valid([]byte(fragment)) {
+		return nil
+	}
+
+	var v any
+	err := json.Unmarshal([]byte(fragment), &v)
+
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
+		return fmt.Errorf("override fragment is not valid JSON: %v (at position %d)", serr, serr.Offset)
+	}
+	return fmt.Errorf("override fragment is not valid JSON: %v", err)
+}
+
 func MergePatch[D any](dest D, fragment string) (o D, err error) {
 	target, err := json.Marshal(dest)
 	if err != nil {
@@ -101,3 +123,35 @@ func JSONPatch[D any](dest D, fragment string) (o D, err error) {
 
 	return o, nil
 }
+
+// SplitAnnotations extracts "metadata.annotations" from a (merge or strategic)
+// override fragment. Ephemeral containers have no metadata of their own, so
+// the annotations cannot be applied to the container itself and would be
+// silently dropped by the patch. The rest of the fragment is returned as is.
+func SplitAnnotations(fragment string) (string, map[string]string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(fragment), &obj); err != nil {
+		// Not an object (e.g., a JSON patch) - nothing to split.
+		return fragment, nil, nil
+	}
+
+	rawMeta, ok := obj["metadata"]
+	if !ok {
+		return fragment, nil, nil
+	}
+
+	var meta struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(rawMeta, &meta); err != nil {
+		return "", nil, fmt.Errorf("invalid override metadata: %v", err)
+	}
+
+	delete(obj, "metadata")
+	rest, err := json.Marshal(obj)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to JSON marshal override: %w", err)
+	}
+
+	return string(rest), meta.Annotations, nil
+}
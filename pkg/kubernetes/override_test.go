@@ -0,0 +1,23 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestSplitAnnotations(t *testing.T) {
+	rest, annotations, err := SplitAnnotations(`{
+		"metadata": {"annotations": {"sidecar.istio.io/inject": "false"}},
+		"env": [{"name": "DEBUG", "value": "1"}]
+	}`)
+	assert.NilError(t, err)
+	assert.Equal(t, rest, `{"env":[{"name":"DEBUG","value":"1"}]}`)
+	assert.DeepEqual(t, annotations, map[string]string{"sidecar.istio.io/inject": "false"})
+
+	patch := `[{"op": "add", "path": "/tty", "value": true}]`
+	rest, annotations, err = SplitAnnotations(patch)
+	assert.NilError(t, err)
+	assert.Equal(t, rest, patch)
+	assert.Assert(t, annotations == nil)
+}
@@ -0,0 +1,97 @@
+package ldd
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ResolveDependencies returns the absolute paths of the shared libraries
+// (including the dynamic loader) the binary needs, as reported by the host's
+// ldd. Statically linked binaries have no dependencies.
+func ResolveDependencies(path string) ([]string, error) {
+	interp, err := Interpreter(path)
+	if err != nil {
+		return nil, err
+	}
+	if interp == "" {
+		return nil, nil // Static binary.
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("ldd", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ldd %s failed: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseLddOutput(stdout.Bytes())
+}
+
+// Interpreter returns the ELF interpreter (aka dynamic loader) of the binary
+// or an empty string if the binary is statically linked.
+func Interpreter(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+
+		buf := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(buf, 0); err != nil {
+			return "", err
+		}
+		return string(bytes.TrimRight(buf, "\x00")), nil
+	}
+
+	return "", nil
+}
+
+// Lines look like:
+//
+//	linux-vdso.so.1 (0x00007ffd5a5f2000)
+//	libc.so.6 => /lib/x86_64-linux-gnu/libc.so.6 (0x00007f0e1c200000)
+//	/lib64/ld-linux-x86-64.so.2 (0x00007f0e1c5f0000)
+//	libfoo.so => not found
+func parseLddOutput(out []byte) ([]string, error) {
+	var deps []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if name, lib, ok := strings.Cut(line, "=>"); ok {
+			lib = strings.TrimSpace(lib)
+			if strings.HasPrefix(lib, "not found") {
+				return nil, fmt.Errorf("shared library %s not found", strings.TrimSpace(name))
+			}
+			if path, _, _ := strings.Cut(lib, " "); strings.HasPrefix(path, "/") {
+				deps = append(deps, path)
+			}
+			continue
+		}
+
+		// The dynamic loader or the vDSO (no path).
+		if path, _, _ := strings.Cut(line, " "); strings.HasPrefix(path, "/") {
+			deps = append(deps, path)
+		}
+	}
+
+	if len(deps) == 0 {
+		return nil, errors.New("cannot parse ldd output")
+	}
+	return deps, scanner.Err()
+}
@@ -0,0 +1,32 @@
+package schema
+
+import (
+	"strings"
+
+	"github.com/iximiuz/cdebug/pkg/cliutil"
+)
+
+const (
+	Containerd = "containerd://"
+	Docker     = "docker://"
+	KubeCRI    = "cri://"
+	KubeLong   = "kubernetes://"
+	KubeShort  = "k8s://"
+	Nerdctl    = "nerdctl://"
+	Podman     = "podman://"
+	OCI        = "oci://" // runc, crun, etc.
+)
+
+// Parse splits the [schema://][POD][CONTAINER] argument into the schema
+// and the target. The "pod/" and "pods/" prefixes imply Kubernetes, and
+// the default schema is Docker unless $CDEBUG_DEFAULT_SCHEMA is set (e.g.,
+// when cdebug runs as a kubectl plugin).
+func Parse(target string) (string, string) {
+	if sep := strings.Index(target, "://"); sep != -1 {
+		return target[:sep+3], target[sep+3:]
+	}
+	if strings.HasPrefix(target, "pod/") || strings.HasPrefix(target, "pods/") {
+		return KubeLong, target
+	}
+	return cliutil.EnvOr("CDEBUG_DEFAULT_SCHEMA", Docker), target
+}
@@ -0,0 +1,38 @@
+package schema
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantSchema string
+		wantTarget string
+	}{
+		{target: "mycontainer", wantSchema: Docker, wantTarget: "mycontainer"},
+		{target: "docker://mycontainer", wantSchema: Docker, wantTarget: "mycontainer"},
+		{target: "containerd://abc123", wantSchema: Containerd, wantTarget: "abc123"},
+		{target: "k8s://mypod/app", wantSchema: KubeShort, wantTarget: "mypod/app"},
+		{target: "pod/mypod", wantSchema: KubeLong, wantTarget: "pod/mypod"},
+		{target: "pods/mypod/app", wantSchema: KubeLong, wantTarget: "pods/mypod/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			schema, target := Parse(tt.target)
+			assert.Equal(t, schema, tt.wantSchema)
+			assert.Equal(t, target, tt.wantTarget)
+		})
+	}
+}
+
+func TestParseDefaultSchema(t *testing.T) {
+	t.Setenv("CDEBUG_DEFAULT_SCHEMA", KubeLong)
+
+	schema, target := Parse("mypod")
+	assert.Equal(t, schema, KubeLong)
+	assert.Equal(t, target, "mypod")
+}
@@ -0,0 +1,33 @@
+package socketutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CheckAccessible makes sure the unix socket exists and the current user
+// can connect to it. The errors are meant to be shown to the user as is.
+func CheckAccessible(sockfile string) error {
+	abs, err := filepath.Abs(sockfile)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(abs)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("socket file not found: %s", abs)
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("permission denied accessing: %s (try sudo)", abs)
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("not a unix socket: %s", abs)
+	}
+
+	return checkAccess(abs, info)
+}
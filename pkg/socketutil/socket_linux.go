@@ -0,0 +1,37 @@
+//go:build linux
+
+package socketutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func checkAccess(sockfile string, info os.FileInfo) error {
+	// Shamelessly borrowed from nerdctl:
+	// > set AT_EACCESS to allow running nerdctl as a setuid binary
+	err := unix.Faccessat(-1, sockfile, unix.R_OK|unix.W_OK, unix.AT_EACCESS)
+	if errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
+		return fmt.Errorf("permission denied accessing: %s (%s)", sockfile, accessHint(info))
+	}
+	return err
+}
+
+func accessHint(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Gid == 0 {
+		return "try sudo"
+	}
+
+	group, err := user.LookupGroupId(strconv.FormatUint(uint64(stat.Gid), 10))
+	if err != nil {
+		return "try sudo"
+	}
+	return fmt.Sprintf("try sudo or add user to '%s' group", group.Name)
+}
@@ -0,0 +1,27 @@
+package socketutil
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCheckAccessible(t *testing.T) {
+	dir := t.TempDir()
+
+	sock := filepath.Join(dir, "test.sock")
+	ln, err := net.Listen("unix", sock)
+	assert.NilError(t, err)
+	defer ln.Close()
+	assert.NilError(t, CheckAccessible(sock))
+
+	missing := filepath.Join(dir, "missing.sock")
+	assert.Error(t, CheckAccessible(missing), "socket file not found: "+missing)
+
+	regular := filepath.Join(dir, "regular")
+	assert.NilError(t, os.WriteFile(regular, nil, 0o600))
+	assert.Error(t, CheckAccessible(regular), "not a unix socket: "+regular)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 
 	"github.com/docker/cli/cli/streams"
@@ -20,37 +21,103 @@ func StartResizing(
 	client dockerclient.ContainerAPIClient,
 	contID string,
 ) {
-	go func() {
-		for retry := 0; retry < 10; retry++ {
-			if err := resize(ctx, out, client, contID); err == nil {
-				return
-			}
-			time.Sleep(time.Duration(retry+1) * 10 * time.Millisecond)
-		}
-		logrus.Warn("Cannot resize TTY")
-	}()
+	startResizing(ctx, out, containerResizer(client, contID))
+}
+
+// StartResizingExec is StartResizing for an exec session's TTY.
+func StartResizingExec(
+	ctx context.Context,
+	out *streams.Out,
+	client dockerclient.ContainerAPIClient,
+	execID string,
+) {
+	startResizing(ctx, out, execResizer(client, execID))
+}
+
+// SetSize resizes the container's TTY once to the given size (e.g., when
+// there is no real terminal to take the size from).
+func SetSize(
+	ctx context.Context,
+	client dockerclient.ContainerAPIClient,
+	contID string,
+	height uint,
+	width uint,
+) {
+	go resizeWithRetries(ctx, containerResizer(client, contID), fixedSize(height, width))
+}
+
+// SetExecSize is SetSize for an exec session's TTY.
+func SetExecSize(
+	ctx context.Context,
+	client dockerclient.ContainerAPIClient,
+	execID string,
+	height uint,
+	width uint,
+) {
+	go resizeWithRetries(ctx, execResizer(client, execID), fixedSize(height, width))
+}
+
+type resizer func(ctx context.Context, options container.ResizeOptions) error
+
+func containerResizer(client dockerclient.ContainerAPIClient, contID string) resizer {
+	return func(ctx context.Context, options container.ResizeOptions) error {
+		return client.ContainerResize(ctx, contID, options)
+	}
+}
+
+func execResizer(client dockerclient.ContainerAPIClient, execID string) resizer {
+	return func(ctx context.Context, options container.ResizeOptions) error {
+		return client.ContainerExecResize(ctx, execID, options)
+	}
+}
+
+func fixedSize(height uint, width uint) func() (uint, uint) {
+	return func() (uint, uint) {
+		return height, width
+	}
+}
+
+func startResizing(
+	ctx context.Context,
+	out *streams.Out,
+	resizeFn resizer,
+) {
+	go resizeWithRetries(ctx, resizeFn, out.GetTtySize)
 
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, mobysignal.SIGWINCH)
 	go func() {
 		for range sigchan {
-			resize(ctx, out, client, contID)
+			resize(ctx, resizeFn, out.GetTtySize)
 		}
 	}()
 }
 
+func resizeWithRetries(
+	ctx context.Context,
+	resizeFn resizer,
+	size func() (uint, uint),
+) {
+	for retry := 0; retry < 10; retry++ {
+		if err := resize(ctx, resizeFn, size); err == nil {
+			return
+		}
+		time.Sleep(time.Duration(retry+1) * 10 * time.Millisecond)
+	}
+	logrus.Warn("Cannot resize TTY")
+}
+
 func resize(
 	ctx context.Context,
-	out *streams.Out,
-	client dockerclient.ContainerAPIClient,
-	contID string,
+	resizeFn resizer,
+	size func() (uint, uint),
 ) error {
-	height, width := out.GetTtySize()
+	height, width := size()
 	if height == 0 && width == 0 {
 		return nil
 	}
 
-	if err := client.ContainerResize(ctx, contID, container.ResizeOptions{Height: height, Width: width}); err != nil {
+	if err := resizeFn(ctx, container.ResizeOptions{Height: height, Width: width}); err != nil {
 		logrus.WithError(err).Debug("TTY resize error")
 		return err
 	}
@@ -59,30 +126,80 @@ func resize(
 }
 
 type ResizeQueue struct {
-	ctx context.Context
-	out *streams.Out
-	ch  chan os.Signal
+	ctx  context.Context
+	out  *streams.Out
+	ch   chan os.Signal
+	done chan struct{}
+	once sync.Once
+
+	// Reported if there is no out to take the size from (NewFixedSizeQueue).
+	height uint
+	width  uint
 }
 
 var _ remotecommand.TerminalSizeQueue = &ResizeQueue{}
 
 func NewResizeQueue(ctx context.Context, out *streams.Out) *ResizeQueue {
 	return &ResizeQueue{
-		ctx: ctx,
-		out: out,
-		ch:  make(chan os.Signal, 100),
+		ctx:  ctx,
+		out:  out,
+		ch:   make(chan os.Signal, 100),
+		done: make(chan struct{}),
+	}
+}
+
+// NewFixedSizeQueue returns a queue reporting the given size once - the
+// terminal's SIGWINCH-s are ignored.
+func NewFixedSizeQueue(ctx context.Context, height uint, width uint) *ResizeQueue {
+	return &ResizeQueue{
+		ctx:    ctx,
+		ch:     make(chan os.Signal, 1),
+		done:   make(chan struct{}),
+		height: height,
+		width:  width,
 	}
 }
 
 func (r *ResizeQueue) Start() {
-	signal.Notify(r.ch, mobysignal.SIGWINCH)
+	if r.out != nil {
+		signal.Notify(r.ch, mobysignal.SIGWINCH)
+	}
 	r.ch <- mobysignal.SIGWINCH // send a dummy signal to trigger the first resize
 }
 
+// Stop unregisters the SIGWINCH handler and unblocks the pending Next()
+// call (if any). It's safe to call Stop more than once.
+func (r *ResizeQueue) Stop() {
+	r.once.Do(func() {
+		signal.Stop(r.ch) // no more sends to r.ch after this returns
+		close(r.done)
+		close(r.ch)
+	})
+}
+
+// Done is closed when the queue is stopped.
+func (r *ResizeQueue) Done() <-chan struct{} {
+	return r.done
+}
+
+// Next returns nil when the queue is stopped or the context is cancelled,
+// which makes the remotecommand's resize goroutine exit.
 func (r *ResizeQueue) Next() *remotecommand.TerminalSize {
-	<-r.ch
+	select {
+	case _, ok := <-r.ch:
+		if !ok {
+			return nil
+		}
+	case <-r.done:
+		return nil
+	case <-r.ctx.Done():
+		return nil
+	}
 
-	height, width := r.out.GetTtySize()
+	height, width := r.height, r.width
+	if r.out != nil {
+		height, width = r.out.GetTtySize()
+	}
 	return &remotecommand.TerminalSize{
 		Height: uint16(height),
 		Width:  uint16(width),
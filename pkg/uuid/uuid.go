@@ -1,11 +1,28 @@
 package uuid
 
 import (
+	_ "embed"
+	"math/rand"
 	"strings"
 
 	"github.com/google/uuid"
 )
 
+var (
+	//go:embed adjectives.txt
+	adjectivesList string
+	adjectives     = strings.Fields(adjectivesList)
+
+	//go:embed nouns.txt
+	nounsList string
+	nouns     = strings.Fields(nounsList)
+)
+
 func ShortID() string {
 	return strings.Split(uuid.NewString(), "-")[0]
 }
+
+// PseudorandomName returns a human-friendly name like "cdebug-happy-panda".
+func PseudorandomName() string {
+	return "cdebug-" + adjectives[rand.Intn(len(adjectives))] + "-" + nouns[rand.Intn(len(nouns))]
+}